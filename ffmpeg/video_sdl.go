@@ -12,6 +12,7 @@ import (
 	"image"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -138,9 +139,21 @@ type VideoFrame struct {
 	bgrBuffer *C.uint8_t
 	NV12      *NV12
 	YUV       *YUV
+
+	// refs 额外持有者的数量，多个窗口共享同一帧时由 Share 设置
+	refs int32
+}
+
+// Share 增加 n 个持有者，每个持有者各调用一次 Free，最后一次才真正释放
+func (self *VideoFrame) Share(n int) {
+	atomic.AddInt32(&self.refs, int32(n))
 }
 
 func (self *VideoFrame) Free() {
+	if atomic.AddInt32(&self.refs, -1) >= 0 {
+		return
+	}
+
 	if self.Mat != nil {
 		self.Mat.Close()
 		self.Mat = nil
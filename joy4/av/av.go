@@ -261,6 +261,7 @@ type Packet struct {
 	Time            time.Duration // packet decode time
 	Data            []byte        // packet data
 	ExtraData       []byte        // data array for extra data, e.g: SEI
+	WallClock       time.Time     // absolute capture time, zero if the demuxer does not know it
 }
 
 // Raw audio frame.
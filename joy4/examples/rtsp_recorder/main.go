@@ -10,8 +10,9 @@ import (
 	"videoplayer/joy4/av"
 	"videoplayer/joy4/av/avutil"
 	"videoplayer/joy4/format"
-	"videoplayer/joy4/format/rtsp"
 	"videoplayer/joy4/log"
+	"videoplayer/rtsp"
+	"videoplayer/transport"
 )
 
 func init() {
@@ -32,14 +33,20 @@ func main() {
 		log.DefaultStandardLogger.Level = log.DEBUG
 	}
 
-	src, err := rtsp.Dial(*srcfile)
+	trans, err := transport.NewTCPTransport(*srcfile)
+	if err != nil {
+		panic(err)
+	}
+	if err = trans.Connect(); err != nil {
+		panic(err)
+	}
+	src, err := rtsp.NewClient(*srcfile, trans)
 	if err != nil {
 		panic(err)
 	}
 	defer src.Close()
 	src.UseUDP = !*useTCP
 	src.RtpTimeout = 5 * time.Second
-	src.RtspTimeout = 5 * time.Second
 	if *dstfile == "" {
 		for {
 			pkt, err := src.ReadPacket()
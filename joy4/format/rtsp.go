@@ -0,0 +1,50 @@
+package format
+
+import (
+	"strings"
+
+	"videoplayer/joy4/av"
+	"videoplayer/joy4/av/avutil"
+	"videoplayer/rtsp"
+	"videoplayer/transport"
+)
+
+// rtspHandler lets avutil.Open play rtsp:// urls, with the client in
+// videoplayer/rtsp over TCP interleaved RTP. It lives here rather than in
+// format/rtsp, which the client's tests import for its server.
+func rtspHandler(h *avutil.RegisterHandler) {
+	h.UrlDemuxer = func(uri string) (ok bool, demuxer av.DemuxCloser, err error) {
+		if !strings.HasPrefix(uri, "rtsp://") {
+			return
+		}
+		ok = true
+		demuxer, err = dialRTSP(uri)
+		return
+	}
+}
+
+// rtspClient ends the session with a TEARDOWN when closed.
+type rtspClient struct {
+	*rtsp.Client
+}
+
+func (self rtspClient) Close() error {
+	self.Teardown()
+	return self.Client.Close()
+}
+
+func dialRTSP(uri string) (av.DemuxCloser, error) {
+	trans, err := transport.NewTCPTransport(uri)
+	if err != nil {
+		return nil, err
+	}
+	if err = trans.Connect(); err != nil {
+		return nil, err
+	}
+	cli, err := rtsp.NewClient(uri, trans)
+	if err != nil {
+		trans.Disconnect()
+		return nil, err
+	}
+	return rtspClient{cli}, nil
+}
@@ -0,0 +1,366 @@
+// Package auth implements RTSP client authentication: Basic and RFC 7616
+// Digest (MD5, SHA-256 and their -sess variants, qop=auth, userhash), with
+// credentials taken from the url or from a store keyed by host.
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNoCredentials is returned when a server asks for authentication and
+	// neither the url nor the store has credentials for it.
+	ErrNoCredentials = errors.New("rtsp: no credentials")
+	// ErrRejected is returned when the server answers 401 to credentials
+	// that were already sent and the nonce is not stale.
+	ErrRejected = errors.New("rtsp: credentials rejected")
+	// ErrUnsupported is returned when no challenge uses a supported scheme
+	// or algorithm.
+	ErrUnsupported = errors.New("rtsp: unsupported authentication challenge")
+)
+
+// MaxAttempts bounds the requests sent for one RTSP method: without
+// credentials, with them, and once more after a stale nonce.
+const MaxAttempts = 3
+
+// Credentials are a user name and password for an RTSP server.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Store holds credentials keyed by host or host:port, it is safe for
+// concurrent use.
+type Store struct {
+	mu    sync.RWMutex
+	creds map[string]Credentials
+}
+
+// DefaultStore is used by the clients when they are not given a store.
+var DefaultStore = NewStore()
+
+func NewStore() *Store {
+	return &Store{creds: make(map[string]Credentials)}
+}
+
+// Set stores credentials for host, either a host name or host:port.
+func (s *Store) Set(host string, cred Credentials) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds[strings.ToLower(host)] = cred
+}
+
+func (s *Store) Delete(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.creds, strings.ToLower(host))
+}
+
+// Lookup returns the credentials for host:port, falling back to the ones
+// stored for the bare host name.
+func (s *Store) Lookup(hostport string) (Credentials, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hostport = strings.ToLower(hostport)
+	if cred, ok := s.creds[hostport]; ok {
+		return cred, true
+	}
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return Credentials{}, false
+	}
+	cred, ok := s.creds[host]
+	return cred, ok
+}
+
+// FromURL returns the credentials in the userinfo of u, or the ones stored
+// for its host. A nil store means DefaultStore.
+func FromURL(u *url.URL, store *Store) (Credentials, bool) {
+	if u.User != nil {
+		password, _ := u.User.Password()
+		return Credentials{Username: u.User.Username(), Password: password}, true
+	}
+	if store == nil {
+		store = DefaultStore
+	}
+	return store.Lookup(u.Host)
+}
+
+// Challenge is one parsed WWW-Authenticate header.
+type Challenge struct {
+	// Scheme is "Basic" or "Digest"
+	Scheme    string
+	Realm     string
+	Nonce     string
+	Opaque    string
+	Algorithm string
+	Qop       []string
+	Stale     bool
+	Userhash  bool
+}
+
+// ParseChallenge parses a WWW-Authenticate header value, quoted values may
+// contain commas and escaped quotes.
+func ParseChallenge(header string) (Challenge, error) {
+	header = strings.TrimSpace(header)
+	scheme, rest := header, ""
+	if i := strings.IndexAny(header, " \t"); i >= 0 {
+		scheme, rest = header[:i], header[i+1:]
+	}
+	if scheme == "" {
+		return Challenge{}, fmt.Errorf("rtsp: empty authentication challenge")
+	}
+	ch := Challenge{Scheme: scheme}
+	params, err := parseParams(rest)
+	if err != nil {
+		return Challenge{}, err
+	}
+	for key, val := range params {
+		switch key {
+		case "realm":
+			ch.Realm = val
+		case "nonce":
+			ch.Nonce = val
+		case "opaque":
+			ch.Opaque = val
+		case "algorithm":
+			ch.Algorithm = val
+		case "qop":
+			for _, qop := range strings.Split(val, ",") {
+				if qop = strings.TrimSpace(qop); qop != "" {
+					ch.Qop = append(ch.Qop, qop)
+				}
+			}
+		case "stale":
+			ch.Stale = strings.EqualFold(val, "true")
+		case "userhash":
+			ch.Userhash = strings.EqualFold(val, "true")
+		}
+	}
+	return ch, nil
+}
+
+func parseParams(s string) (map[string]string, error) {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params, nil
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("rtsp: malformed authentication parameter %q", s)
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		var val string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, fmt.Errorf("rtsp: unterminated quoted value for %v", key)
+			}
+			val, s = b.String(), s[i+1:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			val, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+		params[key] = val
+	}
+}
+
+// hashFunc returns the hash of a digest algorithm and whether it is a
+// session variant, an empty algorithm means MD5.
+func hashFunc(algorithm string) (func() hash.Hash, bool, bool) {
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5":
+		return md5.New, false, true
+	case "MD5-SESS":
+		return md5.New, true, true
+	case "SHA-256":
+		return sha256.New, false, true
+	case "SHA-256-SESS":
+		return sha256.New, true, true
+	}
+	return nil, false, false
+}
+
+// strength ranks supported challenges, 0 means unsupported.
+func (c Challenge) strength() int {
+	switch {
+	case strings.EqualFold(c.Scheme, "Basic"):
+		return 1
+	case strings.EqualFold(c.Scheme, "Digest"):
+		h, _, ok := hashFunc(c.Algorithm)
+		if !ok || c.Nonce == "" {
+			return 0
+		}
+		if len(c.Qop) > 0 && !c.hasQop("auth") && !c.hasQop("auth-int") {
+			return 0
+		}
+		if h().Size() > md5.Size {
+			return 3
+		}
+		return 2
+	}
+	return 0
+}
+
+func (c Challenge) hasQop(qop string) bool {
+	for _, q := range c.Qop {
+		if strings.EqualFold(q, qop) {
+			return true
+		}
+	}
+	return false
+}
+
+// Select parses the WWW-Authenticate headers of a 401 and returns the
+// strongest supported challenge: Digest SHA-256, then Digest MD5, then Basic.
+func Select(headers []string) (Challenge, error) {
+	var best Challenge
+	for _, header := range headers {
+		ch, err := ParseChallenge(header)
+		if err != nil {
+			continue
+		}
+		if ch.strength() > best.strength() {
+			best = ch
+		}
+	}
+	if best.strength() == 0 {
+		return Challenge{}, ErrUnsupported
+	}
+	return best, nil
+}
+
+// Authenticator builds Authorization headers answering a challenge, it is
+// safe for concurrent use.
+type Authenticator struct {
+	cred Credentials
+
+	mu        sync.Mutex
+	challenge Challenge
+	nc        uint32
+	cnonce    string
+}
+
+func NewAuthenticator(cred Credentials, ch Challenge) (*Authenticator, error) {
+	if ch.strength() == 0 {
+		return nil, ErrUnsupported
+	}
+	return &Authenticator{cred: cred, challenge: ch}, nil
+}
+
+// Update takes the challenge of a 401 answered to an authenticated request.
+// A stale nonce is replaced and the request can be retried, otherwise the
+// credentials were rejected.
+func (a *Authenticator) Update(ch Challenge) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !ch.Stale || ch.strength() == 0 {
+		return ErrRejected
+	}
+	a.challenge = ch
+	a.nc = 0
+	a.cnonce = ""
+	return nil
+}
+
+// Authorization returns the Authorization header value for a request.
+func (a *Authenticator) Authorization(method, uri string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ch := a.challenge
+	if strings.EqualFold(ch.Scheme, "Basic") {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(a.cred.Username+":"+a.cred.Password))
+	}
+
+	newHash, sess, _ := hashFunc(ch.Algorithm)
+	h := func(s string) string {
+		hh := newHash()
+		hh.Write([]byte(s))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+
+	qop := ""
+	if ch.hasQop("auth") {
+		qop = "auth"
+	} else if ch.hasQop("auth-int") {
+		qop = "auth-int"
+	}
+	if (qop != "" || sess) && a.cnonce == "" {
+		a.cnonce = newCnonce()
+	}
+	a.nc++
+	nc := fmt.Sprintf("%08x", a.nc)
+
+	ha1 := h(a.cred.Username + ":" + ch.Realm + ":" + a.cred.Password)
+	if sess {
+		ha1 = h(ha1 + ":" + ch.Nonce + ":" + a.cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+	if qop == "auth-int" {
+		// requests carry no body
+		ha2 = h(method + ":" + uri + ":" + h(""))
+	}
+	var response string
+	if qop != "" {
+		response = h(ha1 + ":" + ch.Nonce + ":" + nc + ":" + a.cnonce + ":" + qop + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + ch.Nonce + ":" + ha2)
+	}
+
+	username := a.cred.Username
+	if ch.Userhash {
+		username = h(a.cred.Username + ":" + ch.Realm)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%s, realm=%s, nonce=%s, uri=%s, response=%s`,
+		quote(username), quote(ch.Realm), quote(ch.Nonce), quote(uri), quote(response))
+	if ch.Algorithm != "" {
+		fmt.Fprintf(&b, ", algorithm=%s", ch.Algorithm)
+	}
+	if qop != "" {
+		fmt.Fprintf(&b, ", qop=%s, nc=%s, cnonce=%s", qop, nc, quote(a.cnonce))
+	} else if sess {
+		fmt.Fprintf(&b, ", cnonce=%s", quote(a.cnonce))
+	}
+	if ch.Opaque != "" {
+		fmt.Fprintf(&b, ", opaque=%s", quote(ch.Opaque))
+	}
+	if ch.Userhash {
+		b.WriteString(", userhash=true")
+	}
+	return b.String()
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func newCnonce() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// rfc7616Challenge is the example of RFC 7616 section 3.9.1.
+func rfc7616Challenge(algorithm string) Challenge {
+	return Challenge{
+		Scheme:    "Digest",
+		Realm:     "http-auth@example.org",
+		Nonce:     "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+		Opaque:    "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS",
+		Algorithm: algorithm,
+		Qop:       []string{"auth", "auth-int"},
+	}
+}
+
+func TestDigestRFC7616(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		response  string
+	}{
+		{"MD5", "8ca523f5e9506fed4657c9700eebdbec"},
+		{"SHA-256", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	}
+	for _, tt := range tests {
+		a, err := NewAuthenticator(Credentials{"Mufasa", "Circle of Life"}, rfc7616Challenge(tt.algorithm))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.cnonce = "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+		got := a.Authorization("GET", "/dir/index.html")
+		for _, want := range []string{
+			`username="Mufasa"`,
+			`response="` + tt.response + `"`,
+			"algorithm=" + tt.algorithm,
+			"qop=auth, nc=00000001",
+			`opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`,
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("%v: %q missing %q", tt.algorithm, got, want)
+			}
+		}
+		if got := a.Authorization("GET", "/dir/index.html"); !strings.Contains(got, "nc=00000002") {
+			t.Errorf("%v: nonce count not incremented: %q", tt.algorithm, got)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	ch, err := ParseChallenge(`Digest realm="cam, inc", qop="auth,auth-int", nonce="a\"b", algorithm=SHA-256, stale=TRUE`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.Scheme != "Digest" || ch.Realm != "cam, inc" || ch.Nonce != `a"b` || ch.Algorithm != "SHA-256" || !ch.Stale {
+		t.Errorf("unexpected challenge %+v", ch)
+	}
+	if len(ch.Qop) != 2 || ch.Qop[0] != "auth" || ch.Qop[1] != "auth-int" {
+		t.Errorf("qop = %v", ch.Qop)
+	}
+	if _, err := ParseChallenge(`Digest realm="unterminated`); err == nil {
+		t.Error("expected error for unterminated quote")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	ch, err := Select([]string{
+		`Basic realm="cam"`,
+		`Digest realm="cam", nonce="n1", algorithm=MD5`,
+		`Digest realm="cam", nonce="n2", algorithm=SHA-256, qop="auth"`,
+		`Digest realm="cam", nonce="n3", algorithm=SHA-512-256`,
+	})
+	if err != nil || ch.Nonce != "n2" {
+		t.Errorf("Select = %+v, %v, want SHA-256 challenge", ch, err)
+	}
+	if ch, _ := Select([]string{`Basic realm="cam"`}); ch.Scheme != "Basic" {
+		t.Errorf("Select = %+v, want Basic", ch)
+	}
+	if _, err := Select([]string{`Bearer realm="cam"`}); err != ErrUnsupported {
+		t.Errorf("Select error = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestBasic(t *testing.T) {
+	a, err := NewAuthenticator(Credentials{"admin", "12345"}, Challenge{Scheme: "Basic", Realm: "cam"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Authorization("DESCRIBE", "rtsp://cam/live"); got != "Basic YWRtaW46MTIzNDU=" {
+		t.Errorf("Authorization = %q", got)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	a, _ := NewAuthenticator(Credentials{"admin", "12345"}, Challenge{Scheme: "Digest", Realm: "cam", Nonce: "old", Qop: []string{"auth"}})
+	a.Authorization("OPTIONS", "rtsp://cam/live")
+	if err := a.Update(Challenge{Scheme: "Digest", Realm: "cam", Nonce: "other"}); err != ErrRejected {
+		t.Errorf("Update without stale = %v, want ErrRejected", err)
+	}
+	if err := a.Update(Challenge{Scheme: "Digest", Realm: "cam", Nonce: "new", Qop: []string{"auth"}, Stale: true}); err != nil {
+		t.Fatal(err)
+	}
+	got := a.Authorization("OPTIONS", "rtsp://cam/live")
+	if !strings.Contains(got, `nonce="new"`) || !strings.Contains(got, "nc=00000001") {
+		t.Errorf("Authorization after stale = %q", got)
+	}
+}
+
+func TestFromURL(t *testing.T) {
+	store := NewStore()
+	store.Set("cam.local", Credentials{"host", "h"})
+	store.Set("cam.local:8554", Credentials{"port", "p"})
+
+	tests := []struct {
+		url  string
+		want string
+		ok   bool
+	}{
+		{"rtsp://user:pw@cam.local/live", "user", true},
+		{"rtsp://CAM.local:8554/live", "port", true},
+		{"rtsp://cam.local:554/live", "host", true},
+		{"rtsp://other.local:554/live", "", false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		cred, ok := FromURL(u, store)
+		if ok != tt.ok || cred.Username != tt.want {
+			t.Errorf("FromURL(%v) = %v %v, want %v %v", tt.url, cred.Username, ok, tt.want, tt.ok)
+		}
+	}
+}
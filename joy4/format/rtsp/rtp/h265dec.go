@@ -284,7 +284,7 @@ func (h *H265DynamicProtocol) ParseSDP(sdp *sdp.Media) error {
 				continue
 			}
 			h.sei = val
-		} else if k == "sprop-max-don-diff" || k == "sprop-max-don-diff" {
+		} else if k == "sprop-max-don-diff" || k == "sprop-depack-buf-nalus" {
 			/* sprop-max-don-diff: 0-32767
 			   When the RTP stream depends on one or more other RTP
 			   streams (in this case tx-mode MUST be equal to "MSM" and
@@ -0,0 +1,17 @@
+package rtp
+
+import (
+	"testing"
+
+	"videoplayer/joy4/format/rtsp/sdp"
+)
+
+func TestH265DONLFromSDP(t *testing.T) {
+	for _, k := range []string{"sprop-max-don-diff", "sprop-depack-buf-nalus"} {
+		h := NewH265DynamicProtocol(nil)
+		h.ParseSDP(&sdp.Media{ALines: map[string]string{k: "2"}})
+		if !h.usingDonlField {
+			t.Errorf("%s=2 does not enable the DONL field", k)
+		}
+	}
+}
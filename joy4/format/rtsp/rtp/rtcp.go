@@ -1,5 +1,7 @@
 package rtp
 
+import "time"
+
 const (
 	RTCP_FIR     = iota + 192
 	RTCP_NACK    // 193
@@ -51,6 +53,17 @@ type RtcpPacketBYE struct {
 	SSRC    uint32
 }
 
+// ntpEpochOffset is the number of seconds from 1900-01-01 (NTP epoch) to 1970-01-01.
+const ntpEpochOffset = 2208988800
+
+// NTPTime converts a 64 bit NTP timestamp, as carried in RTCP sender reports,
+// to a time.Time.
+func NTPTime(ntp uint64) time.Time {
+	sec := int64(ntp>>32) - ntpEpochOffset
+	nsec := (ntp & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(sec, int64(nsec))
+}
+
 func rtpPTIsRtcp(b byte) bool {
 	return (b >= RTCP_FIR && b <= RTCP_IJ) ||
 		b >= RTCP_SR && b <= RTCP_TOKEN
@@ -0,0 +1,48 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestNTPTime(t *testing.T) {
+	want := time.Date(2024, 1, 2, 3, 4, 5, int(500*time.Millisecond), time.UTC)
+	ntp := uint64(want.Unix()+ntpEpochOffset)<<32 | 1<<31
+	if got := NTPTime(ntp); !got.Equal(want) {
+		t.Errorf("NTPTime = %v, want %v", got, want)
+	}
+}
+
+func TestWallClock(t *testing.T) {
+	s := NewRTPDemuxContext(96, 0)
+	s.TimeScale = 90000
+
+	// before any sender report the first arrival anchors the stream
+	before := time.Now()
+	first := s.wallClock(1000)
+	if first.Before(before) || first.After(time.Now()) {
+		t.Fatalf("first wall clock %v not the arrival time", first)
+	}
+	if got := s.wallClock(1000 + 90000); got.Sub(first) != time.Second {
+		t.Errorf("arrival based wall clock advanced %v, want 1s", got.Sub(first))
+	}
+
+	srTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sr := make([]byte, 28)
+	sr[0] = RTP_VERSION << 6
+	sr[1] = RTCP_SR
+	binary.BigEndian.PutUint16(sr[2:], 6)
+	binary.BigEndian.PutUint64(sr[8:], uint64(srTime.Unix()+ntpEpochOffset)<<32)
+	srTimestamp := uint32(0xfffffff0)
+	binary.BigEndian.PutUint32(sr[16:], srTimestamp)
+	s.rtcpParsePacket(sr)
+
+	// RTP timestamps wrap around after the sender report
+	if got := s.wallClock(srTimestamp + 45000); !got.Equal(srTime.Add(500 * time.Millisecond)) {
+		t.Errorf("wall clock after wrap = %v", got)
+	}
+	if got := s.wallClock(srTimestamp - 9000); !got.Equal(srTime.Add(-100 * time.Millisecond)) {
+		t.Errorf("wall clock before sender report = %v", got)
+	}
+}
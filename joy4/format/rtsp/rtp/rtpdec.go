@@ -33,6 +33,31 @@ type RTPStatistics struct {
 	Transit uint32
 	// estimated jitter.
 	Jitter uint32
+	// duplicate, reordered or too late packets
+	OutOfOrder uint32
+	// RTP bytes received, headers included
+	Bytes uint64
+}
+
+// Stats is a snapshot of the reception statistics of one RTP stream.
+type Stats struct {
+	Received   uint32
+	Expected   uint32
+	Lost       int64
+	OutOfOrder uint32
+	Bytes      uint64
+	Jitter     time.Duration
+	// SenderClock is set once a sender report maps the RTP timestamps to the
+	// sender's wall clock, before that packet WallClock is the arrival time.
+	SenderClock bool
+}
+
+// LossPercent is the share of expected packets that never arrived.
+func (st Stats) LossPercent() float64 {
+	if st.Expected == 0 || st.Lost <= 0 {
+		return 0
+	}
+	return float64(st.Lost) * 100 / float64(st.Expected)
 }
 
 type RTPPacket struct {
@@ -64,6 +89,11 @@ type RTPDemuxContext struct {
 	lastRtcpTimestamp     uint32
 	rtcpTsOffset          int64
 
+	// arrival time and RTP timestamp of the first packet, used for the wall
+	// clock until the first sender report arrives
+	arrivalBase      time.Time
+	arrivalTimestamp uint32
+
 	prevRet int
 	queue   []RTPPacket
 
@@ -98,8 +128,14 @@ func newRTPStatistics(baseSeq uint16) *RTPStatistics {
 
 func (s *RTPStatistics) init(seq uint16) {
 	s.MaxSeq = seq
+	s.Cycles = 0
 	s.BaseSeq = uint32(seq - 1)
 	s.BadSeq = RTP_SEQ_MOD + 1
+	s.Received = 0
+	s.ExpectedPrior = 0
+	s.ReceivedPrior = 0
+	s.Jitter = 0
+	s.Transit = 0
 }
 
 func (s *RTPStatistics) validPacketInSequence(seq uint16) bool {
@@ -142,6 +178,7 @@ func (s *RTPStatistics) validPacketInSequence(seq uint16) bool {
 		}
 	} else {
 		// duplicate or reordered packet...
+		s.OutOfOrder++
 	}
 	s.Received++
 	return true
@@ -219,7 +256,28 @@ func NewRTPDemuxContext(payloadType int, queueSize int) *RTPDemuxContext {
 
 }
 
+// wallClock maps an RTP timestamp to its absolute capture time. The NTP/RTP
+// pair of the last sender report is used when available, before that the
+// arrival time of the first packet anchors the stream.
+func (s *RTPDemuxContext) wallClock(timestamp uint32) time.Time {
+	if s.TimeScale <= 0 {
+		return time.Now()
+	}
+	base, baseTimestamp := s.arrivalBase, s.arrivalTimestamp
+	if s.lastRtcpNtpTime != AV_NOPTS_VALUE {
+		base, baseTimestamp = NTPTime(uint64(s.lastRtcpNtpTime)), s.lastRtcpTimestamp
+	} else if base.IsZero() {
+		s.arrivalBase, s.arrivalTimestamp = time.Now(), timestamp
+		return s.arrivalBase
+	}
+	delta := int64(int32(timestamp - baseTimestamp))
+	return base.Add(time.Duration(av.Rescale(delta, int64(time.Second), int64(s.TimeScale))))
+}
+
 func (s *RTPDemuxContext) finalizePacket(pkt *av.Packet, timestamp uint32) {
+	if timestamp != RTP_NOTS_VALUE {
+		pkt.WallClock = s.wallClock(timestamp)
+	}
 	// if (pkt.Time != AV_NOPTS_VALUE || pkt->dts != AV_NOPTS_VALUE)
 	//         return; /* Timestamp already set by depacketizer */
 	if pkt.Time != time.Duration(AV_NOPTS_VALUE) {
@@ -444,6 +502,7 @@ func (s *RTPDemuxContext) rtpParseOnePacket(pkt *av.Packet, buf []byte) int {
 		return s.rtcpParsePacket(buf)
 	}
 
+	s.statistics.Bytes += uint64(len(buf))
 	if s.TimeScale != 0 {
 		received := relativeTime()
 		arrivalTs := av.Rescale(received, int64(s.TimeScale), AV_TIME_BASE)
@@ -462,6 +521,7 @@ func (s *RTPDemuxContext) rtpParseOnePacket(pkt *av.Packet, buf []byte) int {
 		if diff < 0 {
 			/* Packet older than the previously emitted one, drop */
 			log.Log(log.WARN, "rtp: dropping old packet received too late")
+			s.statistics.OutOfOrder++
 			return -1
 		} else if diff <= 1 {
 			/* Correct packet */
@@ -486,6 +546,28 @@ func (s *RTPDemuxContext) rtpParseOnePacket(pkt *av.Packet, buf []byte) int {
 	}
 }
 
+// Stats returns the reception statistics, it must be called from the goroutine
+// feeding RtpParsePacket.
+func (s *RTPDemuxContext) Stats() Stats {
+	stats := s.statistics
+	st := Stats{
+		Received:   stats.Received,
+		OutOfOrder: stats.OutOfOrder,
+		Bytes:      stats.Bytes,
+
+		SenderClock: s.lastRtcpNtpTime != AV_NOPTS_VALUE,
+	}
+	if stats.Received > 0 {
+		st.Expected = stats.Cycles + uint32(stats.MaxSeq) - stats.BaseSeq
+		st.Lost = int64(st.Expected) - int64(stats.Received)
+	}
+	if s.TimeScale > 0 {
+		// the jitter estimate is kept scaled by 16, see RFC 3550 A.8
+		st.Jitter = time.Duration(av.Rescale(int64(stats.Jitter>>4), int64(time.Second), int64(s.TimeScale)))
+	}
+	return st
+}
+
 func (s *RTPDemuxContext) GenerateRTCPRR() []byte {
 	// https://github.com/FFmpeg/FFmpeg/blob/master/libavformat/rtpdec.c#L299
 	buf := bytes.NewBuffer(nil)
@@ -0,0 +1,40 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func rtpPacket(seq uint16, timestamp uint32, payloadLen int) []byte {
+	buf := make([]byte, 12+payloadLen)
+	buf[0] = RTP_VERSION << 6
+	buf[1] = 96
+	binary.BigEndian.PutUint16(buf[2:], seq)
+	binary.BigEndian.PutUint32(buf[4:], timestamp)
+	binary.BigEndian.PutUint32(buf[8:], 0x1234)
+	return buf
+}
+
+func TestStats(t *testing.T) {
+	s := NewRTPDemuxContext(96, 0)
+	s.TimeScale = 90000
+
+	// 100 is the probation packet, counting starts at 101; 105 is lost and
+	// 108 arrives after 109
+	for _, seq := range []uint16{100, 101, 102, 103, 104, 106, 107, 109, 108, 110} {
+		s.RtpParsePacket(rtpPacket(seq, uint32(seq)*3000, 88))
+	}
+	st := s.Stats()
+	if st.Expected != 10 || st.Received != 9 || st.Lost != 1 {
+		t.Errorf("expected/received/lost = %v/%v/%v, want 10/9/1", st.Expected, st.Received, st.Lost)
+	}
+	if st.LossPercent() != 10 {
+		t.Errorf("LossPercent = %v, want 10", st.LossPercent())
+	}
+	if st.OutOfOrder != 1 {
+		t.Errorf("OutOfOrder = %v, want 1", st.OutOfOrder)
+	}
+	if st.Bytes != 10*100 {
+		t.Errorf("Bytes = %v, want 1000", st.Bytes)
+	}
+}
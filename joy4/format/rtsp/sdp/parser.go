@@ -30,6 +30,7 @@ type Media struct {
 type SDPInfo struct {
 	RangeStart float64 // a=range:npt=0-60.120(seconds)
 	RangeEnd   float64 // a=range:npt=0-60.120
+	Range      Range   // a=range, npt or clock
 	Medias     []Media
 	CodecDatas []av.CodecData
 	ExtraLines map[string][]string
@@ -73,17 +74,17 @@ func Parse(content string) (sess Session, sdp SDPInfo) {
 
 			// get range time for playback mode
 			if strings.HasPrefix(typeval[1], "range:") {
-				//range:npt=0-60.120
+				//range:npt=0-60.120 or range:clock=19961108T142300Z-19961108T143520Z
 				keyval := strings.SplitN(typeval[1], ":", 2)
 				if len(keyval) >= 2 {
-					//npt=0-60.120
-					ranges := strings.SplitN(keyval[1], "=", 2)
-					if len(ranges) == 2 {
-						//0-60.120
-						times := strings.SplitN(ranges[1], "-", 2)
-						if len(times) == 2 {
-							sdp.RangeStart, _ = strconv.ParseFloat(times[0], 64)
-							sdp.RangeEnd, _ = strconv.ParseFloat(times[1], 64)
+					if r, err := ParseRange(keyval[1]); err == nil {
+						sdp.Range = r
+						if r.Clock {
+							// recordings addressed by wall clock, expose the length as npt
+							sdp.RangeEnd = r.Duration().Seconds()
+						} else {
+							sdp.RangeStart = r.Start.Seconds()
+							sdp.RangeEnd = r.End.Seconds()
 						}
 					}
 				}
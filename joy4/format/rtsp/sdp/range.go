@@ -0,0 +1,165 @@
+package sdp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockLayout is the absolute time format of RFC 2326 section 3.7, always UTC.
+const clockLayout = "20060102T150405Z"
+
+// Range is an RTSP range specifier (RFC 2326 sections 3.6 and 3.7). The same
+// syntax is used by the SDP a=range attribute and by the Range header of PLAY.
+// Either the npt (relative) or the clock (absolute) fields are set.
+type Range struct {
+	Clock bool
+
+	// npt offsets from the beginning of the presentation, End 0 means open ended
+	Start time.Duration
+	End   time.Duration
+	Now   bool // npt=now- for live streams
+
+	// absolute wall clock times, a zero ClockEnd means open ended
+	ClockStart time.Time
+	ClockEnd   time.Time
+}
+
+// NPTRange returns a relative range, end 0 leaves it open ended.
+func NPTRange(start, end time.Duration) Range {
+	return Range{Start: start, End: end}
+}
+
+// ClockRange returns an absolute range, a zero end leaves it open ended.
+func ClockRange(start, end time.Time) Range {
+	return Range{Clock: true, ClockStart: start, ClockEnd: end}
+}
+
+// Duration is the length of the range, 0 if it is open ended.
+func (r Range) Duration() time.Duration {
+	if r.Clock {
+		if r.ClockEnd.IsZero() {
+			return 0
+		}
+		return r.ClockEnd.Sub(r.ClockStart)
+	}
+	if r.End <= 0 {
+		return 0
+	}
+	return r.End - r.Start
+}
+
+// String formats the range as a header value, e.g. "npt=12.5-" or
+// "clock=20240101T120000Z-20240101T130000Z".
+func (r Range) String() string {
+	if r.Clock {
+		s := "clock=" + formatClock(r.ClockStart) + "-"
+		if !r.ClockEnd.IsZero() {
+			s += formatClock(r.ClockEnd)
+		}
+		return s
+	}
+	if r.Now {
+		return "npt=now-"
+	}
+	s := "npt=" + formatNPT(r.Start) + "-"
+	if r.End > 0 {
+		s += formatNPT(r.End)
+	}
+	return s
+}
+
+func formatNPT(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+func formatClock(t time.Time) string {
+	t = t.UTC()
+	s := t.Format("20060102T150405")
+	if ms := t.Nanosecond() / int(time.Millisecond); ms > 0 {
+		s += fmt.Sprintf(".%03d", ms)
+		s = strings.TrimRight(s, "0")
+	}
+	return s + "Z"
+}
+
+// ParseRange parses a range specifier such as "npt=0-60.120" or
+// "clock=19961108T142300Z-19961108T143520Z". A trailing ";time=" parameter is ignored.
+func ParseRange(s string) (r Range, err error) {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, ";"); i >= 0 {
+		s = s[:i]
+	}
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return r, fmt.Errorf("sdp: invalid range %q", s)
+	}
+	times := strings.SplitN(kv[1], "-", 2)
+	if len(times) != 2 {
+		return r, fmt.Errorf("sdp: invalid range %q", s)
+	}
+	start, end := strings.TrimSpace(times[0]), strings.TrimSpace(times[1])
+
+	switch strings.TrimSpace(kv[0]) {
+	case "npt":
+		if start == "now" {
+			r.Now = true
+			return r, nil
+		}
+		if r.Start, err = parseNPT(start); err != nil {
+			return
+		}
+		if end != "" {
+			r.End, err = parseNPT(end)
+		}
+		return
+	case "clock":
+		r.Clock = true
+		if r.ClockStart, err = parseClock(start); err != nil {
+			return
+		}
+		if end != "" {
+			r.ClockEnd, err = parseClock(end)
+		}
+		return
+	}
+	return r, fmt.Errorf("sdp: unsupported range unit %q", kv[0])
+}
+
+// parseNPT parses npt seconds, also accepting the hh:mm:ss.fff form.
+func parseNPT(s string) (time.Duration, error) {
+	var seconds float64
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("sdp: invalid npt %q", s)
+	}
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0, fmt.Errorf("sdp: invalid npt %q", s)
+		}
+		seconds = seconds*60 + v
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func parseClock(s string) (time.Time, error) {
+	frac := ""
+	if i := strings.Index(s, "."); i >= 0 {
+		frac = strings.TrimSuffix(s[i:], "Z")
+		s = s[:i] + "Z"
+	}
+	t, err := time.Parse(clockLayout, s)
+	if err != nil {
+		return t, fmt.Errorf("sdp: invalid clock %q", s)
+	}
+	if frac != "" {
+		f, err := strconv.ParseFloat(frac, 64)
+		if err != nil {
+			return t, fmt.Errorf("sdp: invalid clock fraction %q", frac)
+		}
+		t = t.Add(time.Duration(f * float64(time.Second)))
+	}
+	return t, nil
+}
@@ -0,0 +1,82 @@
+package sdp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	clockStart := time.Date(1996, 11, 8, 14, 23, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want Range
+	}{
+		{"npt=0-60.120", Range{End: 60120 * time.Millisecond}},
+		{"npt=12.5-", Range{Start: 12500 * time.Millisecond}},
+		{"npt=00:01:02.5-", Range{Start: 62500 * time.Millisecond}},
+		{"npt=now-", Range{Now: true}},
+		{"clock=19961108T142300Z-19961108T143520Z", Range{
+			Clock:      true,
+			ClockStart: clockStart,
+			ClockEnd:   time.Date(1996, 11, 8, 14, 35, 20, 0, time.UTC),
+		}},
+		{"clock=19961108T142300.25Z-;time=19970123T143720Z", Range{
+			Clock:      true,
+			ClockStart: clockStart.Add(250 * time.Millisecond),
+		}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRange(tt.in)
+		if err != nil {
+			t.Errorf("ParseRange(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRange(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "npt", "npt=abc-", "smpte=10:07:00-", "clock=yesterday-"} {
+		if _, err := ParseRange(in); err == nil {
+			t.Errorf("ParseRange(%q) expected error", in)
+		}
+	}
+}
+
+func TestRangeString(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("CST", 8*3600))
+	tests := []struct {
+		r    Range
+		want string
+	}{
+		{NPTRange(0, 0), "npt=0-"},
+		{NPTRange(12500*time.Millisecond, 0), "npt=12.5-"},
+		{NPTRange(time.Second, 61*time.Second), "npt=1-61"},
+		{Range{Now: true}, "npt=now-"},
+		{ClockRange(start, time.Time{}), "clock=20240101T190405Z-"},
+		{ClockRange(start.Add(500*time.Millisecond), start.Add(time.Hour)), "clock=20240101T190405.5Z-20240101T200405Z"},
+	}
+	for _, tt := range tests {
+		if got := tt.r.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.r, got, tt.want)
+		}
+		back, err := ParseRange(tt.r.String())
+		if err != nil {
+			t.Errorf("ParseRange(%q) error: %v", tt.want, err)
+			continue
+		}
+		if back.String() != tt.want {
+			t.Errorf("round trip of %q = %q", tt.want, back.String())
+		}
+	}
+}
+
+func TestParseClockRange(t *testing.T) {
+	_, info := Parse("v=0\r\na=range:clock=20240101T100000Z-20240101T110000Z\r\n")
+	if !info.Range.Clock {
+		t.Fatalf("expected clock range, got %+v", info.Range)
+	}
+	if info.RangeEnd != 3600 {
+		t.Errorf("RangeEnd = %v, want 3600", info.RangeEnd)
+	}
+}
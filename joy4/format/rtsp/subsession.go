@@ -3,10 +3,9 @@ package rtsp
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
 	"io"
 	"net"
-	"strings"
+	"strconv"
 	"time"
 
 	"videoplayer/joy4/av"
@@ -49,7 +48,7 @@ func (self *SubSession) writeSRPacket(idx int8, timeBase int64, ntpTime int64, c
 	// SSRC
 	_ = binary.Write(w, binary.BigEndian, mux.SSRC)
 	_ = binary.Write(w, binary.BigEndian, uint32(ntpTime/1000000))
-	_ = binary.Write(w, binary.BigEndian, (uint32(ntpTime%1000000)<<32)/1000000)
+	_ = binary.Write(w, binary.BigEndian, uint32((uint64(ntpTime%1000000)<<32)/1000000))
 	_ = binary.Write(w, binary.BigEndian, uint32(rtpTs))
 	_ = binary.Write(w, binary.BigEndian, uint32(mux.PacketCount))
 	_ = binary.Write(w, binary.BigEndian, uint32(mux.OctetCount))
@@ -709,17 +708,13 @@ func (self *SubSession) setupUDP(addr string, p1, p2 uint16) error {
 		return nil
 	}
 
-	// hack to IPv6 address
-	if strings.Contains(addr, ":") {
-		addr = "[" + addr + "]"
-	}
-	a1 := fmt.Sprintf("%s:%d", addr, p1)
+	a1 := net.JoinHostPort(addr, strconv.Itoa(int(p1)))
 	s1, err := net.Dial("udp", a1)
 	if err != nil {
 		log.Log(log.ERROR, "failed to setup udp rtp sock to ", a1, " ", err)
 		return err
 	}
-	a2 := fmt.Sprintf("%s:%d", addr, p2)
+	a2 := net.JoinHostPort(addr, strconv.Itoa(int(p2)))
 	s2, err := net.Dial("udp", a2)
 	if err != nil {
 		log.Log(log.ERROR, "failed to setup udp rtcp sock to ", a2, " ", err)
@@ -727,7 +722,7 @@ func (self *SubSession) setupUDP(addr string, p1, p2 uint16) error {
 		return err
 	}
 
-	self.udpServerSocks = FindUDPPair(0) // for default random idle port
+	self.udpServerSocks = rtp.FindUDPPair(0) // for default random idle port
 	if self.udpServerSocks == nil {
 		s1.Close()
 		s2.Close()
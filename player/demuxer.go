@@ -2,25 +2,33 @@ package player
 
 import (
 	"bytes"
+	"context"
+	"sync"
 	"time"
 	config "videoplayer/config"
 	"videoplayer/ffmpeg"
 	"videoplayer/pb"
-	"videoplayer/rtsp"
+	"videoplayer/source"
 	"videoplayer/transport"
+	"videoplayer/util/apierr"
 
 	"videoplayer/joy4/av"
 
 	"videoplayer/joy4/codec/aacparser"
 	"videoplayer/joy4/codec/h264parser"
-	jrtsp "videoplayer/joy4/format/rtsp"
-	"videoplayer/joy4/format/rtsp/sdp"
 
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/protobuf/proto"
 )
 
+const (
+	// keyFrameOnlyRate 超过该倍速时只解码关键帧
+	keyFrameOnlyRate = 4
+	// minFrameInterval 倍速播放时送显的最小间隔，超出显示能力的帧直接丢弃
+	minFrameInterval = 40 * time.Millisecond
+)
+
 func pts(pkt av.Packet) float64 {
 	// miliseconds
 	return (pkt.Time + pkt.CompositionTime).Seconds() * 1000
@@ -28,15 +36,16 @@ func pts(pkt av.Packet) float64 {
 
 type Demuxer struct {
 	id             string
+	key            string // 共享视频源的标识，不共享时为空
+	signal         *transport.SignalLog
 	stopChan       chan struct{}
-	ws             *transport.WebSocketProxy
-	client         *rtsp.Client
-	joyClient      *jrtsp.Client
-	sdpInfo        sdp.SDPInfo
+	src            source.Source
+	streams        []av.CodecData
+	caps           source.Capabilities
 	preCodecBuffer *bytes.Buffer
 
 	videoIdx   int
-	videoMedia sdp.Media
+	videoCodec av.CodecType
 
 	audioIdx       int
 	audioCodecData aacparser.CodecData
@@ -48,47 +57,49 @@ type Demuxer struct {
 	statistics      map[int]int64
 	decoder         *ffmpeg.VideoDecoder
 	lastPreviewInfo []*pb.PreviewInfo
+	// lastPreviewTime 最近一次 SEI 所在帧的采集时间，用于判断叠加信息是否过期
+	lastPreviewTime time.Time
+
+	// 暂停控制，由 player 协程设置、run 协程读取
+	pauseMu      sync.Mutex
+	paused       bool
+	keepDecoding bool
+	// stepping 暂停状态下放行下一帧
+	stepping bool
+	// waitKeyFrame 暂停期间没有解码，恢复后需要从关键帧重新开始
+	waitKeyFrame bool
+	// rate 文件播放倍速，lastDeliver 为上一帧送显时间
+	rate        float64
+	lastDeliver time.Time
+
+	// 接收统计，bytes/statsAt 只在 run 协程中访问，stats 由 statsMu 保护
+	bytes   uint64
+	statsAt time.Time
+	statsMu sync.Mutex
+	stats   Stats
 
 	UseOpenCV bool
 	IsCuda    bool
 }
 
-func NewDemuxer(wsurl, rtspurl string, frameChan chan frameData, stateChan chan State, id string) (*Demuxer, error) {
-	var err error
-	var ws *transport.WebSocketProxy
-	var rtspClient *rtsp.Client
-	var joyClient *jrtsp.Client
-	if wsurl != "" {
-		ws, err = transport.NewWebSocketProxy(wsurl, rtspurl)
-		if err != nil {
-			return nil, err
-		}
-		rtspClient, err = rtsp.NewClient(rtspurl, ws)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		joyClient, err = jrtsp.DialTimeout(rtspurl, time.Second*10)
-
-		if err != nil {
-			log.Errorf("dial rtsp err: %v", err)
-			return nil, err
-		}
-
-		joyClient.UseUDP = false
+// NewDemuxer 创建 demuxer。wsurl 不为空时通过 WSP 代理拉取 RTSP，
+// 否则 rtspurl 可以是 rtsp/rtmp/hls 地址或本地文件
+func NewDemuxer(wsurl, rtspurl string, opts source.Options, frameChan chan frameData, stateChan chan State, id string) (*Demuxer, error) {
+	src, err := source.New(wsurl, rtspurl, opts)
+	if err != nil {
+		return nil, err
 	}
 
 	d := &Demuxer{
 		id:             id,
-		ws:             ws,
-		client:         rtspClient,
-		joyClient:      joyClient,
+		src:            src,
 		preCodecBuffer: &bytes.Buffer{},
 		adtsPrefix:     make([]byte, 7),
 		frameChan:      frameChan,
 		stateChan:      stateChan,
 		statistics:     make(map[int]int64),
 		stopChan:       make(chan struct{}),
+		rate:           1,
 
 		UseOpenCV: config.GlobalConfig.UseOpenCV,
 	}
@@ -98,62 +109,58 @@ func NewDemuxer(wsurl, rtspurl string, frameChan chan frameData, stateChan chan
 func (d *Demuxer) reportMediaInfo() {
 	mediaInfo := map[string]interface{}{}
 	if d.videoIdx != -1 {
-		media := d.sdpInfo.Medias[d.videoIdx]
+		codec := d.streams[d.videoIdx].Type()
 		ct := 0
-		if media.Type == av.H265 {
+		if codec == av.H265 {
 			ct = 1
 		}
 		mediaInfo["video"] = map[string]interface{}{
 			"codec": ct,
 		}
-		d.videoMedia = media
+		d.videoCodec = codec
 	}
 	if d.audioIdx != -1 {
 		mediaInfo["audio"] = map[string]interface{}{}
-		d.audioCodecData = d.sdpInfo.CodecDatas[d.audioIdx].(aacparser.CodecData)
+		d.audioCodecData = d.streams[d.audioIdx].(aacparser.CodecData)
 	}
 }
 
-func (d *Demuxer) getSdp() (sdp sdp.SDPInfo, err error) {
-	if d.joyClient != nil {
-		sdp, err = d.joyClient.SDP()
-		return
-	}
-	sdp, err = d.client.SDP()
-
-	return
-}
-
-func (d *Demuxer) Start() error {
+// Start 打开视频源并开始读取，ctx 结束时放弃打开
+func (d *Demuxer) Start(ctx context.Context) error {
 	var err error
-	if d.ws != nil {
-		err = d.ws.Connect()
-		if err != nil {
-			log.Errorf("ws connnet failed: %v", err)
-			return err
-		}
+	if err = d.src.Open(ctx); err != nil {
+		log.Errorf("open source failed: %v", err)
+		return err
 	}
-
-	sdpInfo, err := d.getSdp()
+	d.streams, err = d.src.Streams()
 	if err != nil {
-		log.Errorf("getSdp failed: %v", err)
+		log.Errorf("get streams failed: %v", err)
 		return err
 	}
-	d.sdpInfo = sdpInfo
+	d.caps = d.src.Capabilities()
 	d.videoIdx, d.audioIdx = -1, -1
-	for i, m := range sdpInfo.Medias {
-		log.Debugf("mediaType:%v", m.Type)
-		switch m.Type {
+	for i, cd := range d.streams {
+		if cd == nil {
+			continue
+		}
+		log.Debugf("mediaType:%v", cd.Type())
+		switch cd.Type() {
 		case av.H264, av.H265:
 			d.videoIdx = i
 		case av.AAC:
-			d.audioIdx = i
+			if _, ok := cd.(aacparser.CodecData); ok {
+				d.audioIdx = i
+			}
 		}
 	}
+	if d.videoIdx == -1 {
+		return apierr.New(apierr.UnsupportedCodec, "no video stream in source")
+	}
 
-	d.decoder, err = ffmpeg.NewVideoDecoder(sdpInfo.CodecDatas[d.videoIdx])
+	d.decoder, err = ffmpeg.NewVideoDecoder(d.streams[d.videoIdx])
 	if err != nil {
-		log.Fatalf("ffmpeg.NewVideoDecoder failed, err: %v", err)
+		log.Errorf("ffmpeg.NewVideoDecoder failed, err: %v", err)
+		return apierr.Wrap(apierr.Decoder, 0, err)
 	}
 	d.IsCuda = d.decoder.Mode != ffmpeg.DecodeModeCPU
 
@@ -162,6 +169,197 @@ func (d *Demuxer) Start() error {
 	return nil
 }
 
+// Seekable 源是否为可定位的录像或文件
+func (d *Demuxer) Seekable() bool {
+	return d.caps.Seekable
+}
+
+// pausable 视频源本身能否暂停发送
+func (d *Demuxer) pausable() bool {
+	_, ok := d.src.(source.Pauser)
+	return d.caps.Pausable && ok
+}
+
+func (d *Demuxer) sendPause() error {
+	return d.src.(source.Pauser).Pause()
+}
+
+func (d *Demuxer) sendPlay() error {
+	return d.src.(source.Pauser).Play()
+}
+
+// Pause 暂停显示。直播流继续接收，keepDecoding 为 true 时继续解码以便立即恢复；
+// 可暂停的源（RTSP 回放、文件）让源本身暂停发送
+func (d *Demuxer) Pause(keepDecoding bool) error {
+	d.pauseMu.Lock()
+	defer d.pauseMu.Unlock()
+	if d.paused {
+		return nil
+	}
+	if d.pausable() {
+		if err := d.sendPause(); err != nil {
+			return err
+		}
+	}
+	d.paused = true
+	d.keepDecoding = keepDecoding
+	d.stepping = false
+	return nil
+}
+
+// Paused 是否处于暂停状态
+func (d *Demuxer) Paused() bool {
+	d.pauseMu.Lock()
+	defer d.pauseMu.Unlock()
+	return d.paused
+}
+
+// Resume 恢复显示
+func (d *Demuxer) Resume() error {
+	d.pauseMu.Lock()
+	defer d.pauseMu.Unlock()
+	if !d.paused {
+		return nil
+	}
+	if d.pausable() {
+		if err := d.sendPlay(); err != nil {
+			return err
+		}
+	}
+	d.paused = false
+	d.stepping = false
+	return nil
+}
+
+// Step 暂停状态下显示下一帧。直播流未保持解码时，下一帧为下一个关键帧
+func (d *Demuxer) Step() error {
+	d.pauseMu.Lock()
+	defer d.pauseMu.Unlock()
+	if !d.paused {
+		return apierr.New(apierr.InvalidRequest, "window is not paused")
+	}
+	if d.stepping {
+		return nil
+	}
+	if d.pausable() {
+		if err := d.sendPlay(); err != nil {
+			return err
+		}
+	}
+	d.stepping = true
+	return nil
+}
+
+// pauseState 根据暂停状态决定当前视频包是否需要解码、解码结果是否需要显示
+func (d *Demuxer) pauseState(isKeyFrame bool) (decode bool, deliver bool) {
+	d.pauseMu.Lock()
+	defer d.pauseMu.Unlock()
+	if d.paused && !d.stepping {
+		if d.keepDecoding {
+			return true, false
+		}
+		d.waitKeyFrame = true
+		return false, false
+	}
+	if d.rate > keyFrameOnlyRate && !isKeyFrame {
+		// 高倍速只解码关键帧，恢复正常倍速后需要从关键帧重新开始
+		d.waitKeyFrame = true
+		return false, false
+	}
+	if d.waitKeyFrame {
+		if !isKeyFrame {
+			return false, false
+		}
+		d.waitKeyFrame = false
+	}
+	return true, true
+}
+
+// dropForRate 倍速播放时按最小送显间隔丢帧
+func (d *Demuxer) dropForRate() bool {
+	d.pauseMu.Lock()
+	defer d.pauseMu.Unlock()
+	if d.rate <= 1 || d.stepping {
+		return false
+	}
+	now := time.Now()
+	if now.Sub(d.lastDeliver) < minFrameInterval {
+		return true
+	}
+	d.lastDeliver = now
+	return false
+}
+
+// Seek 定位到目标时间之前最近的关键帧，本地文件和 RTSP 录像支持
+func (d *Demuxer) Seek(to time.Duration) error {
+	seeker, ok := d.src.(source.Seeker)
+	if !ok {
+		return apierr.New(apierr.InvalidRequest, "source does not support seek")
+	}
+	if err := seeker.Seek(to); err != nil {
+		return err
+	}
+	d.seeked()
+	return nil
+}
+
+// SeekClock 定位到绝对时间，仅以 clock 范围描述的 RTSP 录像支持
+func (d *Demuxer) SeekClock(t time.Time) error {
+	seeker, ok := d.src.(source.ClockSeeker)
+	if !ok {
+		return apierr.New(apierr.InvalidRequest, "source does not support seek by clock")
+	}
+	if err := seeker.SeekClock(t); err != nil {
+		return err
+	}
+	d.seeked()
+	return nil
+}
+
+// seeked 定位后丢弃关键帧之前的帧
+func (d *Demuxer) seeked() {
+	d.pauseMu.Lock()
+	defer d.pauseMu.Unlock()
+	d.waitKeyFrame = true
+}
+
+// SetRate 设置播放倍速，本地文件和 RTSP 录像（Scale）支持
+func (d *Demuxer) SetRate(rate float64) error {
+	rc, ok := d.src.(source.RateController)
+	if !ok {
+		return apierr.New(apierr.InvalidRequest, "source does not support playback rate")
+	}
+	if err := rc.SetRate(rate); err != nil {
+		return err
+	}
+	d.pauseMu.Lock()
+	defer d.pauseMu.Unlock()
+	d.rate = rate
+	return nil
+}
+
+// SetLoop 设置播放结束后是否从头开始，不支持的视频源忽略
+func (d *Demuxer) SetLoop(loop bool) {
+	if looper, ok := d.src.(source.Looper); ok {
+		looper.SetLoop(loop)
+	}
+}
+
+// stepDone 单步的一帧已送出，可暂停的源重新暂停
+func (d *Demuxer) stepDone() {
+	d.pauseMu.Lock()
+	defer d.pauseMu.Unlock()
+	if !d.stepping {
+		return
+	}
+	d.stepping = false
+	if d.pausable() {
+		if err := d.sendPause(); err != nil {
+			log.Errorf("PAUSE after step failed: %v", err)
+		}
+	}
+}
+
 func (d *Demuxer) dealWithAudioPacket(pkt av.Packet) {
 	buffer := d.preCodecBuffer
 	data := pkt.Data
@@ -173,7 +371,7 @@ func (d *Demuxer) dealWithAudioPacket(pkt av.Packet) {
 
 func (d *Demuxer) sendPacket(pkt av.Packet, buffer *bytes.Buffer, pktRecieveTime time.Time) {
 	var err error
-	codec := d.videoMedia.Type
+	codec := d.videoCodec
 	if codec != av.H264 {
 		return
 	}
@@ -182,6 +380,11 @@ func (d *Demuxer) sendPacket(pkt av.Packet, buffer *bytes.Buffer, pktRecieveTime
 
 	var seiPayLoad []byte
 	var previewInfos []*pb.PreviewInfo
+	isKeyFrame := pkt.IsKeyFrame
+	captureTime := pkt.WallClock
+	if captureTime.IsZero() {
+		captureTime = pktRecieveTime
+	}
 	for _, nalu := range nalus {
 		if _, ok := d.statistics[nalu.Type]; !ok {
 			d.statistics[nalu.Type] = 1
@@ -190,6 +393,7 @@ func (d *Demuxer) sendPacket(pkt av.Packet, buffer *bytes.Buffer, pktRecieveTime
 		}
 
 		if nalu.Type == h264parser.NALU_IDR_SLICE {
+			isKeyFrame = true
 			log.Debugf("***********IDR************** nalus.len: %v, pkt.IsKeyFram: %v, pkt.Data.len: %v",
 				len(nalus), pkt.IsKeyFrame, len(pkt.Data))
 		}
@@ -216,16 +420,26 @@ func (d *Demuxer) sendPacket(pkt av.Packet, buffer *bytes.Buffer, pktRecieveTime
 
 	if len(previewInfos) > 0 {
 		d.lastPreviewInfo = previewInfos
+		d.lastPreviewTime = captureTime
 	}
 
 	var videoFrame *ffmpeg.VideoFrame
+	decode, deliver := d.pauseState(isKeyFrame)
+	if !decode {
+		return
+	}
 	if nalus[0].Type == h264parser.NALU_NON_IDR_SLICE || nalus[0].Type == h264parser.NALU_IDR_SLICE {
-		videoFrame, err = d.Decode(pkt.Data, int64(pkt.Time), pktRecieveTime)
+		videoFrame, err = d.Decode(pkt.Data, captureTime, pktRecieveTime)
 		if err != nil {
 			log.Errorf("Decode failed: %v", err)
 		}
 	}
 
+	if videoFrame != nil && (!deliver || d.dropForRate()) {
+		videoFrame.Free()
+		return
+	}
+
 	if videoFrame != nil {
 		if d.UseOpenCV {
 			elapsed := time.Since(pktRecieveTime)
@@ -237,9 +451,13 @@ func (d *Demuxer) sendPacket(pkt av.Packet, buffer *bytes.Buffer, pktRecieveTime
 		d.frameChan <- frameData{
 			frame:       videoFrame,
 			id:          d.id,
-			sei:         d.lastPreviewInfo,
+			sei:         d.previewInfo(captureTime),
 			receiveTime: pktRecieveTime,
+			captureTime: captureTime,
+			demuxer:     d,
+			keyFrame:    isKeyFrame,
 		}
+		d.stepDone()
 	}
 
 }
@@ -249,7 +467,7 @@ func (d *Demuxer) dealWithNalu(pkt av.Packet, nalu h264parser.H2645NAL) []byte {
 	var codec string
 	isSEI = (nalu.Type == h264parser.NALU_SEI)
 
-	switch d.videoMedia.Type {
+	switch d.videoCodec {
 	case av.H264:
 		isSEI = (nalu.Type == h264parser.NALU_SEI)
 		codec = "h264"
@@ -306,14 +524,11 @@ func (d *Demuxer) dispatchPacket(pkt av.Packet, pktRecieveTime time.Time) {
 }
 
 func (d *Demuxer) ReadPacket() (pkt av.Packet, err error) {
-	if d.joyClient != nil {
-		return d.joyClient.ReadPacket()
-	}
-
-	return d.client.ReadPacket()
+	return d.src.ReadPacket()
 }
 
-func (d *Demuxer) Release() {
+// Release 停止读取并关闭视频源，ctx 结束时不再等待 RTSP 的 TEARDOWN 响应
+func (d *Demuxer) Release(ctx context.Context) {
 	// 可能会NPE
 	defer func() {
 		if p := recover(); p != nil {
@@ -321,21 +536,8 @@ func (d *Demuxer) Release() {
 		}
 	}()
 	close(d.stopChan)
-	if d.client != nil {
-		err := d.client.Teardown()
-		if err != nil {
-			log.Errorf("TEARDOWN failed: %v", err)
-		}
-	}
-	if d.ws != nil {
-		d.ws.Disconnect()
-	}
-
-	if d.joyClient != nil {
-		err := d.joyClient.Teardown()
-		if err != nil {
-			log.Errorf("TEARDOWN failed: %v", err)
-		}
+	if err := source.Close(ctx, d.src); err != nil {
+		log.Errorf("close source failed: %v", err)
 	}
 	if d.decoder != nil {
 		d.decoder.Destroy()
@@ -360,10 +562,15 @@ func (d *Demuxer) run() {
 				d.stateChan <- State{
 					windowID: d.id,
 					err:      err,
+					demuxer:  d,
 				}
 				return
 			}
 			start := time.Now()
+			d.bytes += uint64(len(pkt.Data))
+			if start.Sub(d.statsAt) >= statsInterval {
+				d.updateStats(start)
+			}
 			d.dispatchPacket(pkt, start)
 
 			cost := time.Since(start)
@@ -0,0 +1,71 @@
+package player
+
+import (
+	"videoplayer/transport"
+	"videoplayer/util/apierr"
+)
+
+// Diagnostics 窗口最近一次打开视频源的信令记录（已脱敏），用于远程排查打开失败。
+// 共享视频源的窗口看到的是同一份记录
+type Diagnostics struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Open 窗口当前是否在播放，打开失败或重连放弃后为 false
+	Open bool `json:"open"`
+	// Error 最近一次打开失败或播放中断的错误
+	Error *apierr.Error `json:"error,omitempty"`
+	// Dropped 握手之后超出上限被丢弃的信令条数
+	Dropped   int                     `json:"dropped,omitempty"`
+	Signaling []transport.SignalEntry `json:"signaling"`
+}
+
+type diagnostics struct {
+	url    string
+	signal *transport.SignalLog
+	err    error
+}
+
+func (p *Player) setDiagnostics(dev Device, signal *transport.SignalLog, err error) {
+	p.diagMu.Lock()
+	defer p.diagMu.Unlock()
+	p.diagnostics[dev.ID] = &diagnostics{url: dev.RTSPURL, signal: signal, err: err}
+}
+
+// noteError 记录视频源播放中出现的错误
+func (p *Player) noteError(windowID string, err error) {
+	p.diagMu.Lock()
+	defer p.diagMu.Unlock()
+	if d := p.diagnostics[windowID]; d != nil {
+		d.err = err
+	}
+}
+
+func (p *Player) dropDiagnostics(windowID string) {
+	p.diagMu.Lock()
+	defer p.diagMu.Unlock()
+	delete(p.diagnostics, windowID)
+}
+
+// windowDiagnostics 返回窗口的信令记录和最近的错误
+func (p *Player) windowDiagnostics(windowID string) (Diagnostics, error) {
+	p.diagMu.Lock()
+	defer p.diagMu.Unlock()
+	d := p.diagnostics[windowID]
+	if d == nil {
+		return Diagnostics{}, apierr.New(apierr.NotFound, "windowID: %v not exist", windowID)
+	}
+	entries, dropped := d.signal.Entries()
+	diag := Diagnostics{
+		ID:        windowID,
+		URL:       transport.Redact(d.url),
+		Open:      p.windows[windowID] != nil,
+		Dropped:   dropped,
+		Signaling: entries,
+	}
+	if d.err != nil {
+		e := *apierr.From(classify(d.err))
+		e.Message = transport.Redact(e.Message)
+		diag.Error = &e
+	}
+	return diag, nil
+}
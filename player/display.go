@@ -25,7 +25,22 @@ type ParsedData struct {
 	Mat          *gocv.Mat
 }
 
-func (d *Demuxer) Decode(pkt []byte, pts int64, startTime time.Time) (*ffmpeg.VideoFrame, error) {
+// overlayMaxSkew SEI 叠加信息与当前帧采集时间相差超过该值时不再绘制
+const overlayMaxSkew = time.Second
+
+// previewInfo 返回与当前帧采集时间对应的 SEI 叠加信息，已过期时返回 nil
+func (d *Demuxer) previewInfo(captureTime time.Time) []*pb.PreviewInfo {
+	skew := captureTime.Sub(d.lastPreviewTime)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > overlayMaxSkew {
+		return nil
+	}
+	return d.lastPreviewInfo
+}
+
+func (d *Demuxer) Decode(pkt []byte, captureTime time.Time, startTime time.Time) (*ffmpeg.VideoFrame, error) {
 
 	decodeFrame, err := d.decoder.Decode(pkt)
 	if err != nil {
@@ -37,27 +52,22 @@ func (d *Demuxer) Decode(pkt []byte, pts int64, startTime time.Time) (*ffmpeg.Vi
 	decodeCost := time.Since(startTime)
 	log.Debug("decodeCost:", decodeCost)
 	// defer decodeFrame.Free()
+	sei := d.previewInfo(captureTime)
 	if decodeFrame.Mat != nil {
-		if len(d.lastPreviewInfo) > 0 {
-			diff := pts/1e6 - d.lastPreviewInfo[0].Timestamp/1e6
-			log.Debug("-----------------------", pts/1e6, d.lastPreviewInfo[0].Timestamp/1e6, diff)
-			// if math.Abs(float64(data.Pts-lastPreviewInfo[0].Timestamp))/1e6 < 100 {
-			getOverlayImage(d.lastPreviewInfo, &decodeFrame.Mat)
-			// }
+		if len(sei) > 0 {
+			log.Debug("overlay skew:", captureTime.Sub(d.lastPreviewTime))
+			getOverlayImage(sei, &decodeFrame.Mat)
 			drawCost := time.Since(startTime)
 			log.Debug("drawCost:**********************", drawCost)
 		}
 		return decodeFrame, nil
 	} else if decodeFrame.Image != nil {
-		if len(d.lastPreviewInfo) > 0 {
-			diff := pts/1e6 - d.lastPreviewInfo[0].Timestamp/1e6
-			log.Debug("-----------------------", pts/1e6, d.lastPreviewInfo[0].Timestamp/1e6, diff)
-			// if math.Abs(float64(data.Pts-lastPreviewInfo[0].Timestamp))/1e6 < 100 {
-			tmpImage, err := getOverlayImageOnImage(d.lastPreviewInfo, decodeFrame.Image)
+		if len(sei) > 0 {
+			log.Debug("overlay skew:", captureTime.Sub(d.lastPreviewTime))
+			tmpImage, err := getOverlayImageOnImage(sei, decodeFrame.Image)
 			if err == nil && tmpImage != nil {
 				decodeFrame.Image = tmpImage
 			}
-			// }
 			drawCost := time.Since(startTime)
 			log.Debug("drawCost:**********************", drawCost)
 		}
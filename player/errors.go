@@ -0,0 +1,78 @@
+package player
+
+import (
+	"errors"
+
+	"videoplayer/joy4/format/rtsp/auth"
+	"videoplayer/rtsp"
+	"videoplayer/transport"
+	"videoplayer/util/apierr"
+)
+
+// classify 把视频源、传输层和平台接口的错误归类为 apierr.Error，带上 RTSP/WSP 状态码，
+// 客户端据此判断是否需要重试。已归类的错误原样返回
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var apiErr *apierr.Error
+	if errors.As(err, &apiErr) {
+		return err
+	}
+	var rtspErr *rtsp.RTSPError
+	var wspErr *transport.WSPError
+	switch {
+	case isAuthError(err):
+		return apierr.Wrap(apierr.Auth, upstreamStatus(err), err)
+	case errors.As(err, &rtspErr):
+		return statusError(rtspErr.Code, err)
+	case errors.As(err, &wspErr):
+		return statusError(wspErr.Code, err)
+	case errors.Is(err, rtsp.ErrNoSupportedStream):
+		return apierr.Wrap(apierr.UnsupportedCodec, 0, err)
+	case errors.Is(err, rtsp.ErrNoUDPPortPair):
+		return apierr.Wrap(apierr.ResourceLimit, 0, err)
+	case errors.Is(err, transport.ErrNoFlow):
+		return apierr.Wrap(apierr.NotFound, 0, err)
+	case errors.Is(err, rtsp.ErrTimeout), errors.Is(err, rtsp.ErrKeepaliveTimeout),
+		errors.Is(err, transport.ErrNotConnected), errors.Is(err, transport.ErrSessionClosed):
+		return apierr.Wrap(apierr.Network, 0, err)
+	case errors.Is(err, rtsp.ErrBadServer), errors.Is(err, auth.ErrUnsupported),
+		errors.Is(err, transport.ErrBadRecording), errors.Is(err, transport.ErrReattachRefused):
+		return apierr.Wrap(apierr.Protocol, 0, err)
+	}
+	return apierr.From(err)
+}
+
+// upstreamStatus 错误中的 RTSP 或 WSP 状态码，没有时为 0
+func upstreamStatus(err error) int {
+	var rtspErr *rtsp.RTSPError
+	var wspErr *transport.WSPError
+	switch {
+	case errors.As(err, &rtspErr):
+		return rtspErr.Code
+	case errors.As(err, &wspErr):
+		return wspErr.Code
+	}
+	return 0
+}
+
+// statusError 按 RTSP/WSP 状态码归类，5xx 的错误可以重试
+func statusError(code int, err error) *apierr.Error {
+	category := apierr.Protocol
+	switch code {
+	case 404:
+		category = apierr.NotFound
+	case 415:
+		category = apierr.UnsupportedCodec
+	case 429, 453, 503:
+		// 453 Not Enough Bandwidth
+		category = apierr.ResourceLimit
+	case 502, 504:
+		// WSP 代理连不上摄像机
+		category = apierr.Network
+	}
+	e := apierr.Wrap(category, code, err)
+	e.Retryable = e.Retryable || code >= 500
+	return e
+}
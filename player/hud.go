@@ -0,0 +1,16 @@
+package player
+
+// hudTimeLayout HUD 中采集时间的显示格式
+const hudTimeLayout = "2006-01-02 15:04:05.000"
+
+// hudLines 生成窗口左上角叠加的信息行
+func hudLines(frame frameData) []string {
+	var lines []string
+	if !frame.captureTime.IsZero() {
+		lines = append(lines, frame.captureTime.Local().Format(hudTimeLayout))
+	}
+	if frame.demuxer != nil {
+		lines = append(lines, hudStats(frame.demuxer.Stats(), frame.demuxer.videoIdx))
+	}
+	return lines
+}
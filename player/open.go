@@ -0,0 +1,172 @@
+package player
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"videoplayer/config"
+	"videoplayer/transport"
+)
+
+const (
+	// openTimeout 打开一个视频源的最长时间，包括 WSP 的 INIT/JOIN 和 RTSP 握手
+	openTimeout = time.Minute
+	// reconnectAttempts 视频源掉线后最多重连的次数，全部失败后关闭窗口
+	reconnectAttempts = 120
+	// reconnectInterval 两次重连之间的间隔
+	reconnectInterval = 5 * time.Second
+)
+
+// errOpening 视频源正在协程中打开，请求的结果在打开完成后回复
+var errOpening = errors.New("opening")
+
+// opening 窗口正在打开的视频源。打开在协程中进行，不阻塞播放器的主循环，
+// 完成后由主循环调用 done；窗口关闭或有新的打开请求时取消
+type opening struct {
+	dev    Device
+	cancel context.CancelFunc
+	done   func(dem *Demuxer)
+	// reply 等待打开结果的请求，为 nil 时不回复
+	reply chan error
+	// preconnect 轮巡预连接，不记录诊断信息，失败时跳过该视频源
+	preconnect bool
+	// retry 掉线重连，失败后由 opened 发起下一次尝试
+	retry *retry
+	// delay 开始打开前的等待时间
+	delay time.Duration
+}
+
+// retry 掉线重连的进度，authFailed 为上一次重连因鉴权失败
+type retry struct {
+	attempt    int
+	offline    string
+	authFailed bool
+}
+
+// slate 重连时窗口显示的状态文字，上一次鉴权失败时保留失败原因，直到重连因其他原因失败或成功
+func (r *retry) slate(lines ...string) []string {
+	if r.authFailed {
+		lines = append([]string{"Authentication failed"}, lines...)
+	}
+	return append(lines, r.offline)
+}
+
+type openResult struct {
+	op      *opening
+	demuxer *Demuxer
+	signal  *transport.SignalLog
+	err     error
+}
+
+// open 在协程中为窗口打开视频源，取消该窗口之前未完成的打开。
+// 返回 errOpening，请求的结果由 opened 回复
+func (p *Player) open(dev Device, reply chan error, done func(dem *Demuxer)) error {
+	p.startOpen(&opening{dev: dev, reply: reply, done: done})
+	return errOpening
+}
+
+func (p *Player) startOpen(op *opening) {
+	p.cancelOpen(op.dev.ID)
+	ctx, cancel := context.WithTimeout(context.Background(), op.delay+openTimeout)
+	op.cancel = cancel
+	p.opening[op.dev.ID] = op
+	go func() {
+		res := openResult{op: op, signal: transport.NewSignalLog()}
+		dev := op.dev
+		if op.retry != nil {
+			dev, res.err = p.redial(ctx, op)
+		}
+		if res.err == nil {
+			res.demuxer, res.err = p.sources.acquire(ctx, dev, res.signal)
+		}
+		select {
+		case p.openChan <- res:
+		case <-p.stopChan:
+			p.sources.release(res.demuxer)
+		}
+	}()
+}
+
+// redial 在协程中等待重连间隔，提示重连次数并刷新 WSURL 中的 token
+func (p *Player) redial(ctx context.Context, op *opening) (Device, error) {
+	dev := op.dev
+	if op.delay > 0 {
+		timer := time.NewTimer(op.delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return dev, ctx.Err()
+		}
+	}
+	s := slate{
+		windowID: dev.ID,
+		lines:    op.retry.slate(fmt.Sprintf("Reconnecting (attempt %d)…", op.retry.attempt)),
+	}
+	select {
+	case p.slateChan <- s:
+	case <-ctx.Done():
+		return dev, ctx.Err()
+	case <-p.stopChan:
+		return dev, context.Canceled
+	}
+	token, err := config.GetTokenContext(ctx)
+	if err != nil {
+		return dev, fmt.Errorf("get token: %w", err)
+	}
+	dev.WSURL, _ = replaceTokenInURL(dev.WSURL, token)
+	log.Infof("get new wsurl:%v", dev.WSURL)
+	return dev, nil
+}
+
+// opened 打开完成，窗口仍在等待该结果时调用 done 并回复请求
+func (p *Player) opened(res openResult) {
+	op := res.op
+	op.cancel()
+	windowID := op.dev.ID
+	if p.opening[windowID] != op {
+		// 已被取消，请求已经回复过
+		p.sources.release(res.demuxer)
+		return
+	}
+	delete(p.opening, windowID)
+	if !op.preconnect {
+		signal := res.signal
+		if res.demuxer != nil {
+			signal = res.demuxer.signal
+		}
+		p.setDiagnostics(op.dev, signal, res.err)
+	}
+	if res.err != nil {
+		log.Errorf("open %v for window %v failed: %v", op.dev, windowID, res.err)
+		if t := p.tours[windowID]; t != nil && op.preconnect {
+			t.skipNext()
+		}
+		if op.retry != nil {
+			p.retryFailed(op, res.err)
+		}
+	} else {
+		op.done(res.demuxer)
+	}
+	if op.reply != nil {
+		op.reply <- classify(res.err)
+	}
+}
+
+// cancelOpen 取消窗口正在进行的打开，等待结果的请求收到取消错误
+func (p *Player) cancelOpen(windowID string) {
+	op := p.opening[windowID]
+	if op == nil {
+		return
+	}
+	log.Infof("cancel opening %v for window %v", op.dev, windowID)
+	delete(p.opening, windowID)
+	op.cancel()
+	if op.reply != nil {
+		op.reply <- classify(fmt.Errorf("open for window %v: %w", windowID, context.Canceled))
+	}
+}
@@ -5,8 +5,12 @@ package player
 
 import (
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
 	"videoplayer/ffmpeg"
 	"videoplayer/pb"
+	"videoplayer/util/text2image"
 
 	log "github.com/sirupsen/logrus"
 	"gocv.io/x/gocv"
@@ -21,6 +25,11 @@ type OpencvWindow struct {
 	*gocv.Window
 	Device
 	Position
+
+	// lastFrame 保留最后显示的一帧，用于绘制状态提示
+	lastFrame *ffmpeg.VideoFrame
+	// hud 每帧叠加在左上角的信息行
+	hud []string
 }
 
 func init() {
@@ -51,6 +60,10 @@ func (cv *OpencvWindow) GetType() string {
 }
 
 func (cv *OpencvWindow) Close() error {
+	if cv.lastFrame != nil {
+		cv.lastFrame.Free()
+		cv.lastFrame = nil
+	}
 	err := cv.Window.Close()
 	cv.Window = nil
 	return err
@@ -81,13 +94,69 @@ func (cv *OpencvWindow) ResizeWindow(width int, height int) {
 }
 
 func (cv *OpencvWindow) IMShow(frame *ffmpeg.VideoFrame, sei []*pb.PreviewInfo) {
-	if frame != nil {
-		defer frame.Free()
+	if frame == nil {
+		return
+	}
+	if cv.lastFrame != nil {
+		cv.lastFrame.Free()
 	}
+	cv.lastFrame = frame
 
-	if frame.Mat != nil {
+	if frame.Mat == nil {
+		return
+	}
+	if len(cv.hud) == 0 {
 		cv.Window.IMShow(*frame.Mat)
+		return
+	}
+	// 帧可能被多个窗口共享，在副本上绘制
+	mat := frame.Mat.Clone()
+	defer mat.Close()
+	for i, line := range cv.hud {
+		gocv.PutText(&mat, line, image.Pt(10, 30+30*i), gocv.FontHersheySimplex, 0.8, color.RGBA{255, 255, 255, 255}, 2)
+	}
+	cv.Window.IMShow(mat)
+}
+
+func (cv *OpencvWindow) SetHUD(lines []string) {
+	cv.hud = lines
+}
+
+func (cv *OpencvWindow) SetSlate(lines []string) {
+	if len(lines) == 0 {
+		if cv.lastFrame != nil && cv.lastFrame.Mat != nil {
+			cv.Window.IMShow(*cv.lastFrame.Mat)
+		}
+		return
+	}
+
+	var img image.Image
+	if cv.lastFrame != nil && cv.lastFrame.Mat != nil {
+		lastImg, err := cv.lastFrame.Mat.ToImage()
+		if err != nil {
+			log.Errorf("last frame ToImage failed: %v", err)
+		}
+		img = lastImg
 	}
+	if img == nil {
+		// 尚未收到任何帧，使用黑色背景
+		rgba := image.NewRGBA(image.Rect(0, 0, cv.Position.width, cv.Position.height))
+		draw.Draw(rgba, rgba.Bounds(), &image.Uniform{C: color.Black}, image.Point{}, draw.Src)
+		img = rgba
+	}
+
+	slate, err := text2image.DrawSlate(img, lines)
+	if err != nil {
+		log.Errorf("DrawSlate failed: %v", err)
+		return
+	}
+	mat, err := gocv.ImageToMatRGBA(slate)
+	if err != nil {
+		log.Errorf("ImageToMatRGBA failed: %v", err)
+		return
+	}
+	defer mat.Close()
+	cv.Window.IMShow(mat)
 }
 
 func (cv *OpencvWindow) WaitKey(delay int) int {
@@ -102,6 +171,10 @@ func (cv *OpencvWindow) GetDevice() Device {
 	return cv.Device
 }
 
+func (cv *OpencvWindow) SetDevice(dev Device) {
+	cv.Device = dev
+}
+
 func NewWindow(pos Position, dev Device, useOpencv bool, isCUDA bool) Window {
 	return NewOpencvWindow(pos, dev)
 }
@@ -4,11 +4,16 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"sync"
 	"time"
 	"videoplayer/config"
 
 	"videoplayer/ffmpeg"
+	"videoplayer/joy4/format/rtsp/auth"
 	"videoplayer/pb"
+	"videoplayer/rtsp"
+	"videoplayer/transport"
+	"videoplayer/util/apierr"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -26,6 +31,32 @@ const (
 	ShowWindow
 	// CloseAll 关闭所有窗口
 	CloseAll
+	// PauseWindow 暂停窗口画面
+	PauseWindow
+	// ResumeWindow 恢复窗口画面
+	ResumeWindow
+	// StepFrame 暂停状态下前进一帧
+	StepFrame
+	// SwitchStream 切换窗口的视频源
+	SwitchStream
+	// StartTour 开始窗口轮巡
+	StartTour
+	// StopTour 停止窗口轮巡
+	StopTour
+	// PauseTour 暂停窗口轮巡，停留在当前视频源
+	PauseTour
+	// ResumeTour 恢复窗口轮巡
+	ResumeTour
+	// SkipTour 立即切换到下一个视频源
+	SkipTour
+	// Seek 定位本地文件或录像的播放位置
+	Seek
+	// SetRate 设置本地文件或录像的播放倍速
+	SetRate
+	// ListWindows 查询所有窗口的状态和接收统计，结果通过 Request.Windows 返回
+	ListWindows
+	// GetDiagnostics 查询窗口打开视频源的信令记录，结果通过 Request.Diagnostics 返回
+	GetDiagnostics
 )
 
 // RequestType 表示请求的类型
@@ -36,7 +67,24 @@ type Request struct {
 	Type   RequestType
 	Device Device
 	Pos    Position
-	Err    chan error
+	// Err 请求的结果，需要带缓冲，调用方不再等待时播放器不会阻塞
+	Err chan error
+
+	// KeepDecoding 暂停直播流时是否继续解码
+	KeepDecoding bool
+	// KeepOld 切换视频源时，新源就绪前是否继续播放旧源
+	KeepOld bool
+	// Devices 轮巡的视频源列表，Dwell 为每个视频源的停留时间
+	Devices []Device
+	Dwell   time.Duration
+	// SeekTo 定位的目标时间，SeekClock 非零时按绝对时间定位录像，Rate 为播放倍速
+	SeekTo    time.Duration
+	SeekClock time.Time
+	Rate      float64
+	// Windows ListWindows 的结果，需要带缓冲
+	Windows chan []WindowInfo
+	// Diagnostics GetDiagnostics 的结果，需要带缓冲
+	Diagnostics chan Diagnostics
 }
 
 func NewRequest(requestType RequestType, device Device, position Position) Request {
@@ -53,34 +101,80 @@ type frameData struct {
 	frame       *ffmpeg.VideoFrame
 	sei         []*pb.PreviewInfo
 	receiveTime time.Time
+	// captureTime 画面采集时间，RTSP 来自 RTCP SR，其他视频源为到达时间
+	captureTime time.Time
+	// demuxer 产生该帧的 demuxer，用于区分切换中的新旧视频源
+	demuxer  *Demuxer
+	keyFrame bool
 }
 
 type State struct {
 	windowID string
 	err      error
+	demuxer  *Demuxer
+}
+
+// pendingSwitch 切换中的新视频源，armed 后首个关键帧解码时替换旧源；
+// 轮巡预连接的源在 armed 之前只接收不显示
+type pendingSwitch struct {
+	device  Device
+	demuxer *Demuxer
+	armed   bool
+}
+
+// freeze 共享视频源上单个窗口的暂停状态，stepping 时放行下一帧
+type freeze struct {
+	stepping bool
+}
+
+// slate 表示窗口需要叠加显示的状态提示
+type slate struct {
+	windowID string
+	lines    []string
 }
 
 // Player 结构体 todo 加锁
 type Player struct {
 	windows     map[string]Window
 	demuxers    map[string]*Demuxer
+	pending     map[string]*pendingSwitch
+	tours       map[string]*tour
+	frozen      map[string]*freeze
+	opening     map[string]*opening
+	openChan    chan openResult
+	sources     *sourcePool
 	commandChan chan Request
 	frameChan   chan frameData
 	stopChan    chan struct{}
 	stateChan   chan State
+	slateChan   chan slate
 
 	useOpencv bool
+
+	// diagnostics 各窗口最近一次打开视频源的信令记录，重连协程也会写入，由 diagMu 保护
+	diagMu      sync.Mutex
+	diagnostics map[string]*diagnostics
 }
 
 // NewPlayer 创建一个新的 Player 实例
 func NewPlayer() *Player {
+	frameChan := make(chan frameData, 100)
+	stateChan := make(chan State, 10)
 	return &Player{
 		windows:     make(map[string]Window),
 		demuxers:    make(map[string]*Demuxer),
+		pending:     make(map[string]*pendingSwitch),
+		tours:       make(map[string]*tour),
+		frozen:      make(map[string]*freeze),
+		opening:     make(map[string]*opening),
+		openChan:    make(chan openResult),
+		sources:     newSourcePool(frameChan, stateChan),
+		diagnostics: make(map[string]*diagnostics),
 		commandChan: make(chan Request, 10),
-		frameChan:   make(chan frameData, 100),
+		frameChan:   frameChan,
 		stopChan:    make(chan struct{}),
-		stateChan:   make(chan State, 10),
+		stateChan:   stateChan,
+		slateChan:   make(chan slate, 10),
 		useOpencv:   config.GlobalConfig.UseOpenCV,
 	}
 }
@@ -104,6 +198,9 @@ func (p *Player) Run() {
 		fmt.Printf("帧率：%.2f fps\n", fps)
 	}()
 
+	tourTicker := time.NewTicker(tourTickInterval)
+	defer tourTicker.Stop()
+
 	for {
 		select {
 		case <-p.stopChan:
@@ -116,9 +213,10 @@ func (p *Player) Run() {
 			// 处理请求
 			switch request.Type {
 			case PlayVideo:
-				err = p.playVideo(request.Device, request.Pos)
+				err = p.playVideo(request.Device, request.Pos, request.Err)
 			case CloseVideo:
 				err = p.closeVideo(request.Device.ID)
+				p.dropDiagnostics(request.Device.ID)
 			case MoveWindow:
 				err = p.moveVideo(request.Device.ID, request.Pos)
 			case HideWindow:
@@ -130,32 +228,71 @@ func (p *Player) Run() {
 
 			case ShowWindow:
 				if p.useOpencv {
-					err = p.playVideo(request.Device, request.Pos)
+					err = p.playVideo(request.Device, request.Pos, request.Err)
 				} else {
 					err = p.showVideo(request.Device, request.Pos)
 				}
 
 			case CloseAll:
 				err = p.closeAll()
+			case PauseWindow:
+				err = p.pauseVideo(request.Device.ID, request.KeepDecoding)
+			case ResumeWindow:
+				err = p.resumeVideo(request.Device.ID)
+			case StepFrame:
+				err = p.stepVideo(request.Device.ID)
+			case SwitchStream:
+				err = p.switchStream(request.Device, request.KeepOld, request.Err)
+			case StartTour:
+				err = p.startTour(request.Device.ID, request.Devices, request.Dwell, request.Pos, request.Err)
+			case StopTour:
+				err = p.stopTour(request.Device.ID)
+			case PauseTour:
+				err = p.pauseTour(request.Device.ID)
+			case ResumeTour:
+				err = p.resumeTour(request.Device.ID)
+			case SkipTour:
+				err = p.skipTour(request.Device.ID)
+			case Seek:
+				err = p.seekVideo(request.Device.ID, request.SeekTo, request.SeekClock)
+			case SetRate:
+				err = p.setRate(request.Device.ID, request.Rate)
+			case ListWindows:
+				request.Windows <- p.listWindows()
+			case GetDiagnostics:
+				var diag Diagnostics
+				diag, err = p.windowDiagnostics(request.Device.ID)
+				request.Diagnostics <- diag
 			}
-			request.Err <- err
+			// 打开视频源的请求在打开完成后回复
+			if err != errOpening {
+				request.Err <- classify(err)
+			}
+		case res := <-p.openChan:
+			p.opened(res)
 		case frame := <-p.frameChan:
 			log.Debugf("frameChan received windowID: %v,%v", frame.id, len(p.frameChan))
 			//log.Info("frameChan.len: %v", len(p.frameChan))
 			img := frame.frame
-			id := frame.id
-			window := p.windows[id]
-			// 如果当前窗口被关闭，但是frameChan中任存在该设备未播放的图片，此时window已经为nil，需要判断防止NPE
-			if window == nil || !window.IsOpen() {
-				continue
-			}
 			// 如果图像为空，则继续循环
 			if img == nil {
 				continue
 			}
+			// 同一视频源可能在多个窗口显示；窗口已关闭或已切走的帧直接释放
+			targets := p.frameTargets(frame)
+			if len(targets) == 0 {
+				img.Free()
+				continue
+			}
+			img.Share(len(targets) - 1)
 			frameCount++
 			// 在窗口中显示图像，并等待1毫秒
-			window.IMShow(img, frame.sei)
+			for _, window := range targets {
+				if window.GetDevice().HUD {
+					window.SetHUD(hudLines(frame))
+				}
+				window.IMShow(img, frame.sei)
+			}
 			// 不调用WaitKey不会显示画面
 			deley := 1
 			if !p.useOpencv {
@@ -164,12 +301,30 @@ func (p *Player) Run() {
 					time.Sleep(35*time.Millisecond - elapsed)
 				}
 			}
-			window.WaitKey(deley)
+			for _, window := range targets {
+				window.WaitKey(deley)
+			}
+		case s := <-p.slateChan:
+			p.setSlate(s.windowID, s.lines)
+		case <-tourTicker.C:
+			p.tickTours()
 		case state := <-p.stateChan:
-			// demuxer报错，重连
-			if state.err != nil {
-				log.Infof("stateChan received: %v,trying to recreate demuxer", state)
-				go p.reconnect(state.windowID)
+			if state.err == nil {
+				continue
+			}
+			p.dropStaleState(state)
+			// demuxer报错，所有使用该视频源的窗口重连
+			p.sources.evict(state.demuxer)
+			var windowIDs []string
+			for windowID, demuxer := range p.demuxers {
+				if demuxer == state.demuxer {
+					windowIDs = append(windowIDs, windowID)
+				}
+			}
+			for _, windowID := range windowIDs {
+				p.noteError(windowID, state.err)
+				log.Infof("stateChan received: %v,trying to recreate demuxer for window %v", state, windowID)
+				p.reconnect(windowID, time.Now())
 			}
 		}
 	}
@@ -200,76 +355,106 @@ func replaceTokenInURL(originalURL, newToken string) (string, error) {
 	return parsedURL.String(), nil
 }
 
-func (p *Player) reconnect(windowID string) {
-	demuxer := p.demuxers[windowID]
-	demuxer.Release()
+// isAuthError 判断错误是否由鉴权失败引起
+func isAuthError(err error) bool {
+	if errors.Is(err, config.ErrAuthFailed) {
+		return true
+	}
+	var rtspErr *rtsp.RTSPError
+	if errors.As(err, &rtspErr) {
+		return rtspErr.Code == 401 || rtspErr.Code == 403
+	}
+	var wspErr *transport.WSPError
+	if errors.As(err, &wspErr) {
+		return wspErr.Code == 401 || wspErr.Code == 403
+	}
+	return errors.Is(err, auth.ErrRejected) || errors.Is(err, auth.ErrNoCredentials)
+}
+
+// reconnect 释放窗口掉线的视频源，在协程中重连，失败后间隔 reconnectInterval 再试
+func (p *Player) reconnect(windowID string, offlineSince time.Time) {
+	p.sources.release(p.demuxers[windowID])
+	delete(p.demuxers, windowID)
 
 	w := p.windows[windowID]
 	if w == nil {
 		return
 	}
+	if op := p.opening[windowID]; op != nil && !op.preconnect {
+		// 窗口正在打开新的视频源，不再重连旧的
+		return
+	}
 
-	log.Info("attempt to recreate demuxer, %v", w.GetDevice())
-	err := RetryFunc(func() error {
-		token, err := config.GetToken()
-		if err != nil {
-			log.Errorf("get token failed,err:%v", err)
-			return err
-		}
-		newUrl, _ := replaceTokenInURL(w.GetDevice().WSURL, token)
-		log.Infof("get new wsurl:%v", newUrl)
-		dev := w.GetDevice()
-		dev.WSURL = newUrl
-		dem, err := NewDemuxer(dev.WSURL, dev.RTSPURL, p.frameChan, p.stateChan, dev.ID)
-		if err != nil {
-			log.Errorf("create demuxer failed, err: %v", err)
-			return err
-		}
-		if err = dem.Start(); err != nil {
-			log.Errorf("demuxer start failed, dev: %v,err:%v", dev, err)
-			dem.Release()
-			return err
-		}
-		p.demuxers[dev.ID] = dem
-		return nil
-	}, 120, 5*time.Second)
-	if err != nil {
-		log.Errorf("recreate demuxer failed with 5 times :%v , err : %v", w.GetDevice(), err)
+	offline := fmt.Sprintf("Offline since %s", offlineSince.Format("15:04:05"))
+	p.setSlate(windowID, []string{offline})
+
+	log.Infof("attempt to recreate demuxer, %v", w.GetDevice())
+	p.startRetry(w.GetDevice(), &retry{attempt: 1, offline: offline}, 0)
+}
+
+func (p *Player) startRetry(dev Device, r *retry, delay time.Duration) {
+	p.startOpen(&opening{dev: dev, retry: r, delay: delay, done: func(dem *Demuxer) {
+		p.attach(dev.ID, dem)
+	}})
+}
+
+// retryFailed 重连失败，提示失败原因并发起下一次重连，次数用完后关闭窗口
+func (p *Player) retryFailed(op *opening, err error) {
+	windowID := op.dev.ID
+	next := &retry{attempt: op.retry.attempt + 1, offline: op.retry.offline, authFailed: isAuthError(err)}
+	p.setSlate(windowID, next.slate())
+	if op.retry.attempt >= reconnectAttempts {
+		log.Errorf("recreate demuxer failed after %d attempts: %v, err: %v", op.retry.attempt, op.dev, err)
 		p.closeVideo(windowID)
+		return
 	}
+	log.Infof("Attempt %d failed. Retrying in %v...", op.retry.attempt, reconnectInterval)
+	p.startRetry(op.dev, next, reconnectInterval)
 }
 
-// playVideo 处理播放视频请求
-func (p *Player) playVideo(dev Device, pos Position) error {
-	var err error
-	log.Infof("Playing video for webcam %v", dev)
-	dem, err := NewDemuxer(dev.WSURL, dev.RTSPURL, p.frameChan, p.stateChan, dev.ID)
-	if err != nil {
-		log.Errorf("create demuxer failed, err: %v", err)
-		return err
-	}
-	if err = dem.Start(); err != nil {
-		dem.Release()
-		log.Errorf("demuxer start failed, dev: %v,err:%v", dev, err)
-		return err
+// setSlate 在窗口上显示状态文字
+func (p *Player) setSlate(windowID string, lines []string) {
+	window := p.windows[windowID]
+	if window == nil || !window.IsOpen() {
+		return
 	}
-	p.demuxers[dev.ID] = dem
+	window.SetSlate(lines)
+	window.WaitKey(1)
+}
+
+// playVideo 处理播放视频请求，视频源打开后创建窗口并回复 reply
+func (p *Player) playVideo(dev Device, pos Position, reply chan error) error {
+	log.Infof("Playing video for webcam %v", dev)
+	return p.open(dev, reply, func(dem *Demuxer) {
+		p.showDemuxer(dev, pos, dem)
+	})
+}
+
+// showDemuxer 用打开的视频源创建窗口
+func (p *Player) showDemuxer(dev Device, pos Position, dem *Demuxer) {
+	p.attach(dev.ID, dem)
 	p.windows[dev.ID] = NewWindow(pos, dev, dem.UseOpenCV, dem.IsCuda)
-	return nil
 }
 
 // closeVideo 处理关闭视频请求
 func (p *Player) closeVideo(windowID string) error {
 	var err error
 	log.Infof("Closing video for webcam %v", windowID)
+	p.cancelOpen(windowID)
 	demuxer := p.demuxers[windowID]
 	window := p.windows[windowID]
 	if window != nil && window.IsOpen() {
 		window.Close()
 		delete(p.windows, windowID)
 	}
+	if pending := p.pending[windowID]; pending != nil {
+		p.sources.release(pending.demuxer)
+		delete(p.pending, windowID)
+	}
+	delete(p.tours, windowID)
+	delete(p.frozen, windowID)
 	if demuxer != nil {
-		demuxer.Release()
+		p.sources.release(demuxer)
 		delete(p.demuxers, windowID)
 	}
 	return err
@@ -282,6 +467,12 @@ func (p *Player) closeAll() error {
 	for windowID := range p.windows {
 		p.closeVideo(windowID)
 	}
+	for windowID := range p.opening {
+		p.closeVideo(windowID)
+	}
+	p.diagMu.Lock()
+	p.diagnostics = make(map[string]*diagnostics)
+	p.diagMu.Unlock()
 	return err
 }
 
@@ -291,7 +482,7 @@ func (p *Player) hideVideo(windowID string) error {
 	log.Infof("hide video for webcam %v", windowID)
 	window := p.windows[windowID]
 	if window == nil || !window.IsOpen() {
-		return fmt.Errorf("windowID: %v not exist", windowID)
+		return apierr.New(apierr.NotFound, "windowID: %v not exist", windowID)
 	}
 	// todo 窗口取消固定最前
 	window.Hide()
@@ -307,7 +498,7 @@ func (p *Player) showVideo(dev Device, pos Position) error {
 	log.Infof("show video for webcam %v", windowID)
 	window := p.windows[windowID]
 	if window == nil || !window.IsOpen() {
-		return fmt.Errorf("windowID: %v not exist", windowID)
+		return apierr.New(apierr.NotFound, "windowID: %v not exist", windowID)
 	}
 	// todo 窗口固定最前
 	window.Show()
@@ -322,23 +513,179 @@ func (p *Player) moveVideo(windowID string, pos Position) error {
 	log.Infof("Moving video for webcam %d, Pos: %v", windowID, pos)
 	window := p.windows[windowID]
 	if window == nil || !window.IsOpen() {
-		return fmt.Errorf("windowID: %v not exist", windowID)
+		return apierr.New(apierr.NotFound, "windowID: %v not exist", windowID)
 	}
 	window.MoveWindow(pos.x, pos.y)
 	window.ResizeWindow(pos.width, pos.height)
 	return err
 }
 
-// RetryFunc 尝试执行函数，最多重试 maxAttempts 次，每次间隔 interval 时间
-func RetryFunc(fn func() error, maxAttempts int, interval time.Duration) error {
-	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		if err := fn(); err == nil {
-			return nil // 函数执行成功，无需重试
+// pauseVideo 处理暂停请求，直播流冻结画面但继续接收。
+// 视频源被多个窗口共享时只冻结当前窗口，不影响其他窗口
+func (p *Player) pauseVideo(windowID string, keepDecoding bool) error {
+	log.Infof("pause video for webcam %v, keepDecoding: %v", windowID, keepDecoding)
+	demuxer := p.demuxers[windowID]
+	if demuxer == nil {
+		return apierr.New(apierr.NotFound, "windowID: %v not exist", windowID)
+	}
+	if p.sources.shared(demuxer) {
+		if p.frozen[windowID] == nil {
+			p.frozen[windowID] = &freeze{}
+		}
+		return nil
+	}
+	return demuxer.Pause(keepDecoding)
+}
+
+// resumeVideo 处理恢复播放请求
+func (p *Player) resumeVideo(windowID string) error {
+	log.Infof("resume video for webcam %v", windowID)
+	demuxer := p.demuxers[windowID]
+	if demuxer == nil {
+		return apierr.New(apierr.NotFound, "windowID: %v not exist", windowID)
+	}
+	if p.frozen[windowID] != nil {
+		delete(p.frozen, windowID)
+		return nil
+	}
+	return demuxer.Resume()
+}
+
+// stepVideo 处理单帧步进请求
+func (p *Player) stepVideo(windowID string) error {
+	log.Infof("step video for webcam %v", windowID)
+	demuxer := p.demuxers[windowID]
+	if demuxer == nil {
+		return apierr.New(apierr.NotFound, "windowID: %v not exist", windowID)
+	}
+	if f := p.frozen[windowID]; f != nil {
+		f.stepping = true
+		return nil
+	}
+	return demuxer.Step()
+}
+
+// seekVideo 处理定位请求，定位到目标之前最近的关键帧，clock 非零时按绝对时间定位
+func (p *Player) seekVideo(windowID string, to time.Duration, clock time.Time) error {
+	demuxer := p.demuxers[windowID]
+	if demuxer == nil {
+		return apierr.New(apierr.NotFound, "windowID: %v not exist", windowID)
+	}
+	if !clock.IsZero() {
+		log.Infof("seek video for webcam %v to %v", windowID, clock)
+		return demuxer.SeekClock(clock)
+	}
+	log.Infof("seek video for webcam %v to %v", windowID, to)
+	return demuxer.Seek(to)
+}
+
+// setRate 处理倍速播放请求
+func (p *Player) setRate(windowID string, rate float64) error {
+	log.Infof("set rate for webcam %v to %v", windowID, rate)
+	demuxer := p.demuxers[windowID]
+	if demuxer == nil {
+		return apierr.New(apierr.NotFound, "windowID: %v not exist", windowID)
+	}
+	return demuxer.SetRate(rate)
+}
+
+// attach 窗口开始使用 demuxer。加入一个已被单窗口暂停的共享源时，
+// 把原来的暂停转为窗口级冻结，新窗口正常播放
+func (p *Player) attach(windowID string, dem *Demuxer) {
+	if p.sources.shared(dem) && dem.Paused() {
+		for id, demuxer := range p.demuxers {
+			if demuxer == dem && p.frozen[id] == nil {
+				p.frozen[id] = &freeze{}
+			}
+		}
+		if err := dem.Resume(); err != nil {
+			log.Errorf("resume shared source for window %v failed: %v", windowID, err)
 		}
+	}
+	p.demuxers[windowID] = dem
+	delete(p.frozen, windowID)
+}
 
-		log.Infof("Attempt %d failed. Retrying in %v...", attempt, interval)
-		time.Sleep(interval)
+// frameTargets 需要显示该帧的窗口：当前视频源为该 demuxer 的窗口，
+// 以及在该关键帧处切换到它的窗口；冻结的窗口只在单步时放行
+func (p *Player) frameTargets(frame frameData) []Window {
+	var targets []Window
+	for windowID, window := range p.windows {
+		// 如果当前窗口被关闭，但是frameChan中任存在该设备未播放的图片，需要判断防止NPE
+		if window == nil || !window.IsOpen() {
+			continue
+		}
+		if p.demuxers[windowID] != frame.demuxer && !p.cutover(windowID, window, frame) {
+			continue
+		}
+		if f := p.frozen[windowID]; f != nil {
+			if !f.stepping {
+				continue
+			}
+			f.stepping = false
+		}
+		targets = append(targets, window)
 	}
+	return targets
+}
 
-	return errors.New("Max attempts reached, function still failed")
+// switchStream 切换窗口的视频源而不重建窗口。先启动新的 demuxer，
+// keepOld 为 true 时旧源继续播放到新源首个关键帧，否则冻结最后一帧直到新源出图
+func (p *Player) switchStream(dev Device, keepOld bool, reply chan error) error {
+	log.Infof("switch stream for webcam %v, keepOld: %v", dev, keepOld)
+	window := p.windows[dev.ID]
+	if window == nil || !window.IsOpen() {
+		return apierr.New(apierr.NotFound, "windowID: %v not exist", dev.ID)
+	}
+	if pending := p.pending[dev.ID]; pending != nil {
+		p.sources.release(pending.demuxer)
+		delete(p.pending, dev.ID)
+	}
+	return p.open(dev, reply, func(dem *Demuxer) {
+		p.switchDemuxer(dev, keepOld, window, dem)
+	})
+}
+
+// switchDemuxer 窗口切换到打开的视频源
+func (p *Player) switchDemuxer(dev Device, keepOld bool, window Window, dem *Demuxer) {
+	if keepOld {
+		p.pending[dev.ID] = &pendingSwitch{device: dev, demuxer: dem, armed: true}
+		return
+	}
+	p.sources.release(p.demuxers[dev.ID])
+	p.attach(dev.ID, dem)
+	window.SetDevice(dev)
+	window.SetSlate([]string{"Switching…"})
+	window.WaitKey(1)
+}
+
+// cutover 新视频源的首个关键帧到达时替换旧源，返回该帧是否可以显示
+func (p *Player) cutover(windowID string, window Window, frame frameData) bool {
+	pending := p.pending[windowID]
+	if pending == nil || pending.demuxer != frame.demuxer || !pending.armed || !frame.keyFrame {
+		return false
+	}
+	log.Infof("cut over window %v to %v", windowID, pending.device)
+	p.setDiagnostics(pending.device, pending.demuxer.signal, nil)
+	p.sources.release(p.demuxers[windowID])
+	p.attach(windowID, pending.demuxer)
+	window.SetDevice(pending.device)
+	delete(p.pending, windowID)
+	return true
+}
+
+// dropStaleState 切换中的新源出错则放弃切换
+func (p *Player) dropStaleState(state State) {
+	for windowID, pending := range p.pending {
+		if pending.demuxer != state.demuxer {
+			continue
+		}
+		log.Errorf("switch stream for window %v failed: %v", windowID, state.err)
+		p.sources.release(pending.demuxer)
+		delete(p.pending, windowID)
+		if t := p.tours[windowID]; t != nil && !pending.armed {
+			// 预连接的源中途断开，跳过它
+			t.skipNext()
+		}
+	}
 }
@@ -166,6 +166,98 @@ func (s *SDLWindow) drawText(input string, point image.Point) {
 
 }
 
+// renderSlate 重绘最后一帧，压暗后在中央绘制状态文字
+func (s *SDLWindow) renderSlate() {
+	if s.renderer == nil {
+		return
+	}
+	s.renderer.Clear()
+	if s.texture != nil {
+		s.renderer.Copy(s.texture, nil, nil)
+	}
+	if len(s.slate) > 0 {
+		s.renderer.SetDrawBlendMode(sdl.BLENDMODE_BLEND)
+		s.renderer.SetDrawColor(0, 0, 0, 160)
+		s.renderer.FillRect(nil)
+		s.renderer.SetDrawBlendMode(sdl.BLENDMODE_NONE)
+		s.drawSlateText(s.slate)
+	}
+	s.renderer.Present()
+}
+
+func (s *SDLWindow) drawSlateText(lines []string) {
+	var err error
+	if s.slateFont == nil {
+		if s.slateFont, err = ttf.OpenFont("song.ttf", 32); err != nil {
+			log.Errorf("Failed to open slate font: %v", err)
+			return
+		}
+	}
+
+	width, height := s.Window.GetSize()
+	lineHeight := int32(48)
+	top := height/2 - lineHeight*int32(len(lines))/2
+	for i, line := range lines {
+		textSurface, err := s.slateFont.RenderUTF8Blended(line, sdl.Color{R: 255, G: 255, B: 255, A: 255})
+		if err != nil {
+			continue
+		}
+		textTexture, err := s.renderer.CreateTextureFromSurface(textSurface)
+		if err != nil {
+			textSurface.Free()
+			log.Errorf("Failed to create texture from surface: %v", err)
+			continue
+		}
+		textRect := &sdl.Rect{
+			X: (width - textSurface.W) / 2,
+			Y: top + lineHeight*int32(i),
+			W: textSurface.W,
+			H: textSurface.H,
+		}
+		s.renderer.Copy(textTexture, nil, textRect)
+		textTexture.Destroy()
+		textSurface.Free()
+	}
+}
+
+// drawHUD 在左上角半透明底色上绘制信息行
+func (s *SDLWindow) drawHUD(lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	var err error
+	if s.hudFont == nil {
+		if s.hudFont, err = ttf.OpenFont("song.ttf", 18); err != nil {
+			log.Errorf("Failed to open hud font: %v", err)
+			return
+		}
+	}
+
+	const margin, lineHeight = int32(8), int32(24)
+	y := margin
+	for _, line := range lines {
+		textSurface, err := s.hudFont.RenderUTF8Blended(line, sdl.Color{R: 255, G: 255, B: 255, A: 255})
+		if err != nil {
+			continue
+		}
+		textTexture, err := s.renderer.CreateTextureFromSurface(textSurface)
+		if err != nil {
+			textSurface.Free()
+			log.Errorf("Failed to create texture from surface: %v", err)
+			continue
+		}
+		textRect := &sdl.Rect{X: margin, Y: y, W: textSurface.W, H: textSurface.H}
+		s.renderer.SetDrawBlendMode(sdl.BLENDMODE_BLEND)
+		s.renderer.SetDrawColor(0, 0, 0, 128)
+		s.renderer.FillRect(&sdl.Rect{X: 0, Y: y - 2, W: textSurface.W + 2*margin, H: lineHeight})
+		s.renderer.SetDrawBlendMode(sdl.BLENDMODE_NONE)
+		s.renderer.Copy(textTexture, nil, textRect)
+		textTexture.Destroy()
+		textSurface.Free()
+		y += lineHeight
+	}
+}
+
 func (s *SDLWindow) drawRect(rects []sdl.Rect, color sdl.Color) {
 	s.renderer.SetDrawColor(color.R, color.G, color.B, color.A)
 	s.renderer.DrawRects(rects)
@@ -33,13 +33,19 @@ type SDLWindow struct {
 	renderer      *sdl.Renderer
 	texture       *sdl.Texture
 	isCudaSupport bool
-	once          sync.Once
 
 	frameWidth  int
 	frameHeight int
 	scaleX      float32
 	scaleY      float32
 	font        *ttf.Font
+
+	// slate 当前叠加的状态文字，收到新帧时清除
+	slate     []string
+	slateFont *ttf.Font
+	// hud 每帧叠加在左上角的信息行
+	hud     []string
+	hudFont *ttf.Font
 }
 
 func NewSDLWindow(pos Position, dev Device, isCuda bool) *SDLWindow {
@@ -75,6 +81,16 @@ func NewSDLWindow(pos Position, dev Device, isCuda bool) *SDLWindow {
 func (s *SDLWindow) initWindow(width, height int) {
 	var err error
 
+	// 切换视频源后分辨率可能变化，需要重建纹理和字体
+	if s.texture != nil {
+		s.texture.Destroy()
+		s.texture = nil
+	}
+	if s.font != nil {
+		s.font.Close()
+		s.font = nil
+	}
+
 	if s.isCudaSupport {
 		s.texture, err = s.renderer.CreateTexture(uint32(C.SDL_PIXELFORMAT_NV12), sdl.TEXTUREACCESS_STREAMING, int32(width), int32(height))
 	} else {
@@ -100,6 +116,12 @@ func (s *SDLWindow) initWindow(width, height int) {
 func (s *SDLWindow) Close() error {
 	var err error
 	sdl.Do(func() {
+		if s.slateFont != nil {
+			s.slateFont.Close()
+		}
+		if s.hudFont != nil {
+			s.hudFont.Close()
+		}
 		s.font.Close()
 		s.texture.Destroy()
 		s.renderer.Destroy()
@@ -150,19 +172,22 @@ func (s *SDLWindow) IMShow(frame *ffmpeg.VideoFrame, sei []*pb.PreviewInfo) {
 	}
 
 	var err error
+	s.slate = nil
 
 	sdl.Do(func() {
 		if frame.NV12 != nil {
 			nv12 := frame.NV12
-			s.once.Do(func() {
+			if s.texture == nil || !s.isCudaSupport || nv12.Width != s.frameWidth || nv12.Height != s.frameHeight {
+				s.isCudaSupport = true
 				s.initWindow(nv12.Width, nv12.Height)
-			})
+			}
 			err = s.texture.UpdateNV(nil, nv12.YPlane, nv12.YPitch, nv12.UVPlane, nv12.UVPitch)
 		} else if frame.YUV != nil {
 			yuv := frame.YUV
-			s.once.Do(func() {
+			if s.texture == nil || s.isCudaSupport || yuv.Width != s.frameWidth || yuv.Height != s.frameHeight {
+				s.isCudaSupport = false
 				s.initWindow(yuv.Width, yuv.Height)
-			})
+			}
 			err = s.texture.UpdateYUV(nil, yuv.YPlane, yuv.YPitch, yuv.UPlane, yuv.UPitch, yuv.VPlane, yuv.VPitch)
 		}
 		if err != nil {
@@ -185,12 +210,24 @@ func (s *SDLWindow) IMShow(frame *ffmpeg.VideoFrame, sei []*pb.PreviewInfo) {
 		}
 
 		s.drawOverlayImage(sei)
+		s.drawHUD(s.hud)
 
 		// Present screen
 		s.renderer.Present()
 	})
 }
 
+func (s *SDLWindow) SetSlate(lines []string) {
+	s.slate = lines
+	sdl.Do(func() {
+		s.renderSlate()
+	})
+}
+
+func (s *SDLWindow) SetHUD(lines []string) {
+	s.hud = lines
+}
+
 func (s *SDLWindow) WaitKey(delay int) int {
 	var err error
 	sdl.Do(func() {
@@ -231,6 +268,10 @@ func (s *SDLWindow) GetDevice() Device {
 	return s.Device
 }
 
+func (s *SDLWindow) SetDevice(dev Device) {
+	s.Device = dev
+}
+
 func (cv *SDLWindow) GetType() string {
 	return "sdl"
 }
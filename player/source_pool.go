@@ -0,0 +1,153 @@
+package player
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"videoplayer/transport"
+)
+
+// releaseTimeout 关闭视频源时等待 TEARDOWN 响应的最长时间
+const releaseTimeout = 3 * time.Second
+
+// sourcePool 按视频源地址复用 demuxer，同一路流在多个窗口显示时只拉流、解码一次。
+// 可定位的录像各窗口进度不同，不共享
+type sourcePool struct {
+	mu        sync.Mutex
+	demuxers  map[string]*Demuxer
+	refs      map[*Demuxer]int
+	frameChan chan frameData
+	stateChan chan State
+}
+
+func newSourcePool(frameChan chan frameData, stateChan chan State) *sourcePool {
+	return &sourcePool{
+		demuxers:  make(map[string]*Demuxer),
+		refs:      make(map[*Demuxer]int),
+		frameChan: frameChan,
+		stateChan: stateChan,
+	}
+}
+
+// sourceKey 视频源的唯一标识，去掉会随刷新变化的鉴权参数
+func sourceKey(dev Device) string {
+	return stripToken(dev.WSURL) + "|" + stripToken(dev.RTSPURL)
+}
+
+func stripToken(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := parsedURL.Query()
+	query.Del("jwt")
+	query.Del("token")
+	parsedURL.RawQuery = query.Encode()
+	return parsedURL.String()
+}
+
+// acquire 获取视频源的 demuxer，已有其他窗口在播放时直接复用。
+// 新建的视频源把信令记录到 signal，复用时沿用原有的记录。
+// 打开视频源时不持有锁，ctx 结束时放弃打开
+func (s *sourcePool) acquire(ctx context.Context, dev Device, signal *transport.SignalLog) (*Demuxer, error) {
+	key := sourceKey(dev)
+	if dem := s.share(key, dev.ID); dem != nil {
+		return dem, nil
+	}
+
+	opts := dev.sourceOptions()
+	opts.Signal = signal
+	dem, err := NewDemuxer(dev.WSURL, dev.RTSPURL, opts, s.frameChan, s.stateChan, dev.ID)
+	if err != nil {
+		log.Errorf("create demuxer failed, err: %v", err)
+		return nil, err
+	}
+	dem.signal = signal
+	dem.SetLoop(dev.Loop)
+	if err = dem.Start(ctx); err != nil {
+		s.close(dem)
+		log.Errorf("demuxer start failed, dev: %v,err:%v", dev, err)
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if !dem.Seekable() {
+		// 打开期间其他窗口已打开同一视频源，改用它
+		if shared := s.demuxers[key]; shared != nil {
+			s.refs[shared]++
+			refs := s.refs[shared]
+			s.mu.Unlock()
+			log.Infof("share source %v with window %v, refs: %v", key, dev.ID, refs)
+			s.close(dem)
+			return shared, nil
+		}
+		dem.key = key
+		s.demuxers[key] = dem
+	}
+	s.refs[dem] = 1
+	s.mu.Unlock()
+	return dem, nil
+}
+
+// share 复用已打开的视频源
+func (s *sourcePool) share(key, windowID string) *Demuxer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dem := s.demuxers[key]
+	if dem != nil {
+		s.refs[dem]++
+		log.Infof("share source %v with window %v, refs: %v", key, windowID, s.refs[dem])
+	}
+	return dem
+}
+
+// release 窗口不再使用该 demuxer，最后一个窗口释放时在协程中关闭视频源
+func (s *sourcePool) release(dem *Demuxer) {
+	if dem == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.refs[dem]; !ok {
+		return
+	}
+	s.refs[dem]--
+	if s.refs[dem] > 0 {
+		return
+	}
+	delete(s.refs, dem)
+	if s.demuxers[dem.key] == dem {
+		delete(s.demuxers, dem.key)
+	}
+	s.close(dem)
+}
+
+// close 在协程中关闭 demuxer，不阻塞播放器的主循环
+func (s *sourcePool) close(dem *Demuxer) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+		defer cancel()
+		dem.Release(ctx)
+	}()
+}
+
+// evict 视频源出错，后续 acquire 重新建立连接；已持有的窗口仍需各自 release
+func (s *sourcePool) evict(dem *Demuxer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.demuxers[dem.key] == dem {
+		delete(s.demuxers, dem.key)
+	}
+}
+
+// shared 该 demuxer 是否被多个窗口使用
+func (s *sourcePool) shared(dem *Demuxer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refs[dem] > 1
+}
@@ -0,0 +1,130 @@
+package player
+
+import (
+	"fmt"
+	"time"
+
+	"videoplayer/source"
+)
+
+// statsInterval 接收统计的刷新间隔
+const statsInterval = time.Second
+
+// StreamStats 单路 RTP 流的接收统计
+type StreamStats struct {
+	Index       int     `json:"index"`
+	Received    uint32  `json:"received"`
+	Lost        int64   `json:"lost"`
+	LossPercent float64 `json:"lossPercent"`
+	JitterMs    float64 `json:"jitterMs"`
+	OutOfOrder  uint32  `json:"outOfOrder"`
+	Bytes       uint64  `json:"bytes"`
+	// Bitrate 最近一个统计周期的码率（bit/s）
+	Bitrate int64 `json:"bitrate"`
+}
+
+// Stats 视频源的接收统计，Bytes/Bitrate 为解复用后的包数据，
+// Streams 仅 RTSP 视频源提供
+type Stats struct {
+	Bytes   uint64        `json:"bytes"`
+	Bitrate int64         `json:"bitrate"`
+	Streams []StreamStats `json:"streams,omitempty"`
+}
+
+// WindowInfo 窗口状态，用于列表查询
+type WindowInfo struct {
+	ID      string `json:"id"`
+	URL     string `json:"url"`
+	X       int    `json:"x"`
+	Y       int    `json:"y"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Paused  bool   `json:"paused"`
+	Touring bool   `json:"touring"`
+	Stats   Stats  `json:"stats"`
+}
+
+func bitrate(bytes, prevBytes uint64, elapsed time.Duration) int64 {
+	if elapsed <= 0 || bytes < prevBytes {
+		return 0
+	}
+	return int64(float64(bytes-prevBytes) * 8 / elapsed.Seconds())
+}
+
+// updateStats 刷新接收统计，只在 run 协程中调用
+func (d *Demuxer) updateStats(now time.Time) {
+	elapsed := now.Sub(d.statsAt)
+	if d.statsAt.IsZero() {
+		elapsed = 0
+	}
+	prev := d.stats
+	stats := Stats{
+		Bytes:   d.bytes,
+		Bitrate: bitrate(d.bytes, prev.Bytes, elapsed),
+	}
+	if reporter, ok := d.src.(source.StatsReporter); ok {
+		for i, st := range reporter.Stats() {
+			ss := StreamStats{
+				Index:       i,
+				Received:    st.Received,
+				Lost:        st.Lost,
+				LossPercent: st.LossPercent(),
+				JitterMs:    float64(st.Jitter) / float64(time.Millisecond),
+				OutOfOrder:  st.OutOfOrder,
+				Bytes:       st.Bytes,
+			}
+			if i < len(prev.Streams) {
+				ss.Bitrate = bitrate(st.Bytes, prev.Streams[i].Bytes, elapsed)
+			}
+			stats.Streams = append(stats.Streams, ss)
+		}
+	}
+
+	d.statsMu.Lock()
+	d.stats = stats
+	d.statsMu.Unlock()
+	d.statsAt = now
+}
+
+// Stats 最近一次刷新的接收统计
+func (d *Demuxer) Stats() Stats {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+	stats := d.stats
+	stats.Streams = append([]StreamStats(nil), d.stats.Streams...)
+	return stats
+}
+
+// hudStats 视频流的接收统计，用于 HUD 显示
+func hudStats(stats Stats, videoIdx int) string {
+	for _, ss := range stats.Streams {
+		if ss.Index == videoIdx {
+			return fmt.Sprintf("loss %.1f%%  jitter %.0fms  ooo %d  %.2fMbps",
+				ss.LossPercent, ss.JitterMs, ss.OutOfOrder, float64(ss.Bitrate)/1e6)
+		}
+	}
+	return fmt.Sprintf("%.2fMbps", float64(stats.Bitrate)/1e6)
+}
+
+// listWindows 返回所有窗口的状态和接收统计
+func (p *Player) listWindows() []WindowInfo {
+	infos := make([]WindowInfo, 0, len(p.windows))
+	for id, window := range p.windows {
+		pos := window.GetPosition()
+		info := WindowInfo{
+			ID:      id,
+			URL:     window.GetDevice().RTSPURL,
+			X:       pos.x,
+			Y:       pos.y,
+			Width:   pos.width,
+			Height:  pos.height,
+			Touring: p.tours[id] != nil,
+		}
+		if demuxer := p.demuxers[id]; demuxer != nil {
+			info.Paused = demuxer.Paused() || p.frozen[id] != nil
+			info.Stats = demuxer.Stats()
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
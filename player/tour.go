@@ -0,0 +1,183 @@
+package player
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"videoplayer/util/apierr"
+)
+
+const (
+	// tourTickInterval 轮巡检查间隔
+	tourTickInterval = 500 * time.Millisecond
+	// tourPreconnect 停留结束前多久预连接下一个视频源
+	tourPreconnect = 3 * time.Second
+)
+
+// tour 窗口轮巡状态。下一个视频源在当前源停留结束前预连接，
+// 到点后在其首个关键帧处切换，避免切换时出现黑屏
+type tour struct {
+	devices   []Device
+	dwell     time.Duration
+	index     int           // 当前播放的视频源下标
+	next      int           // 下一个视频源下标
+	deadline  time.Time     // 当前视频源停留截止时间
+	paused    bool          // 暂停后停留在当前视频源
+	remaining time.Duration // 暂停时剩余的停留时间
+}
+
+// startTour 开始窗口轮巡，窗口不存在时以第一个视频源创建窗口，第一个视频源打开后开始计时
+func (p *Player) startTour(windowID string, devices []Device, dwell time.Duration, pos Position, reply chan error) error {
+	log.Infof("start tour for webcam %v, devices: %v, dwell: %v", windowID, len(devices), dwell)
+	if len(devices) == 0 {
+		return apierr.New(apierr.InvalidRequest, "tour for windowID: %v has no devices", windowID)
+	}
+	if dwell <= 0 {
+		return apierr.New(apierr.InvalidRequest, "tour for windowID: %v has invalid dwell %v", windowID, dwell)
+	}
+	for i := range devices {
+		devices[i].ID = windowID
+	}
+
+	window := p.windows[windowID]
+	if window != nil && !window.IsOpen() {
+		window = nil
+	}
+	if pending := p.pending[windowID]; pending != nil && window != nil {
+		p.sources.release(pending.demuxer)
+		delete(p.pending, windowID)
+	}
+	return p.open(devices[0], reply, func(dem *Demuxer) {
+		if window == nil {
+			p.showDemuxer(devices[0], pos, dem)
+		} else {
+			p.switchDemuxer(devices[0], true, window, dem)
+		}
+		p.tours[windowID] = &tour{
+			devices:  devices,
+			dwell:    dwell,
+			next:     1 % len(devices),
+			deadline: time.Now().Add(dwell),
+		}
+	})
+}
+
+// stopTour 停止窗口轮巡，窗口停留在当前视频源
+func (p *Player) stopTour(windowID string) error {
+	log.Infof("stop tour for webcam %v", windowID)
+	if p.tours[windowID] == nil {
+		return apierr.New(apierr.NotFound, "windowID: %v has no tour", windowID)
+	}
+	delete(p.tours, windowID)
+	p.dropPreconnect(windowID)
+	return nil
+}
+
+// pauseTour 暂停窗口轮巡，记录剩余停留时间
+func (p *Player) pauseTour(windowID string) error {
+	log.Infof("pause tour for webcam %v", windowID)
+	t := p.tours[windowID]
+	if t == nil {
+		return apierr.New(apierr.NotFound, "windowID: %v has no tour", windowID)
+	}
+	if t.paused {
+		return nil
+	}
+	t.paused = true
+	t.remaining = time.Until(t.deadline)
+	p.dropPreconnect(windowID)
+	return nil
+}
+
+// resumeTour 恢复窗口轮巡，继续暂停前剩余的停留时间
+func (p *Player) resumeTour(windowID string) error {
+	log.Infof("resume tour for webcam %v", windowID)
+	t := p.tours[windowID]
+	if t == nil {
+		return apierr.New(apierr.NotFound, "windowID: %v has no tour", windowID)
+	}
+	if !t.paused {
+		return nil
+	}
+	t.paused = false
+	t.deadline = time.Now().Add(t.remaining)
+	return nil
+}
+
+// skipTour 结束当前视频源的停留，立即切换到下一个视频源
+func (p *Player) skipTour(windowID string) error {
+	log.Infof("skip tour for webcam %v", windowID)
+	t := p.tours[windowID]
+	if t == nil {
+		return apierr.New(apierr.NotFound, "windowID: %v has no tour", windowID)
+	}
+	t.deadline = time.Now()
+	if t.paused {
+		t.paused = false
+	}
+	p.tickTour(windowID, t)
+	return nil
+}
+
+// tickTours 推进所有窗口的轮巡
+func (p *Player) tickTours() {
+	for windowID, t := range p.tours {
+		p.tickTour(windowID, t)
+	}
+}
+
+// tickTour 停留快结束时预连接下一个视频源，到点后标记切换。
+// 窗口正在打开视频源时等待打开完成
+func (p *Player) tickTour(windowID string, t *tour) {
+	if t.paused || len(t.devices) < 2 || p.opening[windowID] != nil {
+		return
+	}
+	now := time.Now()
+	if p.pending[windowID] == nil && !now.Before(t.deadline.Add(-tourPreconnect)) {
+		p.preconnect(windowID, t)
+	}
+	if now.Before(t.deadline) || p.opening[windowID] != nil {
+		return
+	}
+	pending := p.pending[windowID]
+	if pending == nil {
+		// 所有候选源都连接失败，留在当前源，下个周期再试
+		t.deadline = now.Add(t.dwell)
+		return
+	}
+	if pending.armed {
+		return
+	}
+	pending.armed = true
+	t.index = t.next
+	t.next = (t.index + 1) % len(t.devices)
+	t.deadline = now.Add(t.dwell)
+}
+
+// preconnect 在协程中预连接下一个视频源，连接失败的源被跳过，下个周期连接再下一个
+func (p *Player) preconnect(windowID string, t *tour) {
+	dev := t.devices[t.next]
+	p.startOpen(&opening{dev: dev, preconnect: true, done: func(dem *Demuxer) {
+		p.pending[windowID] = &pendingSwitch{device: dev, demuxer: dem}
+	}})
+}
+
+// skipNext 跳过下一个视频源，不会选中当前正在播放的源
+func (t *tour) skipNext() {
+	t.next = (t.next + 1) % len(t.devices)
+	if t.next == t.index {
+		t.next = (t.next + 1) % len(t.devices)
+	}
+}
+
+// dropPreconnect 取消正在进行的预连接，释放尚未切换的预连接视频源
+func (p *Player) dropPreconnect(windowID string) {
+	if op := p.opening[windowID]; op != nil && op.preconnect {
+		p.cancelOpen(windowID)
+	}
+	if pending := p.pending[windowID]; pending != nil && !pending.armed {
+		p.sources.release(pending.demuxer)
+		delete(p.pending, windowID)
+	}
+}
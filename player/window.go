@@ -1,14 +1,51 @@
 package player
 
 import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"time"
+	"videoplayer/config"
 	"videoplayer/ffmpeg"
 	"videoplayer/pb"
+	"videoplayer/source"
 )
 
 type Device struct {
 	ID      string
 	WSURL   string
 	RTSPURL string
+	// Loop 本地文件播放结束后从头开始
+	Loop bool
+	// HUD 窗口角落显示画面采集时间等信息
+	HUD bool
+	// Transport 直连 RTSP 的传输方式（tcp/udp/multicast/http），UDPTimeout 为 UDP 收不到数据时回退到 TCP 的等待时间
+	Transport  string
+	UDPTimeout time.Duration
+	// Record 把 RTSP 会话录制到 record_dir，每次连接一个文件，可作为视频源回放；
+	// 文件名带流的采集开始时间（RTCP SR），摄像机没有发送 SR 时为本机开始录制的时间
+	Record bool
+}
+
+func (d Device) sourceOptions() source.Options {
+	opts := source.Options{
+		Transport:  source.Transport(d.Transport),
+		UDPTimeout: d.UDPTimeout,
+		// WSP 代理和平台接口使用同一份 TLS 和出站代理配置，token 过期前自动刷新，无需整体重连
+		ProxyTLSConfig: config.GlobalConfig.TLSConfig(),
+		HTTPProxy:      config.GlobalConfig.ProxyFunc(),
+		TokenSource:    config.GetToken,
+	}
+	if dir := config.GlobalConfig.RecordDir; d.Record && dir != "" {
+		opts.Record = filepath.Join(dir, d.recordName(time.Now()))
+		opts.RecordName = d.recordName
+	}
+	return opts
+}
+
+// recordName 录制文件名，start 为录制开始时间
+func (d Device) recordName(start time.Time) string {
+	return fmt.Sprintf("%s-%s.wsprec", url.PathEscape(d.ID), start.Local().Format("20060102-150405"))
 }
 
 func NewDevice(id string, wsURL, rtspURL string) Device {
@@ -43,5 +80,11 @@ type Window interface {
 	WaitKey(delay int) int
 	GetPosition() Position
 	GetDevice() Device
+	// SetDevice 切换视频源后更新窗口对应的设备
+	SetDevice(dev Device)
 	GetType() string
+	// SetSlate 在最后一帧上压暗并叠加状态文字，传空值清除；收到新帧时自动清除
+	SetSlate(lines []string)
+	// SetHUD 设置每帧叠加在左上角的信息行，传空值清除
+	SetHUD(lines []string)
 }
@@ -9,6 +9,9 @@ import (
 var (
 	ErrBadServer         = errors.New("rtsp bad server")
 	ErrNoSupportedStream = errors.New("rtsp no supported stream")
+	ErrKeepaliveTimeout  = errors.New("rtsp keepalive timeout")
+	ErrTimeout           = errors.New("rtsp or rtp timeout")
+	ErrNoUDPPortPair     = errors.New("no available udp port pairs")
 )
 
 type RTSPError struct {
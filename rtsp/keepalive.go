@@ -0,0 +1,106 @@
+package rtsp
+
+import (
+	"time"
+
+	"videoplayer/joy4/format/rtsp/auth"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// startKeepalive 启动会话保活，每隔 RtpKeepAliveTimeout（由 Session 头的 timeout
+// 协商）发送一次 GET_PARAMETER 或 OPTIONS，暂停期间同样保活
+func (self *Client) startKeepalive() {
+	interval := self.RtpKeepAliveTimeout
+	if interval <= 0 {
+		return
+	}
+	self.kaMu.Lock()
+	defer self.kaMu.Unlock()
+	if self.kaStop != nil {
+		return
+	}
+	self.kaStop = make(chan struct{})
+	self.kaErr = nil
+	go self.keepaliveLoop(interval, self.kaStop)
+}
+
+func (self *Client) stopKeepalive() {
+	self.kaMu.Lock()
+	defer self.kaMu.Unlock()
+	if self.kaStop != nil {
+		close(self.kaStop)
+		self.kaStop = nil
+	}
+}
+
+// keepaliveError 保活失败的原因，读包时作为流错误返回
+func (self *Client) keepaliveError() error {
+	self.kaMu.Lock()
+	defer self.kaMu.Unlock()
+	return self.kaErr
+}
+
+func (self *Client) keepaliveLoop(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		// 一个周期内没有收到响应视为会话已失效
+		done := make(chan error, 1)
+		go func() { done <- self.sendKeepalive() }()
+		var err error
+		select {
+		case <-stop:
+			return
+		case err = <-done:
+		case <-time.After(interval):
+			err = ErrKeepaliveTimeout
+		}
+		if err != nil {
+			log.Errorf("rtsp keepalive failed: %v", err)
+			self.kaMu.Lock()
+			if self.kaStop == stop {
+				self.kaErr = err
+				self.kaStop = nil
+			}
+			self.kaMu.Unlock()
+			return
+		}
+	}
+}
+
+// sendKeepalive 服务端支持 GET_PARAMETER 时优先使用，否则发送 OPTIONS，
+// 参考 FFmpeg rtspdec.c 的 rtsp_read_packet。nonce 过期时更新后重发一次
+func (self *Client) sendKeepalive() error {
+	method := "OPTIONS"
+	if self.sessionId != "" && self.isMethodSupported("GET_PARAMETER") {
+		method = "GET_PARAMETER"
+	}
+	log.Debugf("rtsp keepalive: %v", method)
+	for i := 0; ; i++ {
+		req, err := NewRequest(method, self.requestUri, self.nextCSeq(), nil)
+		if err != nil {
+			return err
+		}
+		res, err := self.sendRequest(req)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode == 401 && i == 0 && self.auth != nil {
+			challenge, err := auth.Select(res.Header.Values("WWW-Authenticate"))
+			if err == nil && self.auth.Update(challenge) == nil {
+				continue
+			}
+		}
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			return NewRTSPError(res.StatusCode, method+" keepalive failed")
+		}
+		return nil
+	}
+}
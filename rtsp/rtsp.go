@@ -3,7 +3,6 @@ package rtsp
 import (
 	"bufio"
 	"bytes"
-	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
@@ -14,6 +13,8 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"videoplayer/transport"
 
@@ -21,6 +22,7 @@ import (
 
 	"videoplayer/joy4/av"
 
+	"videoplayer/joy4/format/rtsp/auth"
 	"videoplayer/joy4/format/rtsp/rtp"
 
 	"videoplayer/joy4/format/rtsp/sdp"
@@ -77,15 +79,32 @@ func NewRequest(method, urlStr, cSeq string, body io.ReadCloser) (*Request, erro
 }
 
 type Client struct {
-	cSeq       int
+	// reqMu 保证控制通道上同一时刻只有一个请求在等待响应
+	reqMu      sync.Mutex
+	cSeq       int64
 	sessionId  string
 	requestUri string
 	trans      transport.Transporter
+	// Credentials 按主机查找的账号密码，地址中没有账号时使用，为空时用 auth.DefaultStore
+	Credentials *auth.Store
+	// Redial 服务端重定向（301/302）时为新地址建立传输，为空时不跟随重定向
+	Redial func(uri string) (transport.Transporter, error)
+
+	// UseUDP RTP 走 UDP 单播，Multicast 同时设置时走组播；
+	// RtpTimeout 为 UDP 收不到数据的超时，ReorderQueueSize 为乱序重排队列的包数
+	UseUDP           bool
+	Multicast        bool
+	RtpTimeout       time.Duration
+	ReorderQueueSize int
+	udpCh            chan udpPacket
+
+	closeOnce sync.Once
+	closed    chan struct{}
 
 	// ported from joy4
 	url                 *url.URL
 	streams             []*Stream
-	authen              func(method string) string
+	auth                *auth.Authenticator
 	RtpKeepAliveTimeout time.Duration
 	supportedMethods    []string
 	stage               int
@@ -95,35 +114,55 @@ type Client struct {
 	redirectTimes       int
 	more                bool
 	moreStreamIdx       int
-	rtpKeepaliveTimer   time.Time
+
+	// 保活协程，PLAY 成功后启动，TEARDOWN 时停止
+	kaMu   sync.Mutex
+	kaStop chan struct{}
+	kaErr  error
 }
 
-func NewClient(uri string, trans transport.Transporter) (*Client, error) {
-	var URL *url.URL
-	var err error
-	if URL, err = url.Parse(uri); err != nil {
-		return nil, err
+// parseURL 补全默认端口，返回去掉账号信息的请求地址
+func parseURL(uri string) (*url.URL, string, error) {
+	URL, err := url.Parse(uri)
+	if err != nil {
+		return nil, "", err
 	}
 
 	if _, _, err := net.SplitHostPort(URL.Host); err != nil {
-		URL.Host = URL.Host + ":554"
+		if URL.Scheme == "rtsps" {
+			URL.Host = URL.Host + ":322"
+		} else {
+			URL.Host = URL.Host + ":554"
+		}
 	}
 
 	u2 := *URL
 	u2.User = nil
+	return URL, u2.String(), nil
+}
+
+// NewClient 在 trans 上收发 RTSP 请求和交织数据，trans 可以是 WSP 代理、TCP、
+// TLS 或 HTTP 隧道；实现了 transport.Conn 的传输由 Client 负责断开
+func NewClient(uri string, trans transport.Transporter) (*Client, error) {
+	URL, requestUri, err := parseURL(uri)
+	if err != nil {
+		return nil, err
+	}
 
 	return &Client{
 		trans:               trans,
 		url:                 URL,
-		requestUri:          u2.String(),
+		requestUri:          requestUri,
 		tcpStreamIndex:      make(map[int]int),
 		RtpKeepAliveTimeout: 30 * time.Second,
+		udpCh:               make(chan udpPacket, 1024),
+		closed:              make(chan struct{}),
 	}, nil
 }
 
+// nextCSeq 保活协程和读协程都会发送请求，序号需要原子递增
 func (s *Client) nextCSeq() string {
-	s.cSeq++
-	return strconv.Itoa(s.cSeq)
+	return strconv.FormatInt(atomic.AddInt64(&s.cSeq, 1), 10)
 }
 
 func (self *Client) Streams() (streams []av.CodecData, err error) {
@@ -138,12 +177,14 @@ func (self *Client) Streams() (streams []av.CodecData, err error) {
 }
 
 func (s *Client) sendRequest(req *Request) (*Response, error) {
+	s.reqMu.Lock()
+	defer s.reqMu.Unlock()
 	if s.sessionId != "" {
 		req.Header.Add("Session", s.sessionId)
 	}
 	req.Header.Add("User-Agent", "go_html_player")
-	if s.authen != nil {
-		req.Header.Add("Authorization", s.authen(req.Method))
+	if s.auth != nil {
+		req.Header.Add("Authorization", s.auth.Authorization(req.Method, req.URL.String()))
 	}
 	resbody, err := s.trans.Send(req.Bytes())
 	if err != nil {
@@ -174,59 +215,22 @@ func (self *Client) handleResp(res *Response) (err error) {
 	return
 }
 
-func (self *Client) handle401(res *Response) (err error) {
-	/*
-		RTSP/1.0 401 Unauthorized
-		CSeq: 2
-		Date: Wed, May 04 2016 10:10:51 GMT
-		WWW-Authenticate: Digest realm="LIVE555 Streaming Media", nonce="c633aaf8b83127633cbe98fac1d20d87"
-	*/
-	authval := res.Header.Get("WWW-Authenticate")
-	hdrval := strings.SplitN(authval, " ", 2)
-	var realm, nonce string
-
-	if len(hdrval) == 2 {
-		for _, field := range strings.Split(hdrval[1], ",") {
-			field = strings.Trim(field, ", ")
-			if keyval := strings.SplitN(field, "=", 2); len(keyval) == 2 { // Value may contain '='
-				key := keyval[0]
-				val := strings.Trim(keyval[1], `"`)
-				switch key {
-				case "realm":
-					realm = val
-				case "nonce":
-					nonce = val
-				}
-			}
-		}
-
-		if realm != "" {
-			var username string
-			var password string
-
-			if self.url.User == nil {
-				err = fmt.Errorf("rtsp: no username")
-				return
-			}
-			username = self.url.User.Username()
-			password, _ = self.url.User.Password()
-
-			self.authen = func(method string) string {
-				if nonce != "" {
-					hs1 := md5hash(username + ":" + realm + ":" + password)
-					hs2 := md5hash(method + ":" + self.requestUri)
-					response := md5hash(hs1 + ":" + nonce + ":" + hs2)
-					return fmt.Sprintf(
-						`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
-						username, realm, nonce, self.requestUri, response)
-				} else {
-					return fmt.Sprintf(`Authorization: Basic %s`, base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
-				}
-			}
-		}
+// handle401 根据 WWW-Authenticate 选择最强的认证方式（Digest SHA-256、MD5、Basic），
+// 已认证过的请求仅在 nonce 过期（stale）时重试
+func (self *Client) handle401(res *Response) error {
+	challenge, err := auth.Select(res.Header.Values("WWW-Authenticate"))
+	if err != nil {
+		return err
 	}
-
-	return
+	if self.auth != nil {
+		return self.auth.Update(challenge)
+	}
+	cred, ok := auth.FromURL(self.url, self.Credentials)
+	if !ok {
+		return auth.ErrNoCredentials
+	}
+	self.auth, err = auth.NewAuthenticator(cred, challenge)
+	return err
 }
 
 func (self *Client) parsePublic(res *Response) {
@@ -283,33 +287,6 @@ func (self *Client) isMethodSupported(m string) bool {
 	return false
 }
 
-func (self *Client) sendRtpKeepalive(readResponse bool) (err error) {
-	if self.RtpKeepAliveTimeout <= 0 {
-		return
-	}
-	if self.rtpKeepaliveTimer.IsZero() {
-		self.rtpKeepaliveTimer = time.Now()
-	} else if time.Now().Sub(self.rtpKeepaliveTimer) > self.RtpKeepAliveTimeout {
-		self.rtpKeepaliveTimer = time.Now()
-		log.Info("rtp: keep alive")
-		var req *Request
-		// refer to: https://github.com/FFmpeg/FFmpeg/blob/master/libavformat/rtspdec.c#L914
-		if self.sessionId != "" && self.isMethodSupported("GET_PARAMETER") {
-			req, err = NewRequest("GET_PARAMETER", self.requestUri, self.nextCSeq(), nil)
-		} else {
-			req, err = NewRequest("OPTIONS", "*", self.nextCSeq(), nil)
-		}
-		if err != nil {
-			return
-		}
-		_, err = self.sendRequest(req)
-		if err != nil {
-			return
-		}
-	}
-	return
-}
-
 func (self *Client) parseBlockHeader(h []byte) (length int, no int, timestamp uint32, seq uint16, err error) {
 	length = int(h[2])<<8 + int(h[3])
 	no = int(h[1])
@@ -430,6 +407,10 @@ func (self *Client) readTCPPacket() (pkt av.Packet, err error) {
 		for {
 			if res, ok, err = self.parseOnePacket(false); err != nil {
 				log.Errorf("failed to read rtsp packet, err: %v ", err)
+				// 会话因保活失败被服务端关闭时报告保活错误
+				if kaErr := self.keepaliveError(); kaErr != nil {
+					err = kaErr
+				}
 				return
 			}
 			if ok && len(res.Block) > 0 {
@@ -511,9 +492,13 @@ func (self *Client) readPacket() (pkt av.Packet, err error) {
 			return
 		}
 	}
-	if err = self.sendRtpKeepalive(false); err != nil {
+	if err = self.keepaliveError(); err != nil {
 		return
 	}
+	self.sendRTCPRR()
+	if self.UseUDP {
+		return self.readUDPPacket()
+	}
 	return self.readTCPPacket()
 }
 
@@ -568,7 +553,7 @@ func (s *Client) Describe() (streams []sdp.Media, err error) {
 		res *Response
 		req *Request
 	)
-	for i := 0; i < 2; i++ {
+	for i := 0; i < auth.MaxAttempts; i++ {
 		req, err = NewRequest("DESCRIBE", s.requestUri, s.nextCSeq(), nil)
 		if err != nil {
 			return
@@ -584,6 +569,16 @@ func (s *Client) Describe() (streams []sdp.Media, err error) {
 			break
 		}
 	}
+	if res.StatusCode == 301 || res.StatusCode == 302 {
+		if s.redirectTimes > 3 {
+			err = NewRTSPError(res.StatusCode, "too many redirection")
+		} else if newURL := res.Header.Get("Location"); newURL != "" {
+			err = s.redirect(newURL)
+		} else {
+			err = NewRTSPError(res.StatusCode, "Not found redirect Location")
+		}
+		return
+	}
 	if res.StatusCode < 200 || res.StatusCode > 299 || res.ContentLength <= 0 {
 		err = NewRTSPError(res.StatusCode, "DESCRIBE failed")
 		return
@@ -599,7 +594,7 @@ func (s *Client) Describe() (streams []sdp.Media, err error) {
 	for i, media := range medias {
 		// skip unsupport stream eg: applicion
 		if !isSupportedMedia(&media) {
-			log.Debug("rtsp: unsupported media type: %s %s", media.AVType, media.Type.String())
+			log.Debugf("rtsp: unsupported media type: %s %s", media.AVType, media.Type.String())
 			continue
 		}
 		stream := &Stream{Sdp: media, client: s, Idx: i}
@@ -608,7 +603,7 @@ func (s *Client) Describe() (streams []sdp.Media, err error) {
 			// continue
 		}
 
-		log.Debug("rtsp: stream %d codec data: %+v", i, stream)
+		log.Debugf("rtsp: stream %d codec data: %+v", i, stream)
 		s.streams = append(s.streams, stream)
 		streams = append(streams, media)
 
@@ -634,7 +629,7 @@ func (s *Client) Options() (err error) {
 		res *Response
 		req *Request
 	)
-	for i := 0; i < 2; i++ {
+	for i := 0; i < auth.MaxAttempts; i++ {
 		req, err = NewRequest("OPTIONS", s.requestUri, s.nextCSeq(), nil)
 		if err != nil {
 			return
@@ -686,14 +681,39 @@ func (self *Client) Setup() (err error) {
 		if err != nil {
 			return
 		}
-		req.Header.Add("Transport", fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", si*2, si*2+1))
+		stream := self.streams[si]
+		stream.channel = si * 2
+		switch {
+		case self.UseUDP && self.Multicast:
+			req.Header.Add("Transport", "RTP/AVP;multicast")
+		case self.UseUDP:
+			if err = stream.setupUDP(self.requestUri); err != nil {
+				return
+			}
+			req.Header.Add("Transport", fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d", stream.rtpUdpPort(), stream.rtcpUdpPort()))
+		default:
+			req.Header.Add("Transport", fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", si*2, si*2+1))
+		}
 		if res, err = self.sendRequest(req); err != nil {
 			return
 		}
 		if err = self.handleResp(res); err != nil {
 			return
 		}
-		self.parseInterleaved(si, res)
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			err = NewRTSPError(res.StatusCode, "SETUP failed")
+			return
+		}
+		switch {
+		case self.UseUDP && self.Multicast:
+			if err = stream.setupMulticast(self.requestUri, res); err != nil {
+				return
+			}
+		case self.UseUDP:
+			stream.sendPunch(res)
+		default:
+			self.parseInterleaved(si, res)
+		}
 	}
 
 	self.redirectTimes = 0
@@ -716,14 +736,43 @@ func (self *Client) allCodecDataReady() bool {
 }
 
 func (self *Client) Play() (err error) {
+	_, err = self.play(nil, 0)
+	return
+}
+
+// PlayRange sends PLAY with an optional Range header to seek within a recording
+// and an optional Scale header to change the playback speed. A nil range resumes
+// from the current position, a zero scale leaves the speed unchanged.
+func (self *Client) PlayRange(r *sdp.Range, scale float64) (err error) {
+	res, err := self.play(r, scale)
+	if err != nil {
+		return
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		err = NewRTSPError(res.StatusCode, "PLAY failed")
+		return
+	}
+	return
+}
+
+func (self *Client) play(r *sdp.Range, scale float64) (res *Response, err error) {
 	req, err := NewRequest("PLAY", self.requestUri, self.nextCSeq(), nil)
 	if err != nil {
 		return
 	}
-	_, err = self.sendRequest(req)
+	if r != nil {
+		req.Header.Add("Range", r.String())
+	}
+	if scale != 0 {
+		req.Header.Add("Scale", strconv.FormatFloat(scale, 'f', -1, 64))
+	}
+	res, err = self.sendRequest(req)
 	if err != nil {
 		return
 	}
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		self.startKeepalive()
+	}
 	if self.allCodecDataReady() {
 		self.stage = stageCodecDataDone
 	} else {
@@ -732,7 +781,26 @@ func (self *Client) Play() (err error) {
 	return
 }
 
+// Pause sends PAUSE, the server stops sending data until the next PLAY.
+func (self *Client) Pause() (err error) {
+	req, err := NewRequest("PAUSE", self.requestUri, self.nextCSeq(), nil)
+	if err != nil {
+		return
+	}
+	res, err := self.sendRequest(req)
+	if err != nil {
+		return
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		err = NewRTSPError(res.StatusCode, "PAUSE failed")
+		return
+	}
+	return
+}
+
 func (self *Client) Teardown() (err error) {
+	self.stopKeepalive()
+	defer self.closeUDP()
 	req, err := NewRequest("TEARDOWN", self.requestUri, self.nextCSeq(), nil)
 	if err != nil {
 		return
@@ -744,6 +812,45 @@ func (self *Client) Teardown() (err error) {
 	return nil
 }
 
+// Close 停止保活、关闭 UDP 连接并断开传输，不发送 TEARDOWN
+func (self *Client) Close() error {
+	self.closeOnce.Do(func() { close(self.closed) })
+	self.stopKeepalive()
+	self.closeUDP()
+	if conn, ok := self.trans.(transport.Conn); ok {
+		conn.Disconnect()
+	}
+	return nil
+}
+
+// redirect 为重定向地址建立新的传输，从 OPTIONS 重新开始
+func (self *Client) redirect(uri string) error {
+	log.Warnf("rtsp: redirect %v to %v", self.requestUri, uri)
+	if self.Redial == nil {
+		return NewRTSPError(302, "redirect not supported by transport")
+	}
+	URL, requestUri, err := parseURL(uri)
+	if err != nil {
+		return err
+	}
+	trans, err := self.Redial(uri)
+	if err != nil {
+		return err
+	}
+	if conn, ok := self.trans.(transport.Conn); ok {
+		conn.Disconnect()
+	}
+	self.trans = trans
+	self.url = URL
+	self.requestUri = requestUri
+	self.auth = nil
+	self.sessionId = ""
+	self.supportedMethods = nil
+	self.stage = 0
+	self.redirectTimes++
+	return nil
+}
+
 func (self *Client) SDP() (sdp sdp.SDPInfo, err error) {
 	if err = self.prepare(stageCodecDataDone); err != nil {
 		return
@@ -760,6 +867,18 @@ func (self *Client) ReadPacket() (pkt av.Packet, err error) {
 	return self.readPacket()
 }
 
+// Stats returns the RTP reception statistics of every stream. Like
+// ReadPacket it must not be called concurrently with reading.
+func (self *Client) Stats() []rtp.Stats {
+	stats := make([]rtp.Stats, len(self.streams))
+	for i, stream := range self.streams {
+		if stream.ctx != nil {
+			stats[i] = stream.ctx.Stats()
+		}
+	}
+	return stats
+}
+
 type closer struct {
 	*bufio.Reader
 	r io.Reader
@@ -2,6 +2,7 @@ package rtsp
 
 import (
 	"fmt"
+	"net"
 
 	"videoplayer/joy4/av"
 
@@ -17,19 +18,38 @@ type Stream struct {
 	client *Client
 
 	remoteHost string
+	// channel 交织通道号，UDP 收到的包按该通道号封装
+	channel int
 
 	ctx *rtp.RTPDemuxContext
+
+	udpConns []*net.UDPConn
+	udpAddrs []*net.UDPAddr
 }
 
 func (self *Stream) Close() error {
+	for _, udp := range self.udpConns {
+		if udp != nil {
+			udp.Close()
+		}
+	}
 	return nil
 }
 
 func (self *Stream) MakeCodecData(buf []byte) (err error) {
 	media := self.Sdp
 
+	// UDP 传输需要重排乱序包，TCP 交织不会乱序
+	queueSize := 0
+	if self.client != nil && self.client.UseUDP {
+		queueSize = self.client.ReorderQueueSize
+		if queueSize <= 0 {
+			queueSize = defaultReorderQueueSize
+		}
+	}
+
 	if self.ctx == nil {
-		self.ctx = rtp.NewRTPDemuxContext(media.PayloadType, 0)
+		self.ctx = rtp.NewRTPDemuxContext(media.PayloadType, queueSize)
 		switch {
 		// Unassigned
 		case media.PayloadType >= 35 && media.PayloadType <= 71:
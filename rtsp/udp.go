@@ -0,0 +1,235 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"videoplayer/joy4/av"
+	"videoplayer/joy4/format/rtsp/rtp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultReorderQueueSize UDP 乱序重排队列的默认包数
+const defaultReorderQueueSize = 100
+
+// rtcpRRInterval UDP 传输时发送 RTCP 接收者报告的间隔
+const rtcpRRInterval = 5 * time.Second
+
+// udpPacket UDP 收到的 RTP/RTCP 包，按交织格式加上 '$' 头，
+// 通道号为 2*流序号（RTCP 再加 1），和 TCP 交织的数据走同一套解析
+type udpPacket struct {
+	Idx  int
+	Data []byte
+}
+
+func (self *Stream) setupUDP(uri string) error {
+	self.udpConns = rtp.FindUDPPair(0)
+	if self.udpConns == nil {
+		return ErrNoUDPPortPair
+	}
+	self.udpAddrs = make([]*net.UDPAddr, 2)
+	if t, err := url.Parse(uri); err == nil {
+		self.remoteHost = t.Hostname()
+	}
+
+	go self.readUDP(0)
+	go self.readUDP(1)
+	log.Debugf("rtsp: stream %d: rtp-rtcp: %v-%v", self.Idx,
+		self.udpConns[0].LocalAddr(), self.udpConns[1].LocalAddr())
+	return nil
+}
+
+// parseMulticastTransport returns the group and RTP/RTCP ports from a SETUP
+// response like "RTP/AVP;multicast;destination=232.1.1.1;port=5000-5001;ttl=16".
+func parseMulticastTransport(transport string) (group net.IP, rtpPort, rtcpPort int, err error) {
+	for _, e := range strings.Split(transport, ";") {
+		e = strings.TrimSpace(e)
+		switch {
+		case strings.HasPrefix(e, "destination="):
+			group = net.ParseIP(strings.TrimPrefix(e, "destination="))
+		case strings.HasPrefix(e, "port="):
+			if n, _ := fmt.Sscanf(e, "port=%d-%d", &rtpPort, &rtcpPort); n == 1 {
+				rtcpPort = rtpPort + 1
+			}
+		}
+	}
+	if group == nil || !group.IsMulticast() {
+		return nil, 0, 0, fmt.Errorf("rtsp: no multicast destination in transport %q", transport)
+	}
+	if rtpPort == 0 {
+		return nil, 0, 0, fmt.Errorf("rtsp: no multicast port in transport %q", transport)
+	}
+	return
+}
+
+// setupMulticast 加入 SETUP 响应中的组播组，RTCP 接收者报告也发往该组
+func (self *Stream) setupMulticast(uri string, resp *Response) error {
+	group, rtpPort, rtcpPort, err := parseMulticastTransport(resp.Header.Get("Transport"))
+	if err != nil {
+		return err
+	}
+	if t, err := url.Parse(uri); err == nil {
+		self.remoteHost = t.Hostname()
+	}
+
+	self.udpAddrs = []*net.UDPAddr{{IP: group, Port: rtpPort}, {IP: group, Port: rtcpPort}}
+	self.udpConns = make([]*net.UDPConn, 2)
+	for i, addr := range self.udpAddrs {
+		if self.udpConns[i], err = net.ListenMulticastUDP("udp", nil, addr); err != nil {
+			self.Close()
+			return err
+		}
+	}
+
+	go self.readUDP(0)
+	go self.readUDP(1)
+	log.Debugf("rtsp: stream %d: multicast rtp-rtcp: %v-%v", self.Idx, self.udpAddrs[0], self.udpAddrs[1])
+	return nil
+}
+
+func (self *Stream) sendPunchInternal(idx int) {
+	udpAddr := self.udpAddrs[idx]
+	if udpAddr == nil {
+		return
+	}
+	var dummy []byte
+	if idx == 0 {
+		// small RTP
+		dummy = []byte{
+			rtp.RTP_VERSION << 6, 0, 0, 0,
+			0, 0, 0, 0,
+			0, 0, 0, 0,
+		}
+	} else {
+		// small RTCP
+		dummy = []byte{rtp.RTP_VERSION << 6, rtp.RTCP_RR, 0, 1,
+			0, 0, 0, 0,
+		}
+	}
+	for i := 0; i < 5; i++ {
+		self.udpConns[idx].WriteToUDP(dummy, udpAddr)
+	}
+}
+
+// sendPunch 向 SETUP 响应中的 server_port 发送打洞包，让 NAT 放行服务端的 RTP
+func (self *Stream) sendPunch(resp *Response) {
+	if len(self.udpConns) == 0 || self.remoteHost == "" {
+		return
+	}
+	for _, e := range strings.Split(resp.Header.Get("Transport"), ";") {
+		var spMin, spMax int
+		if n, _ := fmt.Sscanf(e, "server_port=%d-%d", &spMin, &spMax); n != 2 {
+			continue
+		}
+		if addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(self.remoteHost, fmt.Sprint(spMin))); err == nil {
+			self.udpAddrs[0] = addr
+		}
+		if addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(self.remoteHost, fmt.Sprint(spMax))); err == nil {
+			self.udpAddrs[1] = addr
+		}
+		self.sendPunchInternal(0)
+		self.sendPunchInternal(1)
+	}
+}
+
+func (self *Stream) sendRR() {
+	if len(self.udpConns) == 0 || self.udpAddrs[1] == nil || self.ctx == nil {
+		return
+	}
+	self.udpConns[1].WriteToUDP(self.ctx.GenerateRTCPRR(), self.udpAddrs[1])
+}
+
+func (self *Stream) readUDP(idx int) {
+	b := make([]byte, 65536+4)
+	for {
+		n, _, err := self.udpConns[idx].ReadFromUDP(b[4:])
+		if err != nil {
+			log.Debugf("rtp: ReadFromUDP: %v", err)
+			return
+		}
+		b[0] = '$'
+		b[1] = byte(self.channel + idx)
+		binary.BigEndian.PutUint16(b[2:4], uint16(n))
+
+		out := make([]byte, 4+n)
+		copy(out, b)
+
+		select {
+		case self.client.udpCh <- udpPacket{Idx: idx, Data: out}:
+		default:
+			log.Warnf("rtp: drop udp packet, remote: %v", self.remoteHost)
+		}
+	}
+}
+
+func (self *Stream) rtpUdpPort() int {
+	return self.udpConns[0].LocalAddr().(*net.UDPAddr).Port
+}
+
+func (self *Stream) rtcpUdpPort() int {
+	return self.udpConns[1].LocalAddr().(*net.UDPAddr).Port
+}
+
+func (self *Client) closeUDP() {
+	for _, s := range self.streams {
+		s.Close()
+	}
+}
+
+// sendRTCPRR UDP 传输时定期发送接收者报告，TCP 交织时和 FFmpeg 一样不发送
+func (self *Client) sendRTCPRR() {
+	if !self.UseUDP {
+		return
+	}
+	now := time.Now()
+	if now.After(self.lastRTCPSent.Add(rtcpRRInterval)) {
+		log.Debug("sending RTCP RR")
+		for _, s := range self.streams {
+			s.sendRR()
+		}
+		self.lastRTCPSent = now
+	}
+}
+
+// readUDPPacket 在 RtpTimeout 内收不到数据时返回 ErrTimeout
+func (self *Client) readUDPPacket() (pkt av.Packet, err error) {
+	var timeoutC <-chan time.Time
+	if self.RtpTimeout > 0 {
+		timeout := time.NewTimer(self.RtpTimeout)
+		defer timeout.Stop()
+		timeoutC = timeout.C
+	}
+
+	for {
+		select {
+		case p := <-self.udpCh:
+			if _, _, _, _, err = self.parseBlockHeader(p.Data); err != nil {
+				if err == io.EOF {
+					return
+				}
+				err = nil
+				continue
+			}
+			var ok bool
+			if pkt, ok, err = self.handleBlock(p.Data); err != nil {
+				log.Warnf("rtsp: bad block: %v", err)
+				return
+			}
+			if ok {
+				return
+			}
+		case <-timeoutC:
+			err = ErrTimeout
+			return
+		case <-self.closed:
+			err = io.EOF
+			return
+		}
+	}
+}
@@ -1,8 +1,6 @@
 package rtsp
 
 import (
-	"crypto/md5"
-	"encoding/hex"
 	"net/url"
 
 	"videoplayer/joy4/format/rtsp/sdp"
@@ -16,11 +14,6 @@ const (
 	stageCodecDataDone
 )
 
-func md5hash(s string) string {
-	h := md5.Sum([]byte(s))
-	return hex.EncodeToString(h[:])
-}
-
 func buildStreamUri(requestUri string, control string) (uri string, err error) {
 
 	var u *url.URL
@@ -1,10 +1,13 @@
 package server
 
 import (
-	"fmt"
+	"errors"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"net/http"
+	"videoplayer/player"
+	"videoplayer/util/apierr"
 )
 
 // handleRoot handles requests to the root endpoint.
@@ -24,20 +27,16 @@ func (s *Server) handleOpenWindow(c *gin.Context) {
 	var ret Ret
 	if err := c.BindJSON(&windowParams); err != nil {
 		log.Error(err)
-		ret = Ret{
-			Code:    Failed,
-			Message: fmt.Sprintf("Error parsing request: %s", err.Error()),
-		}
-		c.JSON(http.StatusBadRequest, ret)
+		ret = failed(apierr.New(apierr.InvalidRequest, "Error parsing request: %s", err.Error()), nil)
+		c.JSON(ret.Error.HTTPStatus(), ret)
 		return
 	}
 	ret.Data = windowParams
 	// 在这里打印请求参数
 	log.WithFields(log.Fields{"windowParams": windowParams}).Debug("Received request")
 	if err := s.manager.HandleOpenWindow(windowParams); err != nil {
-		ret.Code = Failed
-		ret.Message = err.Error()
-		c.JSON(http.StatusOK, ret)
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
 		return
 	}
 	ret.Code = Success
@@ -52,9 +51,8 @@ func (s *Server) handleCloseWindow(c *gin.Context) {
 	id := c.Param("id")
 	windowParams.WindowID = id
 	if err := s.manager.HandleCloseWindow(windowParams); err != nil {
-		ret.Code = Failed
-		ret.Message = err.Error()
-		c.JSON(http.StatusOK, ret)
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
 		return
 	}
 	ret.Code = Success
@@ -71,9 +69,8 @@ func (s *Server) handleHideWindow(c *gin.Context) {
 	id := c.Param("id")
 	windowParams.WindowID = id
 	if err := s.manager.HandleHideWindow(windowParams); err != nil {
-		ret.Code = Failed
-		ret.Message = err.Error()
-		c.JSON(http.StatusOK, ret)
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
 		return
 	}
 	ret.Code = Success
@@ -90,9 +87,8 @@ func (s *Server) handleShowWindow(c *gin.Context) {
 	id := c.Param("id")
 	windowParams.WindowID = id
 	if err := s.manager.HandleShowWindow(windowParams); err != nil {
-		ret.Code = Failed
-		ret.Message = err.Error()
-		c.JSON(http.StatusOK, ret)
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
 		return
 	}
 	ret.Code = Success
@@ -106,9 +102,8 @@ func (s *Server) handleShowWindow(c *gin.Context) {
 func (s *Server) handleCloseAllWindows(c *gin.Context) {
 	var ret Ret
 	if err := s.manager.HandleCloseAllWindows(); err != nil {
-		ret.Code = Failed
-		ret.Message = err.Error()
-		c.JSON(http.StatusOK, ret)
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
 		return
 	}
 	ret.Code = Success
@@ -117,16 +112,40 @@ func (s *Server) handleCloseAllWindows(c *gin.Context) {
 	return
 }
 
-// handleListWindow handles requests to list all windows.
+// handleListWindow handles requests to list all windows with their reception
+// statistics.
 func (s *Server) handleListWindow(c *gin.Context) {
-	ret := Ret{}
+	var ret Ret
+	windows, err := s.manager.HandleListWindows()
+	if err != nil {
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
+		return
+	}
 	ret = Ret{
 		Code:    Success,
 		Message: "Success",
+		Data:    windows,
 	}
 	c.JSON(http.StatusOK, ret)
-	return
+}
 
+// handleDiagnostics returns the signaling log of a window, kept after the
+// window failed to open so support can see why.
+func (s *Server) handleDiagnostics(c *gin.Context) {
+	var ret Ret
+	diagnostics, err := s.manager.HandleDiagnostics(c.Param("id"))
+	if err != nil {
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
+		return
+	}
+	ret = Ret{
+		Code:    Success,
+		Message: "Success",
+		Data:    diagnostics,
+	}
+	c.JSON(http.StatusOK, ret)
 }
 
 // handleMoveWindow handles requests to move a window by ID.
@@ -136,18 +155,14 @@ func (s *Server) handleMoveWindow(c *gin.Context) {
 	id := c.Param("id")
 	if err := c.BindJSON(&windowParams); err != nil {
 		log.Error(err)
-		ret = Ret{
-			Code:    Failed,
-			Message: fmt.Sprintf("Error parsing request: %s", err.Error()),
-		}
-		c.JSON(http.StatusBadRequest, ret)
+		ret = failed(apierr.New(apierr.InvalidRequest, "Error parsing request: %s", err.Error()), nil)
+		c.JSON(ret.Error.HTTPStatus(), ret)
 		return
 	}
 	windowParams.WindowID = id
 	if err := s.manager.HandleMoveWindow(windowParams); err != nil {
-		ret.Code = Failed
-		ret.Message = err.Error()
-		c.JSON(http.StatusOK, ret)
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
 		return
 	}
 	ret.Code = Success
@@ -156,3 +171,168 @@ func (s *Server) handleMoveWindow(c *gin.Context) {
 	c.JSON(http.StatusOK, ret)
 	return
 }
+
+// handlePauseWindow handles requests to pause a window by ID, the body is optional.
+func (s *Server) handlePauseWindow(c *gin.Context) {
+	var ret Ret
+	var windowParams WindowParams
+	id := c.Param("id")
+	if err := c.ShouldBindJSON(&windowParams); err != nil && !errors.Is(err, io.EOF) {
+		log.Error(err)
+		ret = failed(apierr.New(apierr.InvalidRequest, "Error parsing request: %s", err.Error()), nil)
+		c.JSON(ret.Error.HTTPStatus(), ret)
+		return
+	}
+	windowParams.WindowID = id
+	if err := s.manager.HandlePauseWindow(windowParams); err != nil {
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
+		return
+	}
+	ret.Code = Success
+	ret.Message = "success"
+	ret.Data = windowParams
+	c.JSON(http.StatusOK, ret)
+}
+
+// handleResumeWindow handles requests to resume a paused window by ID.
+func (s *Server) handleResumeWindow(c *gin.Context) {
+	var ret Ret
+	var windowParams WindowParams
+	id := c.Param("id")
+	windowParams.WindowID = id
+	if err := s.manager.HandleResumeWindow(windowParams); err != nil {
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
+		return
+	}
+	ret.Code = Success
+	ret.Message = "success"
+	c.JSON(http.StatusOK, ret)
+}
+
+// handleStepFrame handles requests to advance a paused window by one frame.
+func (s *Server) handleStepFrame(c *gin.Context) {
+	var ret Ret
+	var windowParams WindowParams
+	id := c.Param("id")
+	windowParams.WindowID = id
+	if err := s.manager.HandleStepFrame(windowParams); err != nil {
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
+		return
+	}
+	ret.Code = Success
+	ret.Message = "success"
+	c.JSON(http.StatusOK, ret)
+}
+
+// handleSwitchStream handles requests to change the stream shown in a window by ID.
+func (s *Server) handleSwitchStream(c *gin.Context) {
+	var ret Ret
+	var windowParams WindowParams
+	id := c.Param("id")
+	if err := c.BindJSON(&windowParams); err != nil {
+		log.Error(err)
+		ret = failed(apierr.New(apierr.InvalidRequest, "Error parsing request: %s", err.Error()), nil)
+		c.JSON(ret.Error.HTTPStatus(), ret)
+		return
+	}
+	windowParams.WindowID = id
+	if err := s.manager.HandleSwitchStream(windowParams); err != nil {
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
+		return
+	}
+	ret.Code = Success
+	ret.Message = "success"
+	ret.Data = windowParams
+	c.JSON(http.StatusOK, ret)
+}
+
+// handleStartTour handles requests to cycle a window through a list of streams.
+func (s *Server) handleStartTour(c *gin.Context) {
+	var ret Ret
+	var windowParams WindowParams
+	id := c.Param("id")
+	if err := c.BindJSON(&windowParams); err != nil {
+		log.Error(err)
+		ret = failed(apierr.New(apierr.InvalidRequest, "Error parsing request: %s", err.Error()), nil)
+		c.JSON(ret.Error.HTTPStatus(), ret)
+		return
+	}
+	windowParams.WindowID = id
+	if err := s.manager.HandleStartTour(windowParams); err != nil {
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
+		return
+	}
+	ret.Code = Success
+	ret.Message = "success"
+	ret.Data = windowParams
+	c.JSON(http.StatusOK, ret)
+}
+
+// handleTourCommand returns a handler that stops, pauses, resumes or skips a window's tour.
+func (s *Server) handleTourCommand(requestType player.RequestType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ret Ret
+		var windowParams WindowParams
+		windowParams.WindowID = c.Param("id")
+		if err := s.manager.HandleTourCommand(windowParams, requestType); err != nil {
+			ret = failed(err, ret.Data)
+			c.JSON(ret.Error.HTTPStatus(), ret)
+			return
+		}
+		ret.Code = Success
+		ret.Message = "success"
+		c.JSON(http.StatusOK, ret)
+	}
+}
+
+// handleSeek handles requests to move a file or recording playback window to a
+// position, or to an absolute time for clock addressed recordings.
+func (s *Server) handleSeek(c *gin.Context) {
+	var ret Ret
+	var windowParams WindowParams
+	id := c.Param("id")
+	if err := c.BindJSON(&windowParams); err != nil {
+		log.Error(err)
+		ret = failed(apierr.New(apierr.InvalidRequest, "Error parsing request: %s", err.Error()), nil)
+		c.JSON(ret.Error.HTTPStatus(), ret)
+		return
+	}
+	windowParams.WindowID = id
+	if err := s.manager.HandleSeek(windowParams); err != nil {
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
+		return
+	}
+	ret.Code = Success
+	ret.Message = "success"
+	ret.Data = windowParams
+	c.JSON(http.StatusOK, ret)
+}
+
+// handleSetRate handles requests to change the playback rate of a file window.
+func (s *Server) handleSetRate(c *gin.Context) {
+	var ret Ret
+	var windowParams WindowParams
+	id := c.Param("id")
+	if err := c.BindJSON(&windowParams); err != nil {
+		log.Error(err)
+		ret = failed(apierr.New(apierr.InvalidRequest, "Error parsing request: %s", err.Error()), nil)
+		c.JSON(ret.Error.HTTPStatus(), ret)
+		return
+	}
+	windowParams.WindowID = id
+	if err := s.manager.HandleSetRate(windowParams); err != nil {
+		ret = failed(err, ret.Data)
+		c.JSON(ret.Error.HTTPStatus(), ret)
+		return
+	}
+	ret.Code = Success
+	ret.Message = "success"
+	ret.Data = windowParams
+	c.JSON(http.StatusOK, ret)
+}
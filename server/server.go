@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
+	"videoplayer/config"
 	"videoplayer/player"
+	"videoplayer/util/apierr"
 )
 
 const (
@@ -28,12 +30,60 @@ type WindowParams struct {
 	Height   int    `json:"height"`
 	WindowID string `json:"windowID"`
 	Command  string `json:"command"`
+
+	// KeepDecoding pause-window 时直播流是否继续解码
+	KeepDecoding bool `json:"keepDecoding"`
+	// KeepOld switch-stream 时新源就绪前是否继续播放旧源
+	KeepOld bool `json:"keepOld"`
+	// Devices start-tour 轮巡的视频源列表，Dwell 为每个视频源停留的秒数
+	Devices []TourDevice `json:"devices"`
+	Dwell   int          `json:"dwell"`
+	// URL 任意支持的视频源地址（rtsp/rtmp/hls/本地文件），未填写 rtspurl 时使用
+	URL string `json:"url"`
+	// Loop 本地文件播放结束后从头开始
+	Loop bool `json:"loop"`
+	// HUD 窗口角落显示画面采集时间等信息
+	HUD bool `json:"hud"`
+	// Transport 直连 RTSP 的传输方式：tcp（默认）、udp、multicast、http（HTTP 隧道）；
+	// UDPTimeout 为 UDP 收不到数据时回退到 TCP 的秒数
+	Transport  string `json:"transport"`
+	UDPTimeout int    `json:"udpTimeout"`
+	// Record 把 RTSP 会话录制到配置的 record_dir，用于离线复现问题
+	Record bool `json:"record"`
+	// Position seek 的目标位置（秒），Rate 为 set-rate 的播放倍速
+	Position float64 `json:"position"`
+	Rate     float64 `json:"rate"`
+	// Time seek 录像的绝对时间（RFC3339），填写时优先于 Position
+	Time string `json:"time"`
+}
+
+// sourceURL 视频源地址，rtspurl 优先
+func (w WindowParams) sourceURL() string {
+	if w.RTSPURL != "" {
+		return w.RTSPURL
+	}
+	return w.URL
+}
+
+// TourDevice 轮巡中的一个视频源
+type TourDevice struct {
+	WSURL   string `json:"wsurl"`
+	RTSPURL string `json:"rtspurl"`
+	URL     string `json:"url"`
 }
 
 type Ret struct {
 	Code    int         `json:"code"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data"`
+	// Error 失败时的错误类别、RTSP/WSP 状态码和是否可重试
+	Error *apierr.Error `json:"error,omitempty"`
+}
+
+// failed 失败响应，HTTP 接口按 Error.HTTPStatus() 返回状态码
+func failed(err error, data interface{}) Ret {
+	e := apierr.From(err)
+	return Ret{Code: Failed, Message: e.Error(), Data: data, Error: e}
 }
 
 // NewServer creates a new instance of the Server struct.
@@ -54,9 +104,26 @@ func (s *Server) SetupRoutes() {
 	s.router.POST("/hide-window/:id", s.handleHideWindow)
 	s.router.POST("/show-window/:id", s.handleShowWindow)
 	s.router.GET("/list-window", s.handleListWindow)
+	s.router.GET("/windows/:id/diagnostics", s.handleDiagnostics)
+	s.router.POST("/pause-window/:id", s.handlePauseWindow)
+	s.router.POST("/resume-window/:id", s.handleResumeWindow)
+	s.router.POST("/step-frame/:id", s.handleStepFrame)
+	s.router.POST("/switch-stream/:id", s.handleSwitchStream)
+	s.router.POST("/start-tour/:id", s.handleStartTour)
+	s.router.POST("/stop-tour/:id", s.handleTourCommand(player.StopTour))
+	s.router.POST("/pause-tour/:id", s.handleTourCommand(player.PauseTour))
+	s.router.POST("/resume-tour/:id", s.handleTourCommand(player.ResumeTour))
+	s.router.POST("/skip-tour/:id", s.handleTourCommand(player.SkipTour))
+	s.router.POST("/seek/:id", s.handleSeek)
+	s.router.POST("/set-rate/:id", s.handleSetRate)
 
 	// 设置 WebSocket 路由
 	s.router.GET("/ws", s.handleWebSocket)
+
+	// 设置 WSP 代理路由
+	if config.GlobalConfig != nil && config.GlobalConfig.WSPProxy {
+		s.setupWSPProxy(config.GlobalConfig)
+	}
 }
 
 // Run starts the server on the specified port.
@@ -10,6 +10,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
+	"videoplayer/player"
+	"videoplayer/util/apierr"
 )
 
 var upgrader = websocket.Upgrader{
@@ -22,7 +24,8 @@ type client struct {
 	conn     *websocket.Conn
 	clientID string
 	windows  map[string]WindowParams
-	mu       sync.Mutex
+	// mu 保护连接的写入和 windows，打开窗口的结果在单独的协程中回复
+	mu sync.Mutex
 }
 
 func (s *Server) handleWebSocket(c *gin.Context) {
@@ -80,6 +83,8 @@ func (s *Server) handleWebSocket(c *gin.Context) {
 
 func (s *Server) handleWebSocketHeartBeat(c *client, params WindowParams) {
 	log.Debugf("receive heartbeat from client: %v, msg: %v", c.clientID, params)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.conn.WriteJSON(WindowParams{Command: "heartbeat"})
 }
 
@@ -97,28 +102,41 @@ func (s *Server) handleWebSocketOperation(c *client, params WindowParams) {
 		s.handleWebSocketShowWindow(c, params)
 	case "close-all-windows":
 		s.handleWebSocketCloseAllWindows(c, params)
+	case "pause-window":
+		s.handleWebSocketPauseWindow(c, params)
+	case "resume-window":
+		s.handleWebSocketResumeWindow(c, params)
+	case "step-frame":
+		s.handleWebSocketStepFrame(c, params)
+	case "switch-stream":
+		s.handleWebSocketSwitchStream(c, params)
+	case "start-tour":
+		s.handleWebSocketStartTour(c, params)
+	case "stop-tour":
+		s.handleWebSocketTourCommand(c, params, player.StopTour)
+	case "pause-tour":
+		s.handleWebSocketTourCommand(c, params, player.PauseTour)
+	case "resume-tour":
+		s.handleWebSocketTourCommand(c, params, player.ResumeTour)
+	case "skip-tour":
+		s.handleWebSocketTourCommand(c, params, player.SkipTour)
+	case "seek":
+		s.handleWebSocketSeek(c, params)
+	case "set-rate":
+		s.handleWebSocketSetRate(c, params)
+	case "list-window":
+		s.handleWebSocketListWindow(c, params)
 	default:
 		log.Infof("Unknown command: %s", params.Command)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		s.sendWebSocketMessage(c, failed(apierr.New(apierr.InvalidRequest, "unknown command: %s", params.Command), params))
 	}
 }
 
 func (s *Server) handleWebSocketOpenWindow(c *client, params WindowParams) {
 	log.Infof("open window: %v", params)
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	var ret Ret
-	if err := s.manager.HandleOpenWindow(params); err != nil {
-		ret.Code = Failed
-		ret.Message = err.Error()
-		ret.Data = params
-		s.sendWebSocketMessage(c, ret)
-		return
-	}
-	c.windows[params.WindowID] = params
-	ret.Code = Success
-	ret.Message = "success"
-	ret.Data = params
-	s.sendWebSocketMessage(c, ret)
+	s.replyWebSocketAsync(c, params, s.manager.OpenWindow(params))
 }
 
 func (s *Server) handleWebSocketMoveWindow(c *client, params WindowParams) {
@@ -127,10 +145,7 @@ func (s *Server) handleWebSocketMoveWindow(c *client, params WindowParams) {
 	defer c.mu.Unlock()
 	var ret Ret
 	if err := s.manager.HandleMoveWindow(params); err != nil {
-		ret.Code = Failed
-		ret.Message = err.Error()
-		ret.Data = params
-		s.sendWebSocketMessage(c, ret)
+		s.sendWebSocketMessage(c, failed(err, params))
 		return
 	}
 	c.windows[params.WindowID] = params
@@ -146,10 +161,7 @@ func (s *Server) handleWebSocketCloseWindow(c *client, params WindowParams) {
 	defer c.mu.Unlock()
 	var ret Ret
 	if err := s.manager.HandleCloseWindow(params); err != nil {
-		ret.Code = Failed
-		ret.Message = err.Error()
-		ret.Data = params
-		s.sendWebSocketMessage(c, ret)
+		s.sendWebSocketMessage(c, failed(err, params))
 		return
 	}
 	delete(c.windows, params.WindowID)
@@ -165,10 +177,7 @@ func (s *Server) handleWebSocketHideWindow(c *client, params WindowParams) {
 	defer c.mu.Unlock()
 	var ret Ret
 	if err := s.manager.HandleHideWindow(params); err != nil {
-		ret.Code = Failed
-		ret.Message = err.Error()
-		ret.Data = params
-		s.sendWebSocketMessage(c, ret)
+		s.sendWebSocketMessage(c, failed(err, params))
 		return
 	}
 	delete(c.windows, params.WindowID)
@@ -184,10 +193,7 @@ func (s *Server) handleWebSocketShowWindow(c *client, params WindowParams) {
 	defer c.mu.Unlock()
 	var ret Ret
 	if err := s.manager.HandleShowWindow(params); err != nil {
-		ret.Code = Failed
-		ret.Message = err.Error()
-		ret.Data = params
-		s.sendWebSocketMessage(c, ret)
+		s.sendWebSocketMessage(c, failed(err, params))
 		return
 	}
 	c.windows[params.WindowID] = params
@@ -203,10 +209,7 @@ func (s *Server) handleWebSocketCloseAllWindows(c *client, params WindowParams)
 	defer c.mu.Unlock()
 	var ret Ret
 	if err := s.manager.HandleCloseAllWindows(); err != nil {
-		ret.Code = Failed
-		ret.Message = err.Error()
-		ret.Data = params
-		s.sendWebSocketMessage(c, ret)
+		s.sendWebSocketMessage(c, failed(err, params))
 		return
 	}
 	c.windows = make(map[string]WindowParams)
@@ -216,6 +219,137 @@ func (s *Server) handleWebSocketCloseAllWindows(c *client, params WindowParams)
 	s.sendWebSocketMessage(c, ret)
 }
 
+func (s *Server) handleWebSocketPauseWindow(c *client, params WindowParams) {
+	log.Infof("pause window: %v", params)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret Ret
+	if err := s.manager.HandlePauseWindow(params); err != nil {
+		s.sendWebSocketMessage(c, failed(err, params))
+		return
+	}
+	ret.Code = Success
+	ret.Message = "success"
+	ret.Data = params
+	s.sendWebSocketMessage(c, ret)
+}
+
+func (s *Server) handleWebSocketResumeWindow(c *client, params WindowParams) {
+	log.Infof("resume window: %v", params)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret Ret
+	if err := s.manager.HandleResumeWindow(params); err != nil {
+		s.sendWebSocketMessage(c, failed(err, params))
+		return
+	}
+	ret.Code = Success
+	ret.Message = "success"
+	ret.Data = params
+	s.sendWebSocketMessage(c, ret)
+}
+
+func (s *Server) handleWebSocketStepFrame(c *client, params WindowParams) {
+	log.Infof("step frame: %v", params)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret Ret
+	if err := s.manager.HandleStepFrame(params); err != nil {
+		s.sendWebSocketMessage(c, failed(err, params))
+		return
+	}
+	ret.Code = Success
+	ret.Message = "success"
+	ret.Data = params
+	s.sendWebSocketMessage(c, ret)
+}
+
+func (s *Server) handleWebSocketSwitchStream(c *client, params WindowParams) {
+	log.Infof("switch stream: %v", params)
+	s.replyWebSocketAsync(c, params, s.manager.SwitchStream(params))
+}
+
+func (s *Server) handleWebSocketStartTour(c *client, params WindowParams) {
+	log.Infof("start tour: %v", params)
+	s.replyWebSocketAsync(c, params, s.manager.StartTour(params))
+}
+
+// replyWebSocketAsync 在协程中等待视频源打开的结果再回复，读循环继续处理该连接后续的命令，
+// 例如用 close-window 取消正在进行的打开。请求已按顺序发给播放器，只有回复是异步的
+func (s *Server) replyWebSocketAsync(c *client, params WindowParams, result <-chan error) {
+	go func() {
+		err := <-result
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err != nil {
+			s.sendWebSocketMessage(c, failed(err, params))
+			return
+		}
+		c.windows[params.WindowID] = params
+		s.sendWebSocketMessage(c, Ret{Code: Success, Message: "success", Data: params})
+	}()
+}
+
+func (s *Server) handleWebSocketTourCommand(c *client, params WindowParams, requestType player.RequestType) {
+	log.Infof("%s: %v", params.Command, params)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret Ret
+	if err := s.manager.HandleTourCommand(params, requestType); err != nil {
+		s.sendWebSocketMessage(c, failed(err, params))
+		return
+	}
+	ret.Code = Success
+	ret.Message = "success"
+	ret.Data = params
+	s.sendWebSocketMessage(c, ret)
+}
+
+func (s *Server) handleWebSocketSeek(c *client, params WindowParams) {
+	log.Infof("seek: %v", params)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret Ret
+	if err := s.manager.HandleSeek(params); err != nil {
+		s.sendWebSocketMessage(c, failed(err, params))
+		return
+	}
+	ret.Code = Success
+	ret.Message = "success"
+	ret.Data = params
+	s.sendWebSocketMessage(c, ret)
+}
+
+func (s *Server) handleWebSocketSetRate(c *client, params WindowParams) {
+	log.Infof("set rate: %v", params)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret Ret
+	if err := s.manager.HandleSetRate(params); err != nil {
+		s.sendWebSocketMessage(c, failed(err, params))
+		return
+	}
+	ret.Code = Success
+	ret.Message = "success"
+	ret.Data = params
+	s.sendWebSocketMessage(c, ret)
+}
+
+func (s *Server) handleWebSocketListWindow(c *client, params WindowParams) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret Ret
+	windows, err := s.manager.HandleListWindows()
+	if err != nil {
+		s.sendWebSocketMessage(c, failed(err, params))
+		return
+	}
+	ret.Code = Success
+	ret.Message = "success"
+	ret.Data = windows
+	s.sendWebSocketMessage(c, ret)
+}
+
 func (s *Server) sendWebSocketMessage(c *client, message interface{}) {
 	if err := c.conn.WriteJSON(message); err != nil {
 		log.WithError(err).Error("Error sending WebSocket message")
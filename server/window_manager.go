@@ -1,7 +1,9 @@
 package server
 
 import (
+	"time"
 	"videoplayer/player"
+	"videoplayer/util/apierr"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -22,9 +24,10 @@ func (m *WindowManager) Run() {
 	m.player.Run()
 }
 
-// HandleOpenWindow 处理打开窗口的操作
-func (m *WindowManager) HandleOpenWindow(windowParams WindowParams) error {
-	err := make(chan error)
+// OpenWindow 发送打开窗口的请求，返回打开结果的 channel，调用方必须读取该结果。
+// 视频源在播放器的协程中打开，打开期间可以关闭该窗口来取消
+func (m *WindowManager) OpenWindow(windowParams WindowParams) <-chan error {
+	err := make(chan error, 1)
 	// 在这里打印请求参数
 	log.WithFields(log.Fields{"windowParams": windowParams}).Debug("Received request")
 	m.player.CommandChan() <- player.Request{
@@ -32,17 +35,28 @@ func (m *WindowManager) HandleOpenWindow(windowParams WindowParams) error {
 		Device: player.Device{
 			ID:      windowParams.WindowID,
 			WSURL:   windowParams.WSURL,
-			RTSPURL: windowParams.RTSPURL,
+			RTSPURL: windowParams.sourceURL(),
+			Loop:    windowParams.Loop,
+			HUD:     windowParams.HUD,
+
+			Transport:  windowParams.Transport,
+			UDPTimeout: time.Duration(windowParams.UDPTimeout) * time.Second,
+			Record:     windowParams.Record,
 		},
 		Pos: player.NewPosition(windowParams.X, windowParams.Y, windowParams.Width, windowParams.Height),
 		Err: err,
 	}
-	return <-err
+	return err
+}
+
+// HandleOpenWindow 处理打开窗口的操作
+func (m *WindowManager) HandleOpenWindow(windowParams WindowParams) error {
+	return <-m.OpenWindow(windowParams)
 }
 
 // HandleCloseWindow 处理关闭窗口的操作
 func (m *WindowManager) HandleCloseWindow(windowParams WindowParams) error {
-	err := make(chan error)
+	err := make(chan error, 1)
 	m.player.CommandChan() <- player.Request{
 		Type:   player.CloseVideo,
 		Device: player.Device{ID: windowParams.WindowID},
@@ -53,7 +67,7 @@ func (m *WindowManager) HandleCloseWindow(windowParams WindowParams) error {
 
 // HandleMoveWindow 处理移动窗口的操作
 func (m *WindowManager) HandleMoveWindow(windowParams WindowParams) error {
-	err := make(chan error)
+	err := make(chan error, 1)
 	// Move the specified window by ID
 	m.player.CommandChan() <- player.Request{
 		Type: player.MoveWindow,
@@ -68,14 +82,20 @@ func (m *WindowManager) HandleMoveWindow(windowParams WindowParams) error {
 
 // HandleShowWindow 处理显示窗口的操作
 func (m *WindowManager) HandleShowWindow(windowParams WindowParams) error {
-	err := make(chan error)
+	err := make(chan error, 1)
 	m.player.CommandChan() <- player.Request{
 		// Type: player.PlayVideo, // todo hide
 		Type: player.ShowWindow, // todo hide
 		Device: player.Device{
 			ID:      windowParams.WindowID,
 			WSURL:   windowParams.WSURL,
-			RTSPURL: windowParams.RTSPURL,
+			RTSPURL: windowParams.sourceURL(),
+			Loop:    windowParams.Loop,
+			HUD:     windowParams.HUD,
+
+			Transport:  windowParams.Transport,
+			UDPTimeout: time.Duration(windowParams.UDPTimeout) * time.Second,
+			Record:     windowParams.Record,
 		},
 		Pos: player.NewPosition(windowParams.X, windowParams.Y, windowParams.Width, windowParams.Height),
 		Err: err,
@@ -85,7 +105,7 @@ func (m *WindowManager) HandleShowWindow(windowParams WindowParams) error {
 
 // HandleHideWindow 处理隐藏窗口的操作
 func (m *WindowManager) HandleHideWindow(windowParams WindowParams) error {
-	err := make(chan error)
+	err := make(chan error, 1)
 	m.player.CommandChan() <- player.Request{
 		// Type:   player.CloseVideo, // todo hide
 		Type:   player.HideWindow, // todo hide
@@ -97,10 +117,182 @@ func (m *WindowManager) HandleHideWindow(windowParams WindowParams) error {
 
 // HandleCloseAllWindows 关闭所有窗口 todo
 func (m *WindowManager) HandleCloseAllWindows() error {
-	err := make(chan error)
+	err := make(chan error, 1)
 	m.player.CommandChan() <- player.Request{
 		Type: player.CloseAll, // todo hide
 		Err:  err,
 	}
 	return <-err
 }
+
+// HandleListWindows 查询所有窗口的状态和接收统计
+func (m *WindowManager) HandleListWindows() ([]player.WindowInfo, error) {
+	err := make(chan error, 1)
+	windows := make(chan []player.WindowInfo, 1)
+	m.player.CommandChan() <- player.Request{
+		Type:    player.ListWindows,
+		Windows: windows,
+		Err:     err,
+	}
+	if e := <-err; e != nil {
+		return nil, e
+	}
+	return <-windows, nil
+}
+
+// HandleDiagnostics 查询窗口打开视频源的信令记录和最近的错误
+func (m *WindowManager) HandleDiagnostics(windowID string) (player.Diagnostics, error) {
+	err := make(chan error, 1)
+	diagnostics := make(chan player.Diagnostics, 1)
+	m.player.CommandChan() <- player.Request{
+		Type:        player.GetDiagnostics,
+		Device:      player.Device{ID: windowID},
+		Diagnostics: diagnostics,
+		Err:         err,
+	}
+	if e := <-err; e != nil {
+		return player.Diagnostics{}, e
+	}
+	return <-diagnostics, nil
+}
+
+// HandlePauseWindow 处理暂停窗口的操作
+func (m *WindowManager) HandlePauseWindow(windowParams WindowParams) error {
+	err := make(chan error, 1)
+	m.player.CommandChan() <- player.Request{
+		Type:         player.PauseWindow,
+		Device:       player.Device{ID: windowParams.WindowID},
+		KeepDecoding: windowParams.KeepDecoding,
+		Err:          err,
+	}
+	return <-err
+}
+
+// HandleResumeWindow 处理恢复窗口的操作
+func (m *WindowManager) HandleResumeWindow(windowParams WindowParams) error {
+	err := make(chan error, 1)
+	m.player.CommandChan() <- player.Request{
+		Type:   player.ResumeWindow,
+		Device: player.Device{ID: windowParams.WindowID},
+		Err:    err,
+	}
+	return <-err
+}
+
+// HandleStepFrame 处理单帧步进的操作
+func (m *WindowManager) HandleStepFrame(windowParams WindowParams) error {
+	err := make(chan error, 1)
+	m.player.CommandChan() <- player.Request{
+		Type:   player.StepFrame,
+		Device: player.Device{ID: windowParams.WindowID},
+		Err:    err,
+	}
+	return <-err
+}
+
+// SwitchStream 发送切换窗口视频源的请求，返回新视频源打开结果的 channel，同 OpenWindow
+func (m *WindowManager) SwitchStream(windowParams WindowParams) <-chan error {
+	err := make(chan error, 1)
+	m.player.CommandChan() <- player.Request{
+		Type: player.SwitchStream,
+		Device: player.Device{
+			ID:      windowParams.WindowID,
+			WSURL:   windowParams.WSURL,
+			RTSPURL: windowParams.sourceURL(),
+			Loop:    windowParams.Loop,
+			HUD:     windowParams.HUD,
+
+			Transport:  windowParams.Transport,
+			UDPTimeout: time.Duration(windowParams.UDPTimeout) * time.Second,
+			Record:     windowParams.Record,
+		},
+		KeepOld: windowParams.KeepOld,
+		Err:     err,
+	}
+	return err
+}
+
+// HandleSwitchStream 处理切换窗口视频源的操作
+func (m *WindowManager) HandleSwitchStream(windowParams WindowParams) error {
+	return <-m.SwitchStream(windowParams)
+}
+
+// StartTour 发送开始窗口轮巡的请求，返回第一个视频源打开结果的 channel，同 OpenWindow
+func (m *WindowManager) StartTour(windowParams WindowParams) <-chan error {
+	err := make(chan error, 1)
+	devices := make([]player.Device, 0, len(windowParams.Devices))
+	for _, d := range windowParams.Devices {
+		rtspURL := d.RTSPURL
+		if rtspURL == "" {
+			rtspURL = d.URL
+		}
+		devices = append(devices, player.Device{
+			ID:      windowParams.WindowID,
+			WSURL:   d.WSURL,
+			RTSPURL: rtspURL,
+			HUD:     windowParams.HUD,
+
+			Transport:  windowParams.Transport,
+			UDPTimeout: time.Duration(windowParams.UDPTimeout) * time.Second,
+			Record:     windowParams.Record,
+		})
+	}
+	m.player.CommandChan() <- player.Request{
+		Type:    player.StartTour,
+		Device:  player.Device{ID: windowParams.WindowID},
+		Pos:     player.NewPosition(windowParams.X, windowParams.Y, windowParams.Width, windowParams.Height),
+		Devices: devices,
+		Dwell:   time.Duration(windowParams.Dwell) * time.Second,
+		Err:     err,
+	}
+	return err
+}
+
+// HandleStartTour 处理开始窗口轮巡的操作
+func (m *WindowManager) HandleStartTour(windowParams WindowParams) error {
+	return <-m.StartTour(windowParams)
+}
+
+// HandleTourCommand 处理停止、暂停、恢复和跳过轮巡的操作
+func (m *WindowManager) HandleTourCommand(windowParams WindowParams, requestType player.RequestType) error {
+	err := make(chan error, 1)
+	m.player.CommandChan() <- player.Request{
+		Type:   requestType,
+		Device: player.Device{ID: windowParams.WindowID},
+		Err:    err,
+	}
+	return <-err
+}
+
+// HandleSeek 处理定位本地文件或录像播放位置的操作
+func (m *WindowManager) HandleSeek(windowParams WindowParams) error {
+	var clock time.Time
+	if windowParams.Time != "" {
+		t, parseErr := time.Parse(time.RFC3339, windowParams.Time)
+		if parseErr != nil {
+			return apierr.New(apierr.InvalidRequest, "invalid time %q: %v", windowParams.Time, parseErr)
+		}
+		clock = t
+	}
+	err := make(chan error, 1)
+	m.player.CommandChan() <- player.Request{
+		Type:      player.Seek,
+		Device:    player.Device{ID: windowParams.WindowID},
+		SeekTo:    time.Duration(windowParams.Position * float64(time.Second)),
+		SeekClock: clock,
+		Err:       err,
+	}
+	return <-err
+}
+
+// HandleSetRate 处理设置播放倍速的操作
+func (m *WindowManager) HandleSetRate(windowParams WindowParams) error {
+	err := make(chan error, 1)
+	m.player.CommandChan() <- player.Request{
+		Type:   player.SetRate,
+		Device: player.Device{ID: windowParams.WindowID},
+		Rate:   windowParams.Rate,
+		Err:    err,
+	}
+	return <-err
+}
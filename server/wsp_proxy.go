@@ -0,0 +1,74 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"videoplayer/config"
+	"videoplayer/transport"
+	"videoplayer/util/apierr"
+)
+
+// wspDialTimeout WSP 代理连接 RTSP 服务端的超时时间
+const wspDialTimeout = 10 * time.Second
+
+// setupWSPProxy 挂载 /rtsp-over-ws。代理会按 INIT 中的地址建立 TCP 连接，
+// 没有 token 或允许连接的 RTSP 服务端时不挂载，避免成为通往内网的开放中继
+func (s *Server) setupWSPProxy(cfg *config.Config) {
+	if cfg.WSPProxyToken == "" || len(cfg.WSPProxyTargets) == 0 {
+		log.Error("wsp_proxy needs wsp_proxy_token and wsp_proxy_targets, /rtsp-over-ws is not served")
+		return
+	}
+	dial, err := allowTargets(cfg.WSPProxyTargets)
+	if err != nil {
+		log.Errorf("wsp_proxy_targets: %v, /rtsp-over-ws is not served", err)
+		return
+	}
+	proxy := transport.NewWSPServer()
+	proxy.Dial = dial
+	s.router.GET("/rtsp-over-ws", requireToken(cfg.WSPProxyToken), gin.WrapH(proxy))
+}
+
+// requireToken 校验 jwt 查询参数或 Authorization: Bearer 头中的 token
+func requireToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		got := c.Query("jwt")
+		if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			got = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			ret := failed(apierr.New(apierr.Auth, "invalid or missing token"), nil)
+			c.AbortWithStatusJSON(ret.Error.HTTPStatus(), ret)
+			return
+		}
+		c.Next()
+	}
+}
+
+// allowTargets 只允许连接 targets 中的 RTSP 服务端，其他地址返回 transport.ErrForbiddenTarget
+func allowTargets(targets []string) (func(network, addr string) (net.Conn, error), error) {
+	allowed := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			target = net.JoinHostPort(target, "554")
+		}
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || host == "" {
+			return nil, fmt.Errorf("invalid target %q", target)
+		}
+		allowed[net.JoinHostPort(strings.ToLower(host), port)] = true
+	}
+	return func(network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || !allowed[net.JoinHostPort(strings.ToLower(host), port)] {
+			return nil, fmt.Errorf("%w: %v", transport.ErrForbiddenTarget, addr)
+		}
+		return net.DialTimeout(network, addr, wspDialTimeout)
+	}, nil
+}
@@ -0,0 +1,197 @@
+package source
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"videoplayer/joy4/av"
+	"videoplayer/joy4/av/avutil"
+	"videoplayer/joy4/format/flv"
+	"videoplayer/joy4/format/mp4"
+	"videoplayer/joy4/format/ts"
+	"videoplayer/util/apierr"
+)
+
+// fileHandlers 本地文件支持的容器格式，不注册到 avutil.DefaultHandlers 以免影响其他调用方
+var fileHandlers = &avutil.Handlers{}
+
+func init() {
+	fileHandlers.Add(mp4.Handler)
+	fileHandlers.Add(ts.Handler)
+	fileHandlers.Add(flv.Handler)
+}
+
+// timeSeeker 容器自带索引、可以直接定位的 demuxer，如 mp4
+type timeSeeker interface {
+	SeekToTime(tm time.Duration) error
+}
+
+// fileSource 本地 mp4/ts/flv 文件，按时间戳和倍速播放，支持定位和循环
+type fileSource struct {
+	path  string
+	pacer *pacer
+
+	// mu 保护 demuxer 和 queued，ReadPacket 与 Seek 在不同协程调用
+	mu      sync.Mutex
+	demuxer av.DemuxCloser
+	streams []av.CodecData
+	// queued 定位时扫描到的、目标之前最近关键帧开始的包，先于文件后续内容送出
+	queued []av.Packet
+	loop   bool
+}
+
+func newFileSource(path string) *fileSource {
+	return &fileSource{path: path, pacer: newPacer()}
+}
+
+// Open 本地文件打开很快，不检查 ctx
+func (s *fileSource) Open(context.Context) error {
+	demuxer, streams, err := s.open()
+	if err != nil {
+		return err
+	}
+	s.demuxer = demuxer
+	s.streams = streams
+	return nil
+}
+
+func (s *fileSource) open() (av.DemuxCloser, []av.CodecData, error) {
+	demuxer, err := fileHandlers.Open(s.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	streams, err := demuxer.Streams()
+	if err != nil {
+		demuxer.Close()
+		return nil, nil, err
+	}
+	return demuxer, streams, nil
+}
+
+func (s *fileSource) Streams() ([]av.CodecData, error) {
+	return s.streams, nil
+}
+
+func (s *fileSource) ReadPacket() (av.Packet, error) {
+	pkt, err := s.nextPacket()
+	if err != nil {
+		return pkt, err
+	}
+	if !s.pacer.wait(pkt.Time) {
+		return av.Packet{}, io.EOF
+	}
+	return pkt, nil
+}
+
+func (s *fileSource) nextPacket() (av.Packet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for rewound := false; ; rewound = true {
+		if len(s.queued) > 0 {
+			pkt := s.queued[0]
+			s.queued = s.queued[1:]
+			return pkt, nil
+		}
+		pkt, err := s.demuxer.ReadPacket()
+		if err != io.EOF || !s.loop || rewound {
+			return pkt, err
+		}
+		// 循环播放，回到开头
+		if err = s.seek(0); err != nil {
+			return pkt, err
+		}
+	}
+}
+
+// Seek 定位到目标时间之前最近的视频关键帧
+func (s *fileSource) Seek(to time.Duration) error {
+	if to < 0 {
+		return apierr.New(apierr.InvalidRequest, "invalid seek position: %v", to)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seek(to)
+}
+
+func (s *fileSource) seek(to time.Duration) error {
+	s.queued = nil
+	defer s.pacer.reset()
+
+	if hd, ok := s.demuxer.(*avutil.HandlerDemuxer); ok {
+		if seeker, ok := hd.Demuxer.(timeSeeker); ok {
+			return seeker.SeekToTime(to)
+		}
+	}
+
+	// ts/flv 没有索引：重新打开文件，从头扫描到目标，保留目标之前最近的一个 GOP
+	demuxer, _, err := s.open()
+	if err != nil {
+		return err
+	}
+	s.demuxer.Close()
+	s.demuxer = demuxer
+
+	var gop []av.Packet
+	for {
+		pkt, err := demuxer.ReadPacket()
+		if err == io.EOF {
+			// 目标超出文件长度，停在最后一个 GOP
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if s.streams[pkt.Idx].Type().IsVideo() && pkt.IsKeyFrame {
+			gop = append(gop[:0], pkt)
+		} else if len(gop) > 0 {
+			gop = append(gop, pkt)
+		}
+		if pkt.Time >= to && len(gop) > 0 {
+			break
+		}
+	}
+	s.queued = gop
+	return nil
+}
+
+// SetRate 设置播放倍速，范围 MinRate 到 MaxRate
+func (s *fileSource) SetRate(rate float64) error {
+	if rate < MinRate || rate > MaxRate {
+		return apierr.New(apierr.InvalidRequest, "rate %v out of range [%v, %v]", rate, MinRate, MaxRate)
+	}
+	s.pacer.setRate(rate)
+	return nil
+}
+
+func (s *fileSource) SetLoop(loop bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loop = loop
+}
+
+func (s *fileSource) Capabilities() Capabilities {
+	return Capabilities{Seekable: true, Pausable: true}
+}
+
+func (s *fileSource) Pause() error {
+	s.pacer.pause()
+	return nil
+}
+
+func (s *fileSource) Play() error {
+	s.pacer.resume()
+	return nil
+}
+
+func (s *fileSource) Close() error {
+	s.pacer.close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.demuxer == nil {
+		return nil
+	}
+	return s.demuxer.Close()
+}
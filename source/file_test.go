@@ -0,0 +1,136 @@
+package source
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"videoplayer/joy4/av"
+	"videoplayer/joy4/codec/h264parser"
+	"videoplayer/joy4/format/mp4"
+	"videoplayer/joy4/format/ts"
+)
+
+// writeTestFile 写入 10 秒、10fps、每秒一个关键帧的 H.264 测试文件
+func writeTestFile(t *testing.T, name string) string {
+	sps, _ := hex.DecodeString("6742001f96540501ed00f0088910")
+	codec, err := h264parser.NewCodecDataFromSPSAndPPS(sps, []byte{0x68, 0xce, 0x38, 0x80})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var muxer av.Muxer
+	if filepath.Ext(name) == ".mp4" {
+		muxer = mp4.NewMuxer(f)
+	} else {
+		muxer = ts.NewMuxer(f)
+	}
+	if err = muxer.WriteHeader([]av.CodecData{codec}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		keyFrame := i%10 == 0
+		nalu := []byte{0, 0, 0, 2, 0x41, 0x9a}
+		if keyFrame {
+			nalu = []byte{0, 0, 0, 2, 0x65, 0x88}
+		}
+		pkt := av.Packet{
+			IsKeyFrame: keyFrame,
+			Time:       time.Duration(i) * 100 * time.Millisecond,
+			Data:       nalu,
+		}
+		if err = muxer.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = muxer.WriteTrailer(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileSourceSeek(t *testing.T) {
+	for _, name := range []string{"clip.ts", "clip.mp4"} {
+		t.Run(name, func(t *testing.T) {
+			src := newFileSource(writeTestFile(t, name))
+			if err := src.Open(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			defer src.Close()
+
+			if err := src.Seek(3500 * time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+			pkt, err := src.nextPacket()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pkt.IsKeyFrame || pkt.Time != 3*time.Second {
+				t.Errorf("first packet after seek: keyframe=%v time=%v, want keyframe at 3s", pkt.IsKeyFrame, pkt.Time)
+			}
+			pkt, err = src.nextPacket()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pkt.Time != 3100*time.Millisecond {
+				t.Errorf("second packet after seek: time=%v, want 3.1s", pkt.Time)
+			}
+		})
+	}
+}
+
+func TestFileSourceLoop(t *testing.T) {
+	src := newFileSource(writeTestFile(t, "clip.ts"))
+	if err := src.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	src.SetLoop(true)
+
+	// ts 的时间戳不一定从 0 开始，以第一个包为准
+	first, err := src.nextPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Seek(9500 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	var last time.Duration
+	for i := 0; i < 40; i++ {
+		pkt, err := src.nextPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkt.Time < last {
+			if !pkt.IsKeyFrame || pkt.Time != first.Time {
+				t.Errorf("after loop: keyframe=%v time=%v, want keyframe at %v", pkt.IsKeyFrame, pkt.Time, first.Time)
+			}
+			return
+		}
+		last = pkt.Time
+	}
+	t.Error("file did not loop")
+}
+
+func TestFileSourceRate(t *testing.T) {
+	src := newFileSource("")
+	if err := src.SetRate(MaxRate * 2); err == nil {
+		t.Error("expected error for rate above MaxRate")
+	}
+	if err := src.SetRate(MinRate / 2); err == nil {
+		t.Error("expected error for rate below MinRate")
+	}
+	if err := src.SetRate(2); err != nil {
+		t.Error(err)
+	}
+}
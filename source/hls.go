@@ -0,0 +1,69 @@
+package source
+
+import (
+	"context"
+	"io"
+
+	"videoplayer/joy4/av"
+	"videoplayer/joy4/format/hls"
+)
+
+// hlsSource HLS 播放列表，分片下载后按时间戳实时送出
+type hlsSource struct {
+	uri     string
+	client  *hls.Client
+	streams []av.CodecData
+	pacer   *pacer
+}
+
+func newHLSSource(uri string) *hlsSource {
+	return &hlsSource{uri: uri, pacer: newPacer()}
+}
+
+func (s *hlsSource) Open(ctx context.Context) error {
+	var streams []av.CodecData
+	c, err := openContext(ctx, func() (io.Closer, error) {
+		client, err := hls.DialWithOptions(s.uri, hls.Options{LiveMode: true})
+		if err != nil {
+			return nil, err
+		}
+		if streams, err = client.Streams(); err != nil {
+			client.Close()
+			return nil, err
+		}
+		return client, nil
+	})
+	if err != nil {
+		return err
+	}
+	s.client = c.(*hls.Client)
+	s.streams = streams
+	return nil
+}
+
+func (s *hlsSource) Streams() ([]av.CodecData, error) {
+	return s.streams, nil
+}
+
+func (s *hlsSource) ReadPacket() (av.Packet, error) {
+	pkt, err := s.client.ReadPacket()
+	if err != nil {
+		return pkt, err
+	}
+	if !s.pacer.wait(pkt.Time) {
+		return av.Packet{}, io.EOF
+	}
+	return pkt, nil
+}
+
+func (s *hlsSource) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+func (s *hlsSource) Close() error {
+	s.pacer.close()
+	if s.client == nil {
+		return nil
+	}
+	return s.client.Close()
+}
@@ -0,0 +1,93 @@
+package source
+
+import (
+	"sync"
+	"time"
+)
+
+// maxPacingGap 包时间戳跳变超过该值时重新对齐，避免时间戳不连续导致长时间阻塞
+const maxPacingGap = 5 * time.Second
+
+// pacer 按包时间戳把读取速度限制为实时，用于文件、HLS 等可以一次读完的视频源，
+// 同时提供暂停：暂停期间 wait 一直阻塞
+type pacer struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	paused  bool
+	closed  bool
+	started bool
+	start   time.Time     // base 对应的墙上时间
+	base    time.Duration // 对齐起点的包时间戳
+	rate    float64       // 播放倍速
+}
+
+func newPacer() *pacer {
+	p := &pacer{rate: 1}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// wait 等到时间戳为 ts 的包应当送出的时刻，返回 false 表示已关闭
+func (p *pacer) wait(ts time.Duration) bool {
+	p.mu.Lock()
+	for p.paused && !p.closed {
+		p.cond.Wait()
+	}
+	if p.closed {
+		p.mu.Unlock()
+		return false
+	}
+	now := time.Now()
+	if !p.started || ts < p.base {
+		p.started = true
+		p.start, p.base = now, ts
+	}
+	delay := time.Until(p.start.Add(time.Duration(float64(ts-p.base) / p.rate)))
+	if delay > maxPacingGap {
+		p.start, p.base = now, ts
+		delay = 0
+	}
+	p.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return true
+}
+
+// pause 暂停送出，恢复后从下一个包重新对齐
+func (p *pacer) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = true
+}
+
+func (p *pacer) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = false
+	p.started = false
+	p.cond.Broadcast()
+}
+
+// setRate 修改倍速，从下一个包重新对齐
+func (p *pacer) setRate(rate float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rate = rate
+	p.started = false
+}
+
+// reset 时间线不连续（如重新定位）时重新对齐
+func (p *pacer) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.started = false
+}
+
+func (p *pacer) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.cond.Broadcast()
+}
@@ -0,0 +1,61 @@
+package source
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"videoplayer/joy4/av"
+	"videoplayer/joy4/format/rtmp"
+)
+
+// rtmpSource RTMP 直播流
+type rtmpSource struct {
+	uri     string
+	conn    *rtmp.Conn
+	streams []av.CodecData
+}
+
+func newRTMPSource(uri string) *rtmpSource {
+	return &rtmpSource{uri: uri}
+}
+
+func (s *rtmpSource) Open(ctx context.Context) error {
+	var streams []av.CodecData
+	c, err := openContext(ctx, func() (io.Closer, error) {
+		conn, err := rtmp.DialTimeout(s.uri, time.Second*10)
+		if err != nil {
+			return nil, err
+		}
+		if streams, err = conn.Streams(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	})
+	if err != nil {
+		return err
+	}
+	s.conn = c.(*rtmp.Conn)
+	s.streams = streams
+	return nil
+}
+
+func (s *rtmpSource) Streams() ([]av.CodecData, error) {
+	return s.streams, nil
+}
+
+func (s *rtmpSource) ReadPacket() (av.Packet, error) {
+	return s.conn.ReadPacket()
+}
+
+func (s *rtmpSource) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+func (s *rtmpSource) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
@@ -0,0 +1,454 @@
+package source
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"videoplayer/joy4/av"
+	"videoplayer/joy4/format/rtsp/rtp"
+	"videoplayer/joy4/format/rtsp/sdp"
+	"videoplayer/rtsp"
+	"videoplayer/transport"
+	"videoplayer/util/apierr"
+	"videoplayer/util/tlsutil"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errLive 直播流不支持回放控制
+var errLive = apierr.New(apierr.InvalidRequest, "rtsp source is live, not a recording")
+
+// rangePlayer 能发送带 Range/Scale 的 PLAY 的 RTSP 客户端
+type rangePlayer interface {
+	PlayRange(r *sdp.Range, scale float64) error
+}
+
+// rtspPlayback RTSP 录像回放控制，通过 PLAY 的 Range 头定位、Scale 头倍速播放
+type rtspPlayback struct {
+	player  rangePlayer
+	sdpInfo sdp.SDPInfo
+	rate    float64
+}
+
+func (p *rtspPlayback) seekable() bool {
+	return p.sdpInfo.RangeEnd > 0
+}
+
+// scale 正常倍速时不发送 Scale 头
+func (p *rtspPlayback) scale() float64 {
+	if p.rate == 0 || p.rate == 1 {
+		return 0
+	}
+	return p.rate
+}
+
+// Seek 定位到录像开始后 to 的位置，clock 范围的录像换算为绝对时间
+func (p *rtspPlayback) Seek(to time.Duration) error {
+	if !p.seekable() {
+		return errLive
+	}
+	r := sdp.NPTRange(to, 0)
+	if rng := p.sdpInfo.Range; rng.Clock {
+		r = sdp.ClockRange(rng.ClockStart.Add(to), rng.ClockEnd)
+	}
+	return p.player.PlayRange(&r, p.scale())
+}
+
+// SeekClock 定位到绝对时间，仅 clock 范围的录像支持
+func (p *rtspPlayback) SeekClock(t time.Time) error {
+	rng := p.sdpInfo.Range
+	if !p.seekable() {
+		return errLive
+	}
+	if !rng.Clock {
+		return apierr.New(apierr.InvalidRequest, "recording range %v is not addressed by clock", rng)
+	}
+	r := sdp.ClockRange(t, rng.ClockEnd)
+	return p.player.PlayRange(&r, p.scale())
+}
+
+// SetRate 通过 Scale 头倍速播放，从当前位置继续
+func (p *rtspPlayback) SetRate(rate float64) error {
+	if !p.seekable() {
+		return errLive
+	}
+	if rate < MinRate || rate > MaxRate {
+		return apierr.New(apierr.InvalidRequest, "rate %v out of range [%v, %v]", rate, MinRate, MaxRate)
+	}
+	if err := p.player.PlayRange(nil, rate); err != nil {
+		return err
+	}
+	p.rate = rate
+	return nil
+}
+
+// Play 暂停后恢复播放，保持当前倍速
+func (p *rtspPlayback) Play() error {
+	return p.player.PlayRange(nil, p.scale())
+}
+
+// rtspSource RTSP 视频源，统一使用 rtsp.Client，按地址和选项选择传输：
+// WSP 代理、TCP（RTP 交织或 UDP 单播/组播）、TLS（rtsps）或 HTTP 隧道
+type rtspSource struct {
+	rtspPlayback
+	wsurl  string
+	uri    string
+	opts   Options
+	client *rtsp.Client
+	// replay 不为空时回放录制的会话或抓包
+	replay transport.Conn
+	// first 打开 UDP 时为确认能收到数据而读取的第一个包，由 ReadPacket 先返回
+	first *av.Packet
+
+	// conn 最近建立的 RTSP 连接，打开被取消时断开它，使阻塞的请求返回；
+	// closed 在 Close 后为 true，读取协程此后不再使用 client；
+	// recorder 录制最近建立的连接，captureStart 为按 RTCP SR 换算的录制开始时间，
+	// Close 时据此给录制文件改名
+	mu           sync.Mutex
+	conn         transport.Conn
+	closed       bool
+	recorder     *transport.Recorder
+	captureStart time.Time
+}
+
+func newRTSPSource(wsurl, uri string, opts Options) *rtspSource {
+	return &rtspSource{wsurl: wsurl, uri: uri, opts: opts, rtspPlayback: rtspPlayback{rate: 1}}
+}
+
+// newReplaySource 用 rtsp.Client 回放 transport.Recorder 录制的会话
+func newReplaySource(name string, opts Options) (*rtspSource, error) {
+	replay, err := transport.OpenReplay(name)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ReplaySpeed != 0 {
+		replay.Speed = opts.ReplaySpeed
+	}
+	opts.Record = ""
+	s := newRTSPSource("", replay.URL(), opts)
+	s.replay = replay
+	return s, nil
+}
+
+// newPcapSource 用 rtsp.Client 回放抓包中的一路 RTP 流，query 的 flow、ssrc 和 sdp 参数选择流和提供 SDP，
+// 没有 sdp 时使用抓包中的 DESCRIBE 响应
+func newPcapSource(name string, query url.Values, opts Options) (*rtspSource, error) {
+	var (
+		filter transport.PcapFilter
+		sdp    []byte
+		err    error
+	)
+	if flow := query.Get("flow"); flow != "" {
+		if filter, err = transport.ParsePcapFlow(flow); err != nil {
+			return nil, apierr.Wrap(apierr.InvalidRequest, 0, err)
+		}
+	}
+	if ssrc := query.Get("ssrc"); ssrc != "" {
+		n, err := strconv.ParseUint(ssrc, 0, 32)
+		if err != nil {
+			return nil, apierr.New(apierr.InvalidRequest, "bad ssrc %q: %v", ssrc, err)
+		}
+		filter.SSRC = uint32(n)
+	}
+	if file := query.Get("sdp"); file != "" {
+		if sdp, err = os.ReadFile(file); err != nil {
+			return nil, err
+		}
+	}
+	capture, err := transport.OpenPcap(name, filter, sdp)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ReplaySpeed != 0 {
+		capture.Speed = opts.ReplaySpeed
+	}
+	log.Infof("playing %v from capture %v", capture.Flow(), name)
+	opts.Record = ""
+	s := newRTSPSource("", capture.URL(), opts)
+	s.replay = capture
+	return s, nil
+}
+
+// dial 为 uri 建立 RTSP 控制连接，重定向时同样使用
+func (s *rtspSource) dial(ctx context.Context, uri string) (transport.Transporter, error) {
+	var (
+		conn transport.Conn
+		err  error
+	)
+	switch {
+	case s.replay != nil:
+		conn = s.replay
+	case s.wsurl != "":
+		var wsp *transport.WebSocketProxy
+		if wsp, err = transport.NewWebSocketProxy(s.wsurl, uri); err == nil {
+			wsp.Dialer = tlsutil.WebSocketDialer(s.opts.ProxyTLSConfig, s.opts.HTTPProxy)
+			wsp.TokenSource = s.opts.TokenSource
+			wsp.Signal = s.opts.Signal
+		}
+		conn = wsp
+	case strings.HasPrefix(strings.ToLower(uri), "rtsps:"):
+		conn, err = transport.NewTLSTransport(uri, s.opts.TLSConfig)
+	case s.opts.Transport == TransportHTTP:
+		conn, err = transport.NewHTTPTunnelTransport(uri)
+	default:
+		conn, err = transport.NewTCPTransport(uri)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.Signal != nil {
+		conn = transport.NewSignalTransport(conn, uri, s.opts.Signal)
+	}
+	if err = transport.Connect(ctx, conn); err != nil {
+		log.Errorf("rtsp transport connect failed: %v", err)
+		conn.Disconnect()
+		return nil, err
+	}
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	if s.opts.Record != "" {
+		f, err := os.Create(s.opts.Record)
+		if err != nil {
+			log.Errorf("rtsp record %v: %v", s.opts.Record, err)
+			return conn, nil
+		}
+		log.Infof("recording rtsp session %v to %v", uri, s.opts.Record)
+		rec := transport.NewRecorder(conn, f)
+		s.mu.Lock()
+		s.recorder, s.captureStart = rec, time.Time{}
+		s.mu.Unlock()
+		return rec, nil
+	}
+	return conn, nil
+}
+
+// udpUnavailable UDP 传输不可用：服务端不支持或收不到数据，可以改用 TCP
+func udpUnavailable(err error) bool {
+	if err == rtsp.ErrTimeout || err == rtsp.ErrNoUDPPortPair {
+		return true
+	}
+	rtspErr, ok := err.(*rtsp.RTSPError)
+	// 461 Unsupported Transport
+	return ok && rtspErr.Code == 461
+}
+
+func (s *rtspSource) Open(ctx context.Context) error {
+	mode := s.opts.Transport
+	if mode == "" || s.wsurl != "" || s.replay != nil {
+		mode = TransportTCP
+	}
+	err := s.open(ctx, mode)
+	if err != nil && (mode == TransportUDP || mode == TransportMulticast) && udpUnavailable(err) {
+		log.Warnf("rtsp %v over %v failed: %v, falling back to tcp", s.uri, mode, err)
+		s.teardown()
+		err = s.open(ctx, TransportTCP)
+	}
+	return err
+}
+
+// disconnectOnDone ctx 结束时断开最近建立的连接，DESCRIBE、SETUP 等请求不带 ctx，
+// 断开后立即返回
+func (s *rtspSource) disconnectOnDone(ctx context.Context) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			if s.conn != nil {
+				s.conn.Disconnect()
+			}
+			s.mu.Unlock()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+func (s *rtspSource) open(ctx context.Context, mode Transport) error {
+	trans, err := s.dial(ctx, s.uri)
+	if err != nil {
+		return err
+	}
+	client, err := rtsp.NewClient(s.uri, trans)
+	if err != nil {
+		if conn, ok := trans.(transport.Conn); ok {
+			conn.Disconnect()
+		}
+		return err
+	}
+	client.Redial = func(uri string) (transport.Transporter, error) {
+		return s.dial(ctx, uri)
+	}
+	switch mode {
+	case TransportUDP:
+		client.UseUDP = true
+	case TransportMulticast:
+		client.UseUDP = true
+		client.Multicast = true
+	}
+	if client.UseUDP {
+		client.RtpTimeout = s.opts.udpTimeout()
+		client.ReorderQueueSize = s.opts.reorderQueue()
+	}
+	s.client = client
+	s.player = client
+
+	stop := s.disconnectOnDone(ctx)
+	sdpInfo, err := client.SDP()
+	if err == nil && client.UseUDP {
+		err = s.awaitUDP(client)
+	}
+	stop()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return err
+	}
+	s.sdpInfo = sdpInfo
+	return nil
+}
+
+// awaitUDP 等待第一个 RTP 包，确认 UDP 能收到数据。SDP 带 sprop-parameter-sets 时
+// SDP 不读取数据，被 NAT 或防火墙挡住的 UDP 要到播放后才超时，重连时又会再用 UDP；
+// 在这里超时则由 Open 改用 TCP
+func (s *rtspSource) awaitUDP(client *rtsp.Client) error {
+	pkt, err := client.ReadPacket()
+	if err != nil {
+		return err
+	}
+	s.first = &pkt
+	return nil
+}
+
+func (s *rtspSource) Streams() ([]av.CodecData, error) {
+	return s.sdpInfo.CodecDatas, nil
+}
+
+func (s *rtspSource) ReadPacket() (av.Packet, error) {
+	if s.isClosed() {
+		return av.Packet{}, io.EOF
+	}
+	if pkt := s.first; pkt != nil {
+		s.first = nil
+		s.syncRecording(*pkt)
+		return *pkt, nil
+	}
+	pkt, err := s.client.ReadPacket()
+	if err == nil {
+		s.syncRecording(pkt)
+	}
+	return pkt, err
+}
+
+// syncRecording 第一个按 RTCP SR 计算采集时间的包到达时，把录制开始时间换算成流的
+// 采集时间写入录制；此前的包只有到达时间
+func (s *rtspSource) syncRecording(pkt av.Packet) {
+	if pkt.WallClock.IsZero() {
+		return
+	}
+	s.mu.Lock()
+	rec, synced := s.recorder, !s.captureStart.IsZero()
+	s.mu.Unlock()
+	if rec == nil || synced {
+		return
+	}
+	stats := s.client.Stats()
+	if int(pkt.Idx) >= len(stats) || !stats[pkt.Idx].SenderClock {
+		return
+	}
+	start := rec.SyncCapture(pkt.WallClock)
+	s.mu.Lock()
+	if s.recorder == rec {
+		s.captureStart = start
+	}
+	s.mu.Unlock()
+}
+
+func (s *rtspSource) Capabilities() Capabilities {
+	seekable := s.sdpInfo.RangeEnd > 0
+	return Capabilities{Seekable: seekable, Pausable: seekable}
+}
+
+func (s *rtspSource) Stats() []rtp.Stats {
+	if s.isClosed() {
+		return nil
+	}
+	return s.client.Stats()
+}
+
+func (s *rtspSource) Pause() error {
+	return s.client.Pause()
+}
+
+// Close 可以在读取协程仍在 ReadPacket 时调用：client 保留不置空，断开后读取返回错误，
+// 之后的 ReadPacket 返回 io.EOF
+func (s *rtspSource) Close() error {
+	return s.CloseContext(context.Background())
+}
+
+// CloseContext 同 Close，ctx 结束时不再等待 TEARDOWN 的响应，直接断开连接
+func (s *rtspSource) CloseContext(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+	stop := s.disconnectOnDone(ctx)
+	defer stop()
+	err := s.teardown()
+	s.renameRecording()
+	return err
+}
+
+// renameRecording 录制文件关闭后按 RTCP SR 的采集开始时间改名，已有同名文件时保留原名
+func (s *rtspSource) renameRecording() {
+	s.mu.Lock()
+	start := s.captureStart
+	s.mu.Unlock()
+	if start.IsZero() || s.opts.RecordName == nil {
+		return
+	}
+	path := filepath.Join(filepath.Dir(s.opts.Record), s.opts.RecordName(start))
+	if path == s.opts.Record {
+		return
+	}
+	if _, err := os.Stat(path); err == nil {
+		log.Warnf("rtsp record %v: %v exists, keeping the name", s.opts.Record, path)
+		return
+	}
+	if err := os.Rename(s.opts.Record, path); err != nil {
+		log.Errorf("rtsp record %v: %v", s.opts.Record, err)
+		return
+	}
+	log.Infof("rtsp recording %v renamed to %v", s.opts.Record, path)
+}
+
+func (s *rtspSource) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// teardown 结束会话并断开连接，UDP 打开失败改用 TCP 前也会调用
+func (s *rtspSource) teardown() error {
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Teardown()
+	if err != nil {
+		log.Errorf("TEARDOWN failed: %v", err)
+	}
+	s.client.Close()
+	return err
+}
@@ -0,0 +1,435 @@
+package source
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"videoplayer/joy4/av"
+	"videoplayer/joy4/format/rtsp/sdp"
+	"videoplayer/transport"
+	"videoplayer/util/pcap"
+)
+
+type fakePlayer struct {
+	ranges []string
+	scales []float64
+}
+
+func (f *fakePlayer) PlayRange(r *sdp.Range, scale float64) error {
+	s := ""
+	if r != nil {
+		s = r.String()
+	}
+	f.ranges = append(f.ranges, s)
+	f.scales = append(f.scales, scale)
+	return nil
+}
+
+func TestRTSPPlayback(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	player := &fakePlayer{}
+	p := rtspPlayback{player: player, rate: 1}
+	p.sdpInfo.Range = sdp.ClockRange(start, start.Add(time.Hour))
+	p.sdpInfo.RangeEnd = 3600
+
+	if err := p.Seek(90 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetRate(4); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SeekClock(start.Add(10 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Play(); err != nil {
+		t.Fatal(err)
+	}
+	wantRanges := []string{
+		"clock=20240102T030130Z-20240102T040000Z",
+		"",
+		"clock=20240102T031000Z-20240102T040000Z",
+		"",
+	}
+	wantScales := []float64{0, 4, 4, 4}
+	for i := range wantRanges {
+		if player.ranges[i] != wantRanges[i] || player.scales[i] != wantScales[i] {
+			t.Errorf("PLAY #%d = %q scale %v, want %q scale %v",
+				i, player.ranges[i], player.scales[i], wantRanges[i], wantScales[i])
+		}
+	}
+	if err := p.SetRate(16); err == nil {
+		t.Error("expected error for rate out of range")
+	}
+
+	live := rtspPlayback{player: player, rate: 1}
+	if err := live.Seek(time.Second); err != errLive {
+		t.Errorf("Seek on live stream = %v, want errLive", err)
+	}
+	npt := rtspPlayback{player: player, rate: 1}
+	npt.sdpInfo.Range = sdp.NPTRange(0, time.Minute)
+	npt.sdpInfo.RangeEnd = 60
+	if err := npt.SeekClock(start); err == nil {
+		t.Error("expected error seeking npt recording by clock")
+	}
+}
+
+// writeH264Capture 写一路 UDP 上的 H264 RTP 流：SPS、PPS 和两个 IDR 帧
+func writeH264Capture(t *testing.T, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := pcap.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sps, _ := hex.DecodeString("6742001f96540501ed00f0088910")
+	nalus := [][]byte{sps, {0x68, 0xce, 0x38, 0x80}, {0x65, 0x88, 0x84, 0x00, 0x33, 0xff}, {0x65, 0x88, 0x84, 0x00, 0x44, 0xff}}
+	at := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	for i, nalu := range nalus {
+		hdr := make([]byte, 12)
+		hdr[0], hdr[1] = 0x80, 96
+		if nalu[0]&0x1f == 5 {
+			// 每个 IDR 单独一帧，带 marker 位
+			hdr[1] |= 0x80
+		}
+		ts := uint32(0)
+		if i == 3 {
+			ts = 3600
+		}
+		binary.BigEndian.PutUint16(hdr[2:4], uint16(i))
+		binary.BigEndian.PutUint32(hdr[4:8], ts)
+		binary.BigEndian.PutUint32(hdr[8:12], 0x1234)
+		err := w.WritePacket(pcap.Packet{
+			Time: at.Add(time.Duration(i) * 40 * time.Millisecond), Proto: pcap.ProtoUDP,
+			Src: netip.MustParseAddrPort("10.0.0.9:6000"), Dst: netip.MustParseAddrPort("10.0.0.2:5000"),
+			Payload: append(hdr, nalu...),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPcapSource(t *testing.T) {
+	dir := t.TempDir()
+	capture := filepath.Join(dir, "cam.pcap")
+	writeH264Capture(t, capture)
+	sdpFile := filepath.Join(dir, "cam.sdp")
+	sdpText := "v=0\r\ns=cam\r\nm=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=fmtp:96 packetization-mode=1\r\n"
+	if err := os.WriteFile(sdpFile, []byte(sdpText), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	query := url.Values{"flow": {"udp:10.0.0.9:6000>"}, "ssrc": {"0x1234"}, "sdp": {sdpFile}}
+	src, err := New("", "file://"+filepath.ToSlash(capture)+"?"+query.Encode(), Options{ReplaySpeed: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	streams, err := src.Streams()
+	if err != nil || len(streams) != 1 || streams[0] == nil || streams[0].Type() != av.H264 {
+		t.Fatalf("Streams() = %v, %v", streams, err)
+	}
+	keyFrames := 0
+	for {
+		pkt, err := src.ReadPacket()
+		if err != nil {
+			break
+		}
+		if pkt.IsKeyFrame {
+			keyFrames++
+		}
+	}
+	if keyFrames == 0 {
+		t.Error("no key frame read from the capture")
+	}
+
+	if _, err := New("", "file://"+filepath.ToSlash(capture)+"?ssrc=0x9999", Options{}); err == nil {
+		t.Error("expected error for a capture with no matching flow")
+	}
+}
+
+func TestRTSPSourceCloseWhileReading(t *testing.T) {
+	dir := t.TempDir()
+	capture := filepath.Join(dir, "cam.pcap")
+	writeH264Capture(t, capture)
+	sdpFile := filepath.Join(dir, "cam.sdp")
+	sdpText := "v=0\r\ns=cam\r\nm=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=fmtp:96 packetization-mode=1\r\n"
+	if err := os.WriteFile(sdpFile, []byte(sdpText), 0600); err != nil {
+		t.Fatal(err)
+	}
+	query := url.Values{"flow": {"udp:10.0.0.9:6000>"}, "sdp": {sdpFile}}
+	src, err := New("", "file://"+filepath.ToSlash(capture)+"?"+query.Encode(), Options{ReplaySpeed: 0.1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the demuxer closes the source while its read goroutine is still running
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, err := src.ReadPacket(); err != nil {
+				return
+			}
+			src.(StatsReporter).Stats()
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	src.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ReadPacket did not return after Close")
+	}
+	if _, err := src.ReadPacket(); err == nil {
+		t.Error("ReadPacket succeeded after Close")
+	}
+}
+
+// rtspServer 按固定脚本回复的 RTSP 服务端，SDP 带 sprop-parameter-sets，
+// TCP 交织的会话 PLAY 之后持续发送 IDR
+type rtspServer struct {
+	ln net.Listener
+	// udp 接受 UDP 的 SETUP，但从不发送 UDP 数据
+	udp bool
+	// noTeardown 不回复 TEARDOWN
+	noTeardown bool
+	// sr 不为零时 PLAY 后先发送 RTCP SR，RTP 时间戳 0 对应 sr
+	sr time.Time
+
+	mu         sync.Mutex
+	transports []string
+}
+
+func newRTSPServer(t *testing.T) *rtspServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &rtspServer{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *rtspServer) url() string {
+	return "rtsp://" + s.ln.Addr().String() + "/live"
+}
+
+func (s *rtspServer) setups() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.transports...)
+}
+
+func (s *rtspServer) serve(conn net.Conn) {
+	defer conn.Close()
+	sps, _ := hex.DecodeString("6742001f96540501ed00f0088910")
+	pps := []byte{0x68, 0xce, 0x38, 0x80}
+	sdpText := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=cam\r\nt=0 0\r\nm=video 0 RTP/AVP 96\r\n" +
+		"a=rtpmap:96 H264/90000\r\na=fmtp:96 packetization-mode=1;sprop-parameter-sets=" +
+		base64.StdEncoding.EncodeToString(sps) + "," + base64.StdEncoding.EncodeToString(pps) + "\r\n" +
+		"a=control:trackID=0\r\n"
+
+	var writeMu sync.Mutex
+	write := func(b []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_, err := conn.Write(b)
+		return err
+	}
+	interleaved := false
+	r := textproto.NewReader(bufio.NewReader(conn))
+	for {
+		line, err := r.ReadLine()
+		if err != nil {
+			return
+		}
+		header, err := r.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		method, _, _ := strings.Cut(line, " ")
+		res := "RTSP/1.0 200 OK\r\nCSeq: " + header.Get("CSeq") + "\r\n"
+		body := ""
+		switch method {
+		case "OPTIONS":
+			res += "Public: OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN\r\n"
+		case "DESCRIBE":
+			res += "Content-Type: application/sdp\r\nContent-Base: " + s.url() + "/\r\n"
+			body = sdpText
+		case "SETUP":
+			transport := header.Get("Transport")
+			s.mu.Lock()
+			s.transports = append(s.transports, transport)
+			s.mu.Unlock()
+			switch {
+			case strings.Contains(transport, "RTP/AVP/TCP"):
+				interleaved = true
+				res += "Session: 1\r\nTransport: " + transport + "\r\n"
+			case s.udp:
+				res += "Session: 1\r\nTransport: " + transport + ";server_port=9-10\r\n"
+			default:
+				res = "RTSP/1.0 461 Unsupported Transport\r\nCSeq: " + header.Get("CSeq") + "\r\n"
+			}
+		case "PLAY":
+			res += "Session: 1\r\n"
+			if interleaved {
+				go func() {
+					if !s.sr.IsZero() {
+						frame := make([]byte, 4+28)
+						frame[0], frame[1] = '$', 1
+						binary.BigEndian.PutUint16(frame[2:], 28)
+						frame[4], frame[5] = 0x80, 200
+						binary.BigEndian.PutUint16(frame[6:], 6)
+						binary.BigEndian.PutUint32(frame[8:], 1)
+						ntp := uint64(s.sr.Unix()+2208988800)<<32 | uint64(s.sr.Nanosecond())<<32/uint64(time.Second)
+						binary.BigEndian.PutUint64(frame[12:], ntp)
+						if write(frame) != nil {
+							return
+						}
+					}
+					idr := []byte{0x65, 0x88, 0x84, 0x00, 0x33, 0xff}
+					for seq := uint16(0); ; seq++ {
+						frame := make([]byte, 4+12+len(idr))
+						frame[0] = '$'
+						binary.BigEndian.PutUint16(frame[2:], uint16(12+len(idr)))
+						frame[4] = 0x80
+						frame[5] = 0x80 | 96
+						binary.BigEndian.PutUint16(frame[6:], seq)
+						binary.BigEndian.PutUint32(frame[8:], uint32(seq)*3600)
+						binary.BigEndian.PutUint32(frame[12:], 1)
+						copy(frame[16:], idr)
+						if write(frame) != nil {
+							return
+						}
+						time.Sleep(20 * time.Millisecond)
+					}
+				}()
+			}
+		case "TEARDOWN":
+			if s.noTeardown {
+				continue
+			}
+		}
+		if body != "" {
+			res += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n"
+		}
+		if write([]byte(res+"\r\n"+body)) != nil {
+			return
+		}
+	}
+}
+
+func TestRTSPSourceCloseContext(t *testing.T) {
+	server := newRTSPServer(t)
+	server.noTeardown = true
+	src := newRTSPSource("", server.url(), Options{})
+	if err := src.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server never answers TEARDOWN, closing gives up when ctx ends
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	Close(ctx, src)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close took %v", elapsed)
+	}
+}
+
+func TestRTSPSourceUDPFallbackWithCodecData(t *testing.T) {
+	server := newRTSPServer(t)
+	server.udp = true
+	src := newRTSPSource("", server.url(), Options{Transport: TransportUDP, UDPTimeout: 200 * time.Millisecond})
+	defer src.Close()
+
+	// the SDP carries codec data and no UDP packet ever arrives, Open must
+	// notice before returning and switch to TCP
+	if err := src.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	setups := server.setups()
+	if len(setups) != 2 || !strings.Contains(setups[1], "RTP/AVP/TCP") {
+		t.Fatalf("SETUP transports = %q", setups)
+	}
+	pkt, err := src.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pkt.IsKeyFrame {
+		t.Error("first packet is not a key frame")
+	}
+}
+
+func TestRTSPSourceRecordCaptureTime(t *testing.T) {
+	server := newRTSPServer(t)
+	// the camera clock is an hour behind
+	server.sr = time.Now().Add(-time.Hour)
+	dir := t.TempDir()
+	name := func(start time.Time) string { return "cam-" + start.Format("20060102-150405") + ".wsprec" }
+	src := newRTSPSource("", server.url(), Options{
+		Record:     filepath.Join(dir, name(time.Now())),
+		RecordName: name,
+	})
+	if err := src.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := src.ReadPacket(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.wsprec"))
+	if len(files) != 1 {
+		t.Fatalf("recordings = %q", files)
+	}
+	start, err := time.ParseInLocation("cam-20060102-150405.wsprec", filepath.Base(files[0]), time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := start.Sub(server.sr); d < -2*time.Second || d > 2*time.Second {
+		t.Errorf("recording named %v, camera started at %v", files[0], server.sr)
+	}
+	replay, err := transport.OpenReplay(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := replay.CaptureStart().Sub(server.sr); d < -time.Second || d > time.Second {
+		t.Errorf("CaptureStart = %v, camera started at %v", replay.CaptureStart(), server.sr)
+	}
+}
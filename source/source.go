@@ -0,0 +1,231 @@
+// Package source 统一不同协议视频源的读取方式：WSP 代理的 RTSP、直连 RTSP（含 RTSPS 和 HTTP 隧道）、
+// 本地文件（mp4/ts/flv）、HLS 和 RTMP。
+package source
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"videoplayer/joy4/av"
+	"videoplayer/joy4/format/rtsp/rtp"
+	"videoplayer/transport"
+	"videoplayer/util/apierr"
+	"videoplayer/util/proxyutil"
+)
+
+// Source 视频源。先 Open 建立连接，再通过 Streams 获取各路流的编码信息，
+// ReadPacket 返回的 av.Packet.Idx 对应 Streams 的下标。ctx 结束时 Open 放弃打开并返回 ctx.Err()
+type Source interface {
+	Open(ctx context.Context) error
+	Streams() ([]av.CodecData, error)
+	ReadPacket() (av.Packet, error)
+	Close() error
+	Capabilities() Capabilities
+}
+
+// ContextCloser 关闭时需要等待对端响应的视频源，如 RTSP 的 TEARDOWN，ctx 结束时不再等待直接断开
+type ContextCloser interface {
+	CloseContext(ctx context.Context) error
+}
+
+// Close 关闭视频源，实现了 ContextCloser 的视频源最多等待到 ctx 结束
+func Close(ctx context.Context, src Source) error {
+	if c, ok := src.(ContextCloser); ok {
+		return c.CloseContext(ctx)
+	}
+	return src.Close()
+}
+
+// Capabilities 视频源支持的能力，Open 之后才准确
+type Capabilities struct {
+	// Seekable 可定位的录像或文件，各窗口播放进度不同，不能共享
+	Seekable bool
+	// Pausable 可以让视频源本身暂停发送，实现了 Pauser
+	Pausable bool
+}
+
+// Pauser 支持暂停的视频源，如 RTSP 回放和本地文件
+type Pauser interface {
+	Pause() error
+	Play() error
+}
+
+// Seeker 支持定位的视频源，定位到目标时间之前最近的关键帧
+type Seeker interface {
+	Seek(to time.Duration) error
+}
+
+// StatsReporter 能提供逐路 RTP 接收统计的视频源，只能在读取协程中调用
+type StatsReporter interface {
+	Stats() []rtp.Stats
+}
+
+// ClockSeeker 按绝对时间定位的视频源，如以 clock 范围描述的 NVR 录像
+type ClockSeeker interface {
+	SeekClock(t time.Time) error
+}
+
+// 播放倍速范围
+const (
+	MinRate = 0.25
+	MaxRate = 8
+)
+
+// RateController 支持倍速播放的视频源
+type RateController interface {
+	SetRate(rate float64) error
+}
+
+// Looper 支持循环播放的视频源，读到结尾后从头开始
+type Looper interface {
+	SetLoop(loop bool)
+}
+
+// Transport 直连 RTSP 时 RTP 的传输方式
+type Transport string
+
+const (
+	// TransportTCP RTP 交织在 RTSP 连接中（interleaved），默认方式
+	TransportTCP Transport = "tcp"
+	// TransportUDP RTP/AVP 单播
+	TransportUDP Transport = "udp"
+	// TransportMulticast RTP/AVP 组播，组地址由服务端在 SETUP 响应中指定
+	TransportMulticast Transport = "multicast"
+	// TransportHTTP RTSP 和 RTP 通过 HTTP 隧道传输（GET/POST 两条连接），用于只放行 HTTP 的网络
+	TransportHTTP Transport = "http"
+)
+
+const (
+	defaultUDPTimeout   = 5 * time.Second
+	defaultReorderQueue = 100
+)
+
+// Options 打开视频源的可选参数，零值使用默认设置
+type Options struct {
+	// Transport 直连 RTSP 的传输方式，为空时使用 TCP
+	Transport Transport
+	// UDPTimeout UDP/组播在该时间内收不到数据时回退到 TCP
+	UDPTimeout time.Duration
+	// ReorderQueue UDP 乱序重排队列的包数
+	ReorderQueue int
+	// TLSConfig rtsps:// 的 TLS 配置，为空时用系统根证书校验服务端
+	TLSConfig *tls.Config
+	// ProxyTLSConfig WSP 代理（wss://）的 TLS 配置，为空时用系统根证书校验代理
+	ProxyTLSConfig *tls.Config
+	// HTTPProxy 连接 WSP 代理经过的出站代理（HTTP CONNECT 或 SOCKS5），为空时按环境变量选择
+	HTTPProxy proxyutil.Func
+	// Record 录制 RTSP 会话（请求、响应和交织数据）的文件路径，UDP 收到的数据不录制
+	Record string
+	// RecordName 收到 RTCP SR 时用流的采集开始时间生成录制文件名，关闭时按它改名；
+	// 为空或没有收到 SR 时保留 Record 的文件名
+	RecordName func(captureStart time.Time) string
+	// ReplaySpeed 回放 .wsprec 录制文件和 pcap 抓包的倍速，为 0 时按原速，小于 0 时不限速
+	ReplaySpeed float64
+	// TokenSource 获取 WSP 代理的新 token，用于 token 过期前刷新和控制连接重连
+	TokenSource func() (string, error)
+	// Signal 记录 RTSP 和 WSP 信令，用于排查打开失败，为空时不记录
+	Signal *transport.SignalLog
+}
+
+func (o Options) validate() error {
+	switch o.Transport {
+	case "", TransportTCP, TransportUDP, TransportMulticast, TransportHTTP:
+		return nil
+	}
+	return apierr.New(apierr.InvalidRequest, "unsupported rtsp transport: %v", o.Transport)
+}
+
+func (o Options) udpTimeout() time.Duration {
+	if o.UDPTimeout > 0 {
+		return o.UDPTimeout
+	}
+	return defaultUDPTimeout
+}
+
+func (o Options) reorderQueue() int {
+	if o.ReorderQueue > 0 {
+		return o.ReorderQueue
+	}
+	return defaultReorderQueue
+}
+
+// New 根据地址选择视频源实现。wsurl 不为空时通过 WSP 代理拉取 RTSP，
+// 否则按 rawURL 的 scheme 和扩展名区分 RTSP、RTMP、HLS、本地文件、RTSP 会话录制文件和抓包。
+// 抓包用 file:// 地址的参数选择 RTP 流：flow=udp:10.0.0.9:5000>10.0.0.2:6000、
+// ssrc=0x1234 和 sdp=/path/cam.sdp，见 transport.ParsePcapFlow
+func New(wsurl, rawURL string, opts Options) (Source, error) {
+	if wsurl != "" {
+		return newRTSPSource(wsurl, rawURL, opts), nil
+	}
+	if rawURL == "" {
+		return nil, apierr.New(apierr.InvalidRequest, "source url is empty")
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || len(u.Scheme) <= 1 {
+		// 没有 scheme 或 windows 盘符，按本地文件处理
+		return newLocalSource(rawURL, nil, opts)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "rtsp", "rtsps":
+		if err := opts.validate(); err != nil {
+			return nil, err
+		}
+		// RTSPS 只支持 RTP 交织在 TLS 连接中
+		if strings.EqualFold(u.Scheme, "rtsps") && opts.Transport != "" && opts.Transport != TransportTCP {
+			return nil, apierr.New(apierr.InvalidRequest, "rtsps does not support %v transport", opts.Transport)
+		}
+		return newRTSPSource("", rawURL, opts), nil
+	case "rtmp":
+		return newRTMPSource(rawURL), nil
+	case "http", "https":
+		if strings.EqualFold(path.Ext(u.Path), ".m3u8") {
+			return newHLSSource(rawURL), nil
+		}
+		return nil, apierr.New(apierr.InvalidRequest, "unsupported http source: %v", rawURL)
+	case "file":
+		return newLocalSource(u.Path, u.Query(), opts)
+	}
+	return nil, apierr.New(apierr.InvalidRequest, "unsupported source scheme: %v", u.Scheme)
+}
+
+// newLocalSource 本地文件，.wsprec 为 RTSP 会话录制文件，.pcap/.pcapng 为抓包
+func newLocalSource(name string, query url.Values, opts Options) (Source, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".wsprec":
+		return newReplaySource(name, opts)
+	case ".pcap", ".pcapng":
+		return newPcapSource(name, query, opts)
+	}
+	return newFileSource(name), nil
+}
+
+// openContext 在协程中执行不支持 ctx 的 open，ctx 先结束时返回 ctx.Err()，
+// 之后打开成功的连接由协程关闭
+func openContext(ctx context.Context, open func() (io.Closer, error)) (io.Closer, error) {
+	type result struct {
+		c   io.Closer
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, err := open()
+		done <- result{c, err}
+	}()
+	select {
+	case r := <-done:
+		return r.c, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.err == nil {
+				r.c.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,119 @@
+package source
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"videoplayer/transport"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{url: "rtsp://127.0.0.1:554/live", want: "*source.rtspSource"},
+		{url: "rtsps://127.0.0.1:322/live", want: "*source.rtspSource"},
+		{url: "rtmp://127.0.0.1/app/stream", want: "*source.rtmpSource"},
+		{url: "https://example.com/live/index.m3u8?token=x", want: "*source.hlsSource"},
+		{url: "file:///data/record.mp4", want: "*source.fileSource"},
+		{url: "/data/record.ts", want: "*source.fileSource"},
+		{url: `C:\data\record.flv`, want: "*source.fileSource"},
+		{url: "https://example.com/record.mp4", wantErr: true},
+		{url: "ftp://example.com/record.mp4", wantErr: true},
+		{url: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		src, err := New("", tt.url, Options{})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("New(%q) expected error, got %T", tt.url, src)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("New(%q) unexpected error: %v", tt.url, err)
+			continue
+		}
+		if got := fmt.Sprintf("%T", src); got != tt.want {
+			t.Errorf("New(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestNewFilePath(t *testing.T) {
+	src, err := New("", "file:///data/record.mp4", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := src.(*fileSource).path; got != "/data/record.mp4" {
+		t.Errorf("file path = %v, want /data/record.mp4", got)
+	}
+}
+
+type echoTransport struct{}
+
+func (echoTransport) Send([]byte) ([]byte, error) { return []byte("RTSP/1.0 200 OK\r\n\r\n"), nil }
+func (echoTransport) ReadData() ([]byte, error)   { return nil, os.ErrClosed }
+
+func TestNewReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cam1.wsprec")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := transport.NewRecorder(echoTransport{}, f)
+	if _, err := rec.Send([]byte("OPTIONS rtsp://10.0.0.2/live RTSP/1.0\r\nCSeq: 1\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	rec.Close()
+
+	src, err := New("", "file://"+filepath.ToSlash(path), Options{ReplaySpeed: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := src.(*rtspSource)
+	if !ok || s.replay == nil {
+		t.Fatalf("New(%v) = %T, want replaying *source.rtspSource", path, src)
+	}
+	if speed := s.replay.(*transport.Replayer).Speed; s.uri != "rtsp://10.0.0.2/live" || speed != 4 {
+		t.Errorf("replay uri = %v speed = %v", s.uri, speed)
+	}
+	if _, err := New("", filepath.Join(t.TempDir(), "missing.wsprec"), Options{}); err == nil {
+		t.Error("expected error for missing recording")
+	}
+}
+
+func TestNewRTSPTransport(t *testing.T) {
+	tests := []struct {
+		url       string
+		transport Transport
+		want      string
+		wantErr   bool
+	}{
+		{url: "rtsp://127.0.0.1/live", transport: TransportUDP, want: "*source.rtspSource"},
+		{url: "rtsp://127.0.0.1/live", transport: TransportHTTP, want: "*source.rtspSource"},
+		{url: "rtsps://127.0.0.1/live", transport: TransportTCP, want: "*source.rtspSource"},
+		{url: "rtsps://127.0.0.1/live", transport: TransportMulticast, wantErr: true},
+		{url: "rtsp://127.0.0.1/live", transport: "quic", wantErr: true},
+	}
+	for _, tt := range tests {
+		src, err := New("", tt.url, Options{Transport: tt.transport})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("New(%q, %v) expected error, got %T", tt.url, tt.transport, src)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("New(%q, %v) unexpected error: %v", tt.url, tt.transport, err)
+			continue
+		}
+		if got := fmt.Sprintf("%T", src); got != tt.want {
+			t.Errorf("New(%q, %v) = %v, want %v", tt.url, tt.transport, got, tt.want)
+		}
+	}
+}
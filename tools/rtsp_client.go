@@ -2,7 +2,6 @@ package tools
 
 import (
 	"io"
-	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -10,7 +9,8 @@ import (
 
 	"videoplayer/joy4/codec/h264parser"
 
-	"videoplayer/joy4/format/rtsp"
+	"videoplayer/rtsp"
+	"videoplayer/transport"
 )
 
 var (
@@ -23,14 +23,22 @@ func rtsp_client() {
 	// 	log.Fatal(err)
 	// }
 
-	src, err := rtsp.DialTimeout("rtsp://10.9.244.166:8554/fpach_1080p_osd.mp4", time.Second*10)
-
+	uri := "rtsp://10.9.244.166:8554/fpach_1080p_osd.mp4"
+	trans, err := transport.NewTCPTransport(uri)
 	if nil != err {
 		log.Debug("dial rtsp err:", err)
 		return
 	}
-
-	src.UseUDP = false
+	if err = trans.Connect(); nil != err {
+		log.Debug("dial rtsp err:", err)
+		return
+	}
+	src, err := rtsp.NewClient(uri, trans)
+	if nil != err {
+		log.Debug("dial rtsp err:", err)
+		trans.Disconnect()
+		return
+	}
 
 	defer func() {
 
@@ -0,0 +1,145 @@
+// streamdump 打印视频源逐帧的信息和 SEI，用于排查现场码流和从抓包编写回归测试。
+// 支持 source.New 能打开的所有地址，包括 .wsprec 录制文件和 pcap/pcapng 抓包：
+//
+//	streamdump -n 100 'file:///caps/cam.pcap?flow=udp:10.0.0.9:5000>&ssrc=0x1234'
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	"videoplayer/joy4/av"
+	"videoplayer/joy4/codec/h264parser"
+	"videoplayer/joy4/codec/h265parser"
+	"videoplayer/pb"
+	"videoplayer/source"
+)
+
+// seiUUIDSize 平台 SEI 的 user_data_unregistered 前 16 字节是 UUID，之后是 pb.PreviewInfo
+const seiUUIDSize = 16
+
+func main() {
+	wsurl := flag.String("ws", "", "WSP 代理地址，为空时直连")
+	max := flag.Int("n", 0, "最多打印的帧数，0 为不限")
+	speed := flag.Float64("speed", -1, "录制文件和抓包的回放倍速，小于 0 时不限速")
+	dump := flag.Int("hex", 0, "每帧打印的数据字节数")
+	verbose := flag.Bool("v", false, "打印调试日志")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] url\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	log.SetOutput(os.Stderr)
+	if *verbose {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.WarnLevel)
+	}
+
+	src, err := source.New(*wsurl, flag.Arg(0), source.Options{ReplaySpeed: *speed})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := src.Open(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	defer src.Close()
+	streams, err := src.Streams()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i, codec := range streams {
+		if codec == nil {
+			fmt.Printf("stream %d: no codec data\n", i)
+			continue
+		}
+		if video, ok := codec.(av.VideoCodecData); ok {
+			fmt.Printf("stream %d: %v %dx%d\n", i, codec.Type(), video.Width(), video.Height())
+		} else {
+			fmt.Printf("stream %d: %v\n", i, codec.Type())
+		}
+	}
+
+	for n := 0; *max == 0 || n < *max; n++ {
+		pkt, err := src.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		printPacket(pkt, streams)
+		if *dump > 0 {
+			size := *dump
+			if size > len(pkt.Data) {
+				size = len(pkt.Data)
+			}
+			fmt.Print(hex.Dump(pkt.Data[:size]))
+		}
+	}
+}
+
+func printPacket(pkt av.Packet, streams []av.CodecData) {
+	line := fmt.Sprintf("#%d time=%v size=%d", pkt.Idx, pkt.Time, len(pkt.Data))
+	if !pkt.WallClock.IsZero() {
+		line += " wallclock=" + pkt.WallClock.Format("15:04:05.000")
+	}
+	if pkt.IsKeyFrame {
+		line += " key"
+	}
+	var codec av.CodecType
+	if int(pkt.Idx) < len(streams) && streams[pkt.Idx] != nil {
+		codec = streams[pkt.Idx].Type()
+	}
+	if codec != av.H264 && codec != av.H265 {
+		fmt.Println(line)
+		return
+	}
+
+	var types []string
+	var seis []string
+	for _, nalu := range h264parser.SplitNALUs(pkt.Data, true, 4, codec, true) {
+		types = append(types, fmt.Sprint(nalu.Type))
+		switch {
+		case codec == av.H264 && nalu.Type == h264parser.NALU_SEI:
+			seis = append(seis, describeSEI(nalu.Rbsp))
+		case codec == av.H265 && (nalu.Type == h265parser.NALU_PREFIX_SEI_NUT || nalu.Type == h265parser.NALU_SUFFIX_SEI_NUT):
+			// HEVC 的 SEI 暂不解析，与播放器一致
+			seis = append(seis, fmt.Sprintf("sei hevc size=%d", len(nalu.Raw)))
+		}
+	}
+	fmt.Printf("%s nalu=%s\n", line, strings.Join(types, ","))
+	for _, sei := range seis {
+		fmt.Println("  " + sei)
+	}
+}
+
+// describeSEI 平台的 user_data_unregistered SEI 解出 pb.PreviewInfo
+func describeSEI(rbsp []byte) string {
+	sei, err := h264parser.ParseSEIMessageFromNALU(rbsp)
+	if err != nil {
+		return fmt.Sprintf("sei error: %v", err)
+	}
+	s := fmt.Sprintf("sei type=%d size=%d", sei.Type, sei.PayloadSize)
+	if sei.Type != h264parser.SEI_TYPE_USER_DATA_UNREGISTERED || len(sei.Payload) < seiUUIDSize {
+		return s
+	}
+	s += " uuid=" + hex.EncodeToString(sei.Payload[:seiUUIDSize])
+	info := &pb.PreviewInfo{}
+	if err := proto.Unmarshal(sei.Payload[seiUUIDSize:], info); err != nil {
+		return s + " (not a preview info)"
+	}
+	return fmt.Sprintf("%s objects=%d %v", s, len(info.Objects), info)
+}
@@ -0,0 +1,445 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRTSPPort  = "554"
+	defaultRTSPSPort = "322"
+	dialTimeout      = 10 * time.Second
+	responseTimeout  = 10 * time.Second
+	// stallTimeout is how long a full data queue may hold up a pending
+	// response before frames are dropped.
+	stallTimeout = 200 * time.Millisecond
+	// responsePrefix starts every RTSP response, a frame starting with 'R'
+	// without it is garbage to skip.
+	responsePrefix = "RTSP/1.0 "
+)
+
+// ErrNotConnected is returned by Send and ReadData before Connect or after
+// Disconnect.
+var ErrNotConnected = errors.New("transport not connected")
+
+// dialFunc opens the byte streams RTSP is carried on, requests are written to
+// w and responses and interleaved data are read from r. The dial is abandoned
+// when ctx is done.
+type dialFunc func(ctx context.Context) (r io.Reader, w io.Writer, c io.Closer, err error)
+
+// ConnTransport carries RTSP and interleaved RTP over a byte stream: plain TCP,
+// TLS (rtsps) or an RTSP-over-HTTP tunnel. A reader goroutine splits the
+// stream into responses, handed to Send, and data frames, handed to ReadData.
+type ConnTransport struct {
+	dial dialFunc
+
+	sendMu    sync.Mutex
+	mu        sync.Mutex
+	w         io.Writer
+	c         io.Closer
+	responses chan []byte
+	data      chan []byte
+	done      chan struct{}
+	err       error
+	// closing is closed by Disconnect, releasing a reader blocked on a full
+	// data queue. waiting is set while Send waits for a response and wake
+	// tells a blocked reader to look at it again.
+	closing chan struct{}
+	waiting bool
+	wake    chan struct{}
+	dropped int
+}
+
+func newConnTransport(dial dialFunc) *ConnTransport {
+	return &ConnTransport{dial: dial}
+}
+
+// hostPort returns host:port of an rtsp/rtsps url, adding the default port.
+func hostPort(rtspurl string) (*url.URL, string, error) {
+	u, err := url.Parse(rtspurl)
+	if err != nil {
+		return nil, "", err
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultRTSPPort
+		if u.Scheme == "rtsps" {
+			port = defaultRTSPSPort
+		}
+	}
+	return u, net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// NewTCPTransport carries RTSP on a plain TCP connection.
+func NewTCPTransport(rtspurl string) (*ConnTransport, error) {
+	_, addr, err := hostPort(rtspurl)
+	if err != nil {
+		return nil, err
+	}
+	return newConnTransport(func(ctx context.Context) (io.Reader, io.Writer, io.Closer, error) {
+		dialer := &net.Dialer{Timeout: dialTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return conn, conn, conn, nil
+	}), nil
+}
+
+// NewTLSTransport carries RTSP over TLS (rtsps://, default port 322). A nil
+// config verifies the server against the system roots.
+func NewTLSTransport(rtspurl string, config *tls.Config) (*ConnTransport, error) {
+	u, addr, err := hostPort(rtspurl)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = u.Hostname()
+	}
+	return newConnTransport(func(ctx context.Context) (io.Reader, io.Writer, io.Closer, error) {
+		dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: dialTimeout}, Config: config}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return conn, conn, conn, nil
+	}), nil
+}
+
+// Connect dials the connection and starts the reader.
+func (t *ConnTransport) Connect() error {
+	return t.ConnectContext(context.Background())
+}
+
+// ConnectContext is Connect abandoned when ctx is done.
+func (t *ConnTransport) ConnectContext(ctx context.Context) error {
+	r, w, c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.w, t.c = w, c
+	t.responses = make(chan []byte, 1)
+	t.data = make(chan []byte, 256)
+	t.done = make(chan struct{})
+	t.closing = make(chan struct{})
+	t.wake = make(chan struct{}, 1)
+	t.err = nil
+	responses, data, done := t.responses, t.data, t.done
+	q := queue{data: data, wake: t.wake, closing: t.closing}
+	t.mu.Unlock()
+
+	go t.readLoop(bufio.NewReader(r), responses, q, done)
+	return nil
+}
+
+// Disconnect closes the connection, pending Send and ReadData return.
+func (t *ConnTransport) Disconnect() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.c != nil {
+		t.c.Close()
+		close(t.closing)
+		t.c = nil
+		t.w = nil
+	}
+}
+
+func (t *ConnTransport) channels() (chan []byte, chan []byte, chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.responses, t.data, t.done
+}
+
+func (t *ConnTransport) readErr() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		return io.EOF
+	}
+	return t.err
+}
+
+// Send writes one RTSP request and waits for its response. Responses with
+// another CSeq, such as a late answer to a request that timed out, are
+// dropped.
+func (t *ConnTransport) Send(payload []byte) ([]byte, error) {
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+
+	t.mu.Lock()
+	w := t.w
+	t.mu.Unlock()
+	responses, _, done := t.channels()
+	if w == nil || responses == nil {
+		return nil, ErrNotConnected
+	}
+	for drained := false; !drained; {
+		select {
+		case res := <-responses:
+			log.Warnf("dropping stale rtsp response: %q", res)
+		default:
+			drained = true
+		}
+	}
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	defer t.stopWaiting()
+	t.startWaiting()
+
+	want := cseq(payload)
+	timer := time.NewTimer(responseTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case res := <-responses:
+			if got := cseq(res); want != "" && got != "" && got != want {
+				log.Warnf("dropping rtsp response CSeq %v, waiting for %v", got, want)
+				continue
+			}
+			return res, nil
+		case <-done:
+			return nil, t.readErr()
+		case <-timer.C:
+			return nil, fmt.Errorf("rtsp response timeout after %v", responseTimeout)
+		}
+	}
+}
+
+// startWaiting lets the reader drop frames from a stalled data queue, the
+// response may be queued behind them.
+func (t *ConnTransport) startWaiting() {
+	t.mu.Lock()
+	t.waiting = true
+	wake := t.wake
+	t.mu.Unlock()
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+func (t *ConnTransport) stopWaiting() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.waiting = false
+	if t.dropped > 0 {
+		log.Warnf("rtsp transport dropped %d data frames while waiting for a response", t.dropped)
+		t.dropped = 0
+	}
+}
+
+// cseq returns the CSeq header of an RTSP message, "" when it has none.
+func cseq(msg []byte) string {
+	header, _, _ := bytes.Cut(msg, []byte("\r\n\r\n"))
+	for _, line := range strings.Split(string(header), "\n") {
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(k), "CSeq") {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// ReadData returns the next interleaved frame, '$' header included.
+func (t *ConnTransport) ReadData() ([]byte, error) {
+	_, data, done := t.channels()
+	if data == nil {
+		return nil, ErrNotConnected
+	}
+	select {
+	case frame := <-data:
+		return frame, nil
+	case <-done:
+		// frames received before the connection closed are still delivered
+		select {
+		case frame := <-data:
+			return frame, nil
+		default:
+		}
+		return nil, t.readErr()
+	}
+}
+
+// queue is where one connection's reader hands data frames to ReadData.
+type queue struct {
+	data    chan []byte
+	wake    chan struct{}
+	closing chan struct{}
+}
+
+func (t *ConnTransport) readLoop(r *bufio.Reader, responses chan []byte, q queue, done chan struct{}) {
+	var err error
+	defer func() {
+		t.mu.Lock()
+		t.err = err
+		t.mu.Unlock()
+		close(done)
+	}()
+	for {
+		var first byte
+		if first, err = r.ReadByte(); err != nil {
+			return
+		}
+		switch first {
+		case '$':
+			var frame []byte
+			if frame, err = readFrame(r); err != nil {
+				return
+			}
+			if !t.deliver(frame, q) {
+				return
+			}
+		case 'R':
+			r.UnreadByte()
+			var peek []byte
+			if peek, err = r.Peek(len(responsePrefix)); err != nil {
+				return
+			}
+			if string(peek) != responsePrefix {
+				// not a response, skip the 'R' and resynchronize on the
+				// next '$' or response
+				r.ReadByte()
+				continue
+			}
+			var res []byte
+			if res, err = readResponse(r); err != nil {
+				return
+			}
+			queueResponse(responses, res)
+		default:
+			// not the start of a message, skip until resynchronized
+		}
+	}
+}
+
+// queueResponse hands a response to Send. An unread older response, stale
+// or unsolicited, is replaced so it cannot hide the one Send waits for.
+func queueResponse(responses chan []byte, res []byte) {
+	for {
+		select {
+		case responses <- res:
+			return
+		default:
+		}
+		select {
+		case old := <-responses:
+			log.Warnf("dropping unread rtsp response: %q", old)
+		default:
+		}
+	}
+}
+
+// deliver queues a data frame for ReadData. It blocks while the queue is
+// full so TCP pushes back on the server instead of losing RTP. Frames are
+// only dropped when the queue stays full for stallTimeout while Send waits
+// for a response behind them, e.g. TEARDOWN after the reader stopped. It
+// returns false after Disconnect.
+func (t *ConnTransport) deliver(frame []byte, q queue) bool {
+	for {
+		t.mu.Lock()
+		waiting, stalled := t.waiting, t.dropped > 0
+		t.mu.Unlock()
+		if waiting && stalled {
+			select {
+			case q.data <- frame:
+			default:
+				t.drop()
+			}
+			return true
+		}
+		var (
+			timer *time.Timer
+			stall <-chan time.Time
+		)
+		if waiting {
+			timer = time.NewTimer(stallTimeout)
+			stall = timer.C
+		}
+		queued, open := true, true
+		select {
+		case q.data <- frame:
+		case <-stall:
+			t.drop()
+		case <-q.wake:
+			queued = false
+		case <-q.closing:
+			open = false
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if !open || queued {
+			return open
+		}
+	}
+}
+
+func (t *ConnTransport) drop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dropped++
+}
+
+// readFrame reads an interleaved frame after its '$'.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	var header [3]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint16(header[1:]))
+	frame := make([]byte, 4+size)
+	frame[0] = '$'
+	copy(frame[1:], header[:])
+	if _, err := io.ReadFull(r, frame[4:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// readResponse reads an RTSP response with its body, keeping the bytes as
+// they were received.
+func readResponse(r *bufio.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	contentLength := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(line)
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if kv := strings.SplitN(line, ":", 2); len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "Content-Length") {
+			n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("bad Content-Length %q", kv[1])
+			}
+			contentLength = n
+		}
+	}
+	if _, err := io.CopyN(&buf, r, int64(contentLength)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
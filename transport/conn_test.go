@@ -0,0 +1,324 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveRTSP answers every request read from r with an interleaved frame
+// followed by a 200 response echoing the method in its body.
+func serveRTSP(r *bufio.Reader, w io.Writer) {
+	tr := textproto.NewReader(r)
+	for {
+		line, err := tr.ReadLine()
+		if err != nil {
+			return
+		}
+		header, err := tr.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		method := strings.Fields(line)[0]
+		frame := []byte{'$', 0, 0, byte(len(method))}
+		frame = append(frame, method...)
+		fmt.Fprintf(w, "%sRTSP/1.0 200 OK\r\nCSeq: %s\r\nContent-Length: %d\r\n\r\n%s",
+			frame, header.Get("CSeq"), len(method), method)
+	}
+}
+
+func checkExchange(t *testing.T, trans Conn) {
+	t.Helper()
+	for i, method := range []string{"OPTIONS", "DESCRIBE"} {
+		req := fmt.Sprintf("%s rtsp://127.0.0.1/live RTSP/1.0\r\nCSeq: %d\r\n\r\n", method, i+1)
+		res, err := trans.Send([]byte(req))
+		if err != nil {
+			t.Fatalf("Send %v: %v", method, err)
+		}
+		if !strings.HasPrefix(string(res), "RTSP/1.0 200 OK\r\n") || !strings.HasSuffix(string(res), "\r\n\r\n"+method) {
+			t.Errorf("response = %q", res)
+		}
+		if !strings.Contains(string(res), fmt.Sprintf("CSeq: %d\r\n", i+1)) {
+			t.Errorf("response CSeq mismatch: %q", res)
+		}
+		frame, err := trans.ReadData()
+		if err != nil {
+			t.Fatalf("ReadData: %v", err)
+		}
+		if string(frame[4:]) != method || frame[0] != '$' {
+			t.Errorf("frame = %q, want payload %q", frame, method)
+		}
+	}
+	trans.Disconnect()
+	if _, err := trans.Send([]byte("OPTIONS * RTSP/1.0\r\n\r\n")); err != ErrNotConnected {
+		t.Errorf("Send after Disconnect = %v, want ErrNotConnected", err)
+	}
+}
+
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rtsps test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestTLSTransport(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serveRTSP(bufio.NewReader(conn), conn)
+			}()
+		}
+	}()
+
+	uri := "rtsps://" + ln.Addr().String() + "/live"
+	untrusted, err := NewTLSTransport(uri, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := untrusted.Connect(); err == nil {
+		untrusted.Disconnect()
+		t.Fatal("Connect to self-signed server succeeded without its root")
+	}
+
+	trans, err := NewTLSTransport(uri, &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trans.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	checkExchange(t, trans)
+}
+
+// startTunnelServer is an RTSP-over-HTTP stand-in: it pairs the GET and POST
+// connections by session cookie, decodes requests from the POST body and
+// answers on the GET connection.
+func startTunnelServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		gets := make(map[string]net.Conn)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			req, err := http.ReadRequest(r)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			cookie := req.Header.Get("x-sessioncookie")
+			switch req.Method {
+			case "GET":
+				if req.Header.Get("Accept") != tunnelContentType || cookie == "" {
+					io.WriteString(conn, "HTTP/1.0 400 Bad Request\r\n\r\n")
+					conn.Close()
+					continue
+				}
+				io.WriteString(conn, "HTTP/1.0 200 OK\r\nContent-Type: "+tunnelContentType+"\r\n\r\n")
+				gets[cookie] = conn
+			case "POST":
+				get := gets[cookie]
+				if get == nil || req.Header.Get("Content-Type") != tunnelContentType {
+					conn.Close()
+					continue
+				}
+				pr, pw := io.Pipe()
+				go decodeTunnel(r, pw)
+				go func() {
+					defer conn.Close()
+					defer get.Close()
+					serveRTSP(bufio.NewReader(pr), get)
+				}()
+			}
+		}
+	}()
+	return "rtsp://" + ln.Addr().String() + "/live"
+}
+
+// decodeTunnel decodes the POST body. Each request is base64 encoded on its
+// own and may end in padding, so every 4 byte group is decoded separately.
+func decodeTunnel(r io.Reader, w *io.PipeWriter) {
+	var pending []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		pending = append(pending, buf[:n]...)
+		for len(pending) >= 4 {
+			dec, derr := base64.StdEncoding.DecodeString(string(pending[:4]))
+			if derr != nil {
+				w.CloseWithError(derr)
+				return
+			}
+			w.Write(dec)
+			pending = pending[4:]
+		}
+		if err != nil {
+			w.CloseWithError(err)
+			return
+		}
+	}
+}
+
+func TestHTTPTunnelTransport(t *testing.T) {
+	uri := startTunnelServer(t)
+	trans, err := NewHTTPTunnelTransport(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trans.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	checkExchange(t, trans)
+}
+
+func TestConnTransportResync(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	trans := newConnTransport(func(ctx context.Context) (io.Reader, io.Writer, io.Closer, error) {
+		return client, client, client, nil
+	})
+	if err := trans.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer trans.Disconnect()
+
+	go func() {
+		r := bufio.NewReader(server)
+		tr := textproto.NewReader(r)
+		if _, err := tr.ReadLine(); err != nil {
+			return
+		}
+		header, err := tr.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		// a late answer to an earlier request, a stray 'R' in the stream and
+		// a frame before the real response
+		fmt.Fprintf(server, "RTSP/1.0 200 OK\r\nCSeq: 1\r\n\r\n"+
+			"R\x80\x60$\x00\x00\x03abc"+
+			"RTSP/1.0 200 OK\r\nCSeq: %s\r\nContent-Length: 4\r\n\r\nPLAY", header.Get("CSeq"))
+	}()
+	res, err := trans.Send([]byte("PLAY rtsp://127.0.0.1/live RTSP/1.0\r\nCSeq: 2\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(res), "CSeq: 2\r\n") || !strings.HasSuffix(string(res), "PLAY") {
+		t.Errorf("response = %q", res)
+	}
+	frame, err := trans.ReadData()
+	if err != nil || string(frame) != "$\x00\x00\x03abc" {
+		t.Errorf("ReadData = %q, %v", frame, err)
+	}
+}
+
+func TestConnTransportBackpressure(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	trans := newConnTransport(func(ctx context.Context) (io.Reader, io.Writer, io.Closer, error) {
+		return client, client, client, nil
+	})
+	if err := trans.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer trans.Disconnect()
+
+	frame := func(i int) []byte {
+		return []byte{'$', 0, 0, 2, byte(i >> 8), byte(i)}
+	}
+	// more frames than the queue holds, nobody reads until all are written
+	const n = 600
+	go func() {
+		for i := 0; i < n; i++ {
+			if _, err := server.Write(frame(i)); err != nil {
+				return
+			}
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < n; i++ {
+		got, err := trans.ReadData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, frame(i)) {
+			t.Fatalf("frame %d = %x", i, got)
+		}
+	}
+
+	// with the queue full and no reader, a request still gets its response
+	go func() {
+		tr := textproto.NewReader(bufio.NewReader(server))
+		if _, err := tr.ReadLine(); err != nil {
+			return
+		}
+		if _, err := tr.ReadMIMEHeader(); err != nil {
+			return
+		}
+		for i := 0; i < n; i++ {
+			server.Write(frame(i))
+		}
+		fmt.Fprintf(server, "RTSP/1.0 200 OK\r\nCSeq: 3\r\n\r\n")
+	}()
+	res, err := trans.Send([]byte("TEARDOWN rtsp://127.0.0.1/live RTSP/1.0\r\nCSeq: 3\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(res), "CSeq: 3\r\n") {
+		t.Errorf("response = %q", res)
+	}
+}
@@ -1,6 +1,51 @@
 package transport
 
+import (
+	"context"
+	"io"
+)
+
 type Transporter interface {
 	Send(payload []byte) ([]byte, error)
 	ReadData() ([]byte, error)
 }
+
+// Conn is a Transporter with a connection lifecycle: the WSP proxy, TCP, TLS
+// and the HTTP tunnel. rtsp.Client disconnects it on Close and on redirect.
+type Conn interface {
+	Transporter
+	Connect() error
+	Disconnect()
+}
+
+// Connect connects conn. Conns with a ConnectContext method abandon the
+// connect when ctx is done, the others connect regardless of ctx.
+func Connect(ctx context.Context, conn Conn) error {
+	if c, ok := conn.(interface{ ConnectContext(context.Context) error }); ok {
+		return c.ConnectContext(ctx)
+	}
+	return conn.Connect()
+}
+
+// contextErr returns the error of ctx when it is done, err otherwise, so an
+// exchange broken by closeOnDone reports why.
+func contextErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// closeOnDone closes c when ctx is done before stop is called, unblocking
+// reads and writes that take no context.
+func closeOnDone(ctx context.Context, c io.Closer) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
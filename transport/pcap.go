@@ -0,0 +1,692 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"videoplayer/util/pcap"
+)
+
+const (
+	// maxPendingSegments bytes of out-of-order TCP data waiting for a segment
+	// the capture missed, beyond that the gap is skipped
+	maxPendingSegments = 1 << 20
+	// maxMessageHead caps the header of an RTSP message found in a TCP stream
+	maxMessageHead = 64 * 1024
+)
+
+// ErrNoFlow is returned by OpenPcap when no RTP flow of the capture matches
+// the filter.
+var ErrNoFlow = errors.New("no matching rtp flow in capture")
+
+// PcapFilter selects the RTP flow PcapTransport plays. Zero fields match any
+// flow; of the matching flows the one with the most RTP packets is played.
+type PcapFilter struct {
+	// Proto is "udp" for RTP over UDP or "tcp" for RTP interleaved in an RTSP
+	// connection.
+	Proto string
+	// Src and Dst are the sender and the receiver of the RTP packets, a zero
+	// port matches any port of the address.
+	Src, Dst netip.AddrPort
+	// SSRC picks one RTP stream of a flow carrying several.
+	SSRC uint32
+}
+
+// ParsePcapFlow parses a flow written "[udp:|tcp:]SRC>DST", as tcpdump prints
+// it. Either side may be empty and the ports may be left out, so
+// "udp:10.0.0.9:5000>" and "10.0.0.9>10.0.0.2" are valid. IPv6 addresses
+// with a port are bracketed.
+func ParsePcapFlow(s string) (PcapFilter, error) {
+	var f PcapFilter
+	for _, proto := range []string{pcap.ProtoUDP, pcap.ProtoTCP} {
+		if strings.HasPrefix(s, proto+":") {
+			f.Proto, s = proto, s[len(proto)+1:]
+		}
+	}
+	src, dst, ok := strings.Cut(s, ">")
+	if !ok {
+		return f, fmt.Errorf("pcap flow %q: want SRC>DST", s)
+	}
+	var err error
+	if f.Src, err = parseFlowAddr(src); err != nil {
+		return f, err
+	}
+	if f.Dst, err = parseFlowAddr(dst); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+func parseFlowAddr(s string) (netip.AddrPort, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return netip.AddrPort{}, nil
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap, nil
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("pcap flow address %q: %w", s, err)
+	}
+	return netip.AddrPortFrom(addr, 0), nil
+}
+
+func matchAddr(want, got netip.AddrPort) bool {
+	return !want.IsValid() || want.Addr() == got.Addr() && (want.Port() == 0 || want.Port() == got.Port())
+}
+
+func (f PcapFilter) match(k flowKey) bool {
+	return (f.Proto == "" || f.Proto == k.proto) && matchAddr(f.Src, k.src) && matchAddr(f.Dst, k.dst)
+}
+
+// flowKey is one direction of RTP: a UDP port pair or an interleaved channel
+// of an RTSP connection, src being the server.
+type flowKey struct {
+	proto    string
+	src, dst netip.AddrPort
+	channel  int
+}
+
+func (k flowKey) String() string {
+	if k.proto == pcap.ProtoTCP {
+		return fmt.Sprintf("tcp:%v>%v channel %d", k.src, k.dst, k.channel)
+	}
+	return fmt.Sprintf("udp:%v>%v", k.src, k.dst)
+}
+
+// rtpHeader returns the payload type and SSRC of an RTP packet, false for
+// RTCP and anything else.
+func rtpHeader(b []byte) (pt int, ssrc uint32, ok bool) {
+	if len(b) < 12 || b[0]>>6 != 2 {
+		return 0, 0, false
+	}
+	pt = int(b[1] & 0x7f)
+	// RTCP packet types 200-204 would read as payload types 72-76
+	if pt >= 72 && pt <= 76 {
+		return 0, 0, false
+	}
+	return pt, binary.BigEndian.Uint32(b[8:12]), true
+}
+
+func isRTCP(b []byte) bool {
+	return len(b) >= 8 && b[0]>>6 == 2 && b[1] >= 200 && b[1] <= 204
+}
+
+// tcpStream reassembles one direction of a captured TCP connection and splits
+// it into interleaved frames and RTSP messages. Segments the capture missed
+// are skipped once too much data is waiting behind them.
+type tcpStream struct {
+	next    uint32
+	started bool
+	pending map[uint32][]byte
+	waiting int
+	buf     []byte
+}
+
+// feed adds a segment, calling frame and message for what it completes. The
+// slices are only valid during the call.
+func (s *tcpStream) feed(p pcap.Packet, frame func(channel int, payload []byte), message func(msg []byte)) {
+	if p.Flags&pcap.FlagSYN != 0 {
+		s.next, s.started = p.Seq+1, true
+		return
+	}
+	if len(p.Payload) == 0 {
+		return
+	}
+	if !s.started {
+		// the capture started after the handshake
+		s.next, s.started = p.Seq, true
+	}
+	payload := p.Payload
+	if d := int32(p.Seq - s.next); d > 0 {
+		if s.pending == nil {
+			s.pending = make(map[uint32][]byte)
+		}
+		if _, ok := s.pending[p.Seq]; !ok {
+			s.pending[p.Seq] = append([]byte(nil), payload...)
+			s.waiting += len(payload)
+		}
+		if s.waiting <= maxPendingSegments {
+			return
+		}
+		// give up on the missing segment, resync on the earliest one waiting
+		s.next = s.earliest()
+		s.buf = s.buf[:0]
+		payload = nil
+	} else if d < 0 {
+		if len(payload) <= int(-d) {
+			return
+		}
+		payload = payload[-d:]
+	}
+	s.buf = append(s.buf, payload...)
+	s.next += uint32(len(payload))
+	s.drain()
+	s.split(frame, message)
+}
+
+func (s *tcpStream) earliest() uint32 {
+	first := true
+	var seq uint32
+	for k := range s.pending {
+		if first || int32(k-seq) < 0 {
+			seq, first = k, false
+		}
+	}
+	return seq
+}
+
+// drain appends the waiting segments that became contiguous.
+func (s *tcpStream) drain() {
+	for again := true; again; {
+		again = false
+		for seq, b := range s.pending {
+			d := int32(seq - s.next)
+			if d > 0 {
+				continue
+			}
+			delete(s.pending, seq)
+			s.waiting -= len(b)
+			if len(b) > int(-d) {
+				s.buf = append(s.buf, b[-d:]...)
+				s.next += uint32(len(b) + int(d))
+			}
+			again = true
+		}
+	}
+}
+
+func (s *tcpStream) split(frame func(channel int, payload []byte), message func(msg []byte)) {
+	b := s.buf
+	for len(b) > 0 {
+		if b[0] == '$' {
+			if len(b) < 4 {
+				break
+			}
+			size := int(binary.BigEndian.Uint16(b[2:4]))
+			if len(b) < 4+size {
+				break
+			}
+			frame(int(b[1]), b[4:4+size])
+			b = b[4+size:]
+			continue
+		}
+		start, more := messageStart(b)
+		if more {
+			break
+		}
+		if !start {
+			b = b[resync(b):]
+			continue
+		}
+		end := bytes.Index(b, []byte("\r\n\r\n"))
+		if end < 0 {
+			if len(b) > maxMessageHead {
+				b = b[resync(b):]
+				continue
+			}
+			break
+		}
+		end += 4
+		size, _ := strconv.Atoi(rtspHeader(b[:end], "Content-Length"))
+		if len(b) < end+size {
+			break
+		}
+		message(b[:end+size])
+		b = b[end+size:]
+	}
+	s.buf = append(s.buf[:0], b...)
+}
+
+// messageStart tells whether b starts with an RTSP request or response line,
+// more when b is too short to tell.
+func messageStart(b []byte) (start, more bool) {
+	n := len(b)
+	if n > 20 {
+		n = 20
+	}
+	for i, c := range b[:n] {
+		switch {
+		case c == ' ':
+			token := string(b[:i])
+			return token == "RTSP/1.0" || i >= 3 && strings.Trim(token, "ABCDEFGHIJKLMNOPQRSTUVWXYZ_") == "", false
+		case c >= 'A' && c <= 'Z', c == '_', c == '/', c == '.', c >= '0' && c <= '9':
+		default:
+			return false, false
+		}
+	}
+	return false, n < 20
+}
+
+// resync skips to the next possible frame or message, keeping a tail that
+// may be the start of one.
+func resync(b []byte) int {
+	for i := 1; i < len(b); i++ {
+		if b[i] == '$' || bytes.HasPrefix(b[i:], []byte("RTSP/")) || len(b)-i < 5 {
+			return i
+		}
+	}
+	return len(b)
+}
+
+// rtspHeader returns the value of a header of an RTSP message head.
+func rtspHeader(head []byte, name string) string {
+	for _, line := range strings.Split(string(head), "\r\n")[1:] {
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(k), name) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// describe is a captured DESCRIBE response.
+type describe struct {
+	at             time.Time
+	server, client netip.AddrPort
+	base           string
+	sdp            []byte
+}
+
+// flowStat counts the RTP packets of a flow, per SSRC.
+type flowStat struct {
+	key     flowKey
+	first   time.Time
+	packets map[uint32]int
+	pt      map[uint32]int
+}
+
+func (st *flowStat) count(ssrc uint32) int {
+	if ssrc != 0 {
+		return st.packets[ssrc]
+	}
+	n := 0
+	for _, c := range st.packets {
+		n += c
+	}
+	return n
+}
+
+// PcapTransport is a Transporter playing one RTP flow out of a pcap or
+// pcapng capture to rtsp.Client, as if the flow was interleaved in an RTSP
+// session: requests are answered with the flow's SDP, reduced to its media,
+// and ReadData returns its RTP and RTCP packets as interleaved frames on
+// channels 0 and 1, paced by their capture times divided by Speed. The SDP
+// is given or taken from a DESCRIBE response in the capture.
+type PcapTransport struct {
+	// Speed scales the captured timing, zero or less plays as fast as the
+	// packets are read.
+	Speed float64
+
+	path string
+	uri  string
+	flow flowKey
+	pt   int
+	ssrc uint32
+	sdp  []byte
+
+	mu     sync.Mutex
+	f      *os.File
+	r      *pcap.Reader
+	stream *tcpStream
+	queue  [][]byte
+	at     []time.Time
+	pacer  pacer
+	closed chan struct{}
+}
+
+// OpenPcap scans the capture at path for RTP flows and picks the one filter
+// selects. sdp describes the flow, when nil the SDP of the DESCRIBE captured
+// on the flow's connection, or last before the flow started, is used.
+func OpenPcap(path string, filter PcapFilter, sdp []byte) (*PcapTransport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := pcap.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	flows := make(map[flowKey]*flowStat)
+	var describes []describe
+	streams := make(map[[2]netip.AddrPort]*tcpStream)
+	addRTP := func(key flowKey, at time.Time, b []byte) {
+		pt, ssrc, ok := rtpHeader(b)
+		if !ok {
+			return
+		}
+		st := flows[key]
+		if st == nil {
+			st = &flowStat{key: key, first: at, packets: make(map[uint32]int), pt: make(map[uint32]int)}
+			flows[key] = st
+		}
+		if _, ok := st.pt[ssrc]; !ok {
+			st.pt[ssrc] = pt
+		}
+		st.packets[ssrc]++
+	}
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		switch p.Proto {
+		case pcap.ProtoUDP:
+			addRTP(flowKey{proto: pcap.ProtoUDP, src: p.Src, dst: p.Dst}, p.Time, p.Payload)
+		case pcap.ProtoTCP:
+			dir := [2]netip.AddrPort{p.Src, p.Dst}
+			s := streams[dir]
+			if s == nil {
+				s = &tcpStream{}
+				streams[dir] = s
+			}
+			s.feed(p, func(channel int, payload []byte) {
+				addRTP(flowKey{proto: pcap.ProtoTCP, src: p.Src, dst: p.Dst, channel: channel}, p.Time, payload)
+			}, func(msg []byte) {
+				if d, ok := parseDescribe(msg); ok {
+					d.at, d.server, d.client = p.Time, p.Src, p.Dst
+					describes = append(describes, d)
+				}
+			})
+		}
+	}
+
+	var best *flowStat
+	for _, st := range flows {
+		if !filter.match(st.key) || st.count(filter.SSRC) == 0 {
+			continue
+		}
+		if best == nil || st.count(filter.SSRC) > best.count(filter.SSRC) ||
+			st.count(filter.SSRC) == best.count(filter.SSRC) && st.first.Before(best.first) {
+			best = st
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%v: %w, flows: %v", path, ErrNoFlow, listFlows(flows))
+	}
+
+	// without an SSRC the whole flow is played, a camera restart changes it
+	t := &PcapTransport{Speed: 1, path: path, flow: best.key, ssrc: filter.SSRC}
+	if filter.SSRC != 0 {
+		t.pt = best.pt[filter.SSRC]
+	} else {
+		most := 0
+		for ssrc, n := range best.packets {
+			if n > most {
+				most, t.pt = n, best.pt[ssrc]
+			}
+		}
+	}
+
+	t.uri = fmt.Sprintf("rtsp://%v/", best.key.src)
+	if sdp == nil {
+		d, ok := pickDescribe(describes, best)
+		if !ok {
+			return nil, fmt.Errorf("%v: no DESCRIBE captured for %v, an SDP is needed", path, best.key)
+		}
+		sdp = d.sdp
+		if d.base != "" {
+			t.uri = d.base
+		}
+	}
+	if t.sdp, err = mediaSDP(sdp, t.pt); err != nil {
+		return nil, fmt.Errorf("%v: %v: %w", path, best.key, err)
+	}
+	return t, nil
+}
+
+func listFlows(flows map[flowKey]*flowStat) string {
+	var list []*flowStat
+	for _, st := range flows {
+		list = append(list, st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].count(0) > list[j].count(0) })
+	var names []string
+	for i, st := range list {
+		if i == 5 {
+			names = append(names, "...")
+			break
+		}
+		names = append(names, fmt.Sprintf("%v (%d packets)", st.key, st.count(0)))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// parseDescribe returns the SDP of a successful DESCRIBE response.
+func parseDescribe(msg []byte) (describe, bool) {
+	end := bytes.Index(msg, []byte("\r\n\r\n"))
+	head := msg[:end+4]
+	if !bytes.HasPrefix(head, []byte("RTSP/1.0 200")) ||
+		!strings.HasPrefix(strings.ToLower(rtspHeader(head, "Content-Type")), "application/sdp") {
+		return describe{}, false
+	}
+	return describe{base: rtspHeader(head, "Content-Base"), sdp: append([]byte(nil), msg[end+4:]...)}, true
+}
+
+// pickDescribe prefers the DESCRIBE of the flow's own connection, then those
+// of the flow's server, the last one before the flow started.
+func pickDescribe(describes []describe, flow *flowStat) (describe, bool) {
+	var candidates []describe
+	for _, d := range describes {
+		if flow.key.proto == pcap.ProtoTCP && d.server == flow.key.src && d.client == flow.key.dst {
+			candidates = append(candidates, d)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, d := range describes {
+			if d.server.Addr() == flow.key.src.Addr() {
+				candidates = append(candidates, d)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = describes
+	}
+	if len(candidates) == 0 {
+		return describe{}, false
+	}
+	pick := candidates[0]
+	for _, d := range candidates[1:] {
+		if !d.at.After(flow.first) {
+			pick = d
+		}
+	}
+	return pick, true
+}
+
+// mediaSDP reduces sdp to the session lines and the media carrying payload
+// type pt, moved first in the media's format list. Ranges are dropped, a
+// capture can't be seeked.
+func mediaSDP(sdp []byte, pt int) ([]byte, error) {
+	var lines []string
+	session, found, in := true, false, false
+	for _, line := range strings.Split(strings.ReplaceAll(string(sdp), "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "a=range:") {
+			continue
+		}
+		if strings.HasPrefix(line, "m=") {
+			session, in = false, false
+			fields := strings.Fields(line)
+			for i := 3; i < len(fields) && !found; i++ {
+				if fields[i] == strconv.Itoa(pt) {
+					in, found = true, true
+					lines = append(lines, strings.Join(append(fields[:3], fields[i]), " "))
+				}
+			}
+			continue
+		}
+		if session || in {
+			lines = append(lines, line)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no media with payload type %d in sdp", pt)
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n"), nil
+}
+
+// URL is the rtsp url of the captured session, or one made up from the
+// flow's server address.
+func (t *PcapTransport) URL() string {
+	return t.uri
+}
+
+// Flow describes the flow played, "udp:10.0.0.9:5000>10.0.0.2:6000".
+func (t *PcapTransport) Flow() string {
+	return t.flow.String()
+}
+
+// Connect rewinds the capture.
+func (t *PcapTransport) Connect() error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	r, err := pcap.NewReader(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("%v: %w", t.path, err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.f != nil {
+		t.f.Close()
+	}
+	t.f, t.r = f, r
+	t.stream = &tcpStream{}
+	t.queue, t.at = nil, nil
+	t.pacer = pacer{}
+	t.closed = make(chan struct{})
+	return nil
+}
+
+func (t *PcapTransport) Disconnect() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.f != nil {
+		t.f.Close()
+		t.f, t.r = nil, nil
+		close(t.closed)
+	}
+}
+
+// Send answers DESCRIBE with the flow's SDP and SETUP with interleaved
+// channels 0-1, anything else with a bare 200.
+func (t *PcapTransport) Send(payload []byte) ([]byte, error) {
+	t.mu.Lock()
+	connected := t.r != nil
+	t.mu.Unlock()
+	if !connected {
+		return nil, ErrNotConnected
+	}
+	method, _, _ := strings.Cut(string(payload), " ")
+	switch method {
+	case "OPTIONS":
+		return rtspReply(payload, []string{"Public: OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER"}, nil), nil
+	case "DESCRIBE":
+		return rtspReply(payload, []string{"Content-Base: " + t.uri, "Content-Type: application/sdp"}, t.sdp), nil
+	case "SETUP":
+		return rtspReply(payload, []string{"Session: pcap", "Transport: RTP/AVP/TCP;unicast;interleaved=0-1"}, nil), nil
+	}
+	return rtspReply(payload, nil, nil), nil
+}
+
+// ReadData returns the flow's next packet once it is due, io.EOF at the end
+// of the capture.
+func (t *PcapTransport) ReadData() ([]byte, error) {
+	t.mu.Lock()
+	r, closed := t.r, t.closed
+	t.mu.Unlock()
+	if r == nil {
+		return nil, ErrNotConnected
+	}
+	// ReadData is not called concurrently, the reader is only shared with
+	// Disconnect which closes the file under it
+	for len(t.queue) == 0 {
+		p, err := r.Next()
+		if err != nil {
+			select {
+			case <-closed:
+				return nil, ErrNotConnected
+			default:
+			}
+			return nil, err
+		}
+		t.match(p)
+	}
+	frame, at := t.queue[0], t.at[0]
+	t.queue, t.at = t.queue[1:], t.at[1:]
+
+	t.mu.Lock()
+	due := t.pacer.due(time.Duration(at.UnixNano()), t.Speed)
+	t.mu.Unlock()
+	if !sleepUntil(due, closed) {
+		return nil, ErrNotConnected
+	}
+	return frame, nil
+}
+
+// match queues the flow's RTP and RTCP packets found in p.
+func (t *PcapTransport) match(p pcap.Packet) {
+	k := t.flow
+	if p.Proto != k.proto {
+		return
+	}
+	if k.proto == pcap.ProtoUDP {
+		switch {
+		case p.Src == k.src && p.Dst == k.dst:
+			t.enqueue(0, p.Payload, p.Time)
+		case p.Src.Addr() == k.src.Addr() && p.Dst.Addr() == k.dst.Addr() &&
+			p.Src.Port() == k.src.Port()+1 && p.Dst.Port() == k.dst.Port()+1:
+			t.enqueue(1, p.Payload, p.Time)
+		}
+		return
+	}
+	if p.Src != k.src || p.Dst != k.dst {
+		return
+	}
+	t.stream.feed(p, func(channel int, payload []byte) {
+		switch channel {
+		case k.channel:
+			t.enqueue(0, payload, p.Time)
+		case k.channel + 1:
+			t.enqueue(1, payload, p.Time)
+		}
+	}, func([]byte) {})
+}
+
+func (t *PcapTransport) enqueue(channel int, payload []byte, at time.Time) {
+	if channel == 0 {
+		pt, ssrc, ok := rtpHeader(payload)
+		if !ok || pt != t.pt || t.ssrc != 0 && ssrc != t.ssrc {
+			return
+		}
+	} else if !isRTCP(payload) {
+		return
+	}
+	frame := make([]byte, 4+len(payload))
+	frame[0], frame[1] = '$', byte(channel)
+	binary.BigEndian.PutUint16(frame[2:4], uint16(len(payload)))
+	copy(frame[4:], payload)
+	t.queue = append(t.queue, frame)
+	t.at = append(t.at, at)
+}
@@ -0,0 +1,168 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"videoplayer/util/pcap"
+)
+
+const captureSDP = "v=0\r\no=- 0 0 IN IP4 10.0.0.9\r\ns=cam\r\na=range:npt=0-\r\n" +
+	"m=audio 0 RTP/AVP 0\r\na=control:track2\r\n" +
+	"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=control:track1\r\n"
+
+func rtpPacket(pt byte, seq uint16, ssrc uint32) []byte {
+	b := make([]byte, 16)
+	b[0], b[1] = 0x80, pt
+	binary.BigEndian.PutUint16(b[2:4], seq)
+	binary.BigEndian.PutUint32(b[8:12], ssrc)
+	return b
+}
+
+func interleaved(channel byte, payload []byte) []byte {
+	return append([]byte{'$', channel, 0, byte(len(payload))}, payload...)
+}
+
+// writeCapture writes an RTSP connection carrying the DESCRIBE and the audio
+// and video interleaved, its segments out of order and retransmitted, and a
+// UDP video flow with its RTCP.
+func writeCapture(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "cam.pcap")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := pcap.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := netip.MustParseAddrPort("10.0.0.9:554"), netip.MustParseAddrPort("10.0.0.2:50000")
+	at := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	write := func(p pcap.Packet) {
+		at = at.Add(10 * time.Millisecond)
+		p.Time = at
+		if err := w.WritePacket(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stream := []byte("RTSP/1.0 200 OK\r\nCSeq: 2\r\nContent-Base: rtsp://10.0.0.9/live/\r\n" +
+		"Content-Type: application/sdp\r\nContent-Length: " + strconv.Itoa(len(captureSDP)) + "\r\n\r\n" + captureSDP)
+	for i := 0; i < 3; i++ {
+		stream = append(stream, interleaved(0, rtpPacket(96, uint16(i), 0x1111))...)
+		stream = append(stream, interleaved(2, rtpPacket(0, uint16(i), 0x2222))...)
+	}
+	write(pcap.Packet{Proto: pcap.ProtoTCP, Src: server, Dst: client, Seq: 99, Flags: pcap.FlagSYN | pcap.FlagACK})
+	segments := [][2]int{{0, 100}, {150, len(stream)}, {100, 150}, {120, 150}}
+	for _, s := range segments {
+		write(pcap.Packet{Proto: pcap.ProtoTCP, Src: server, Dst: client, Seq: 100 + uint32(s[0]), Flags: pcap.FlagACK, Payload: stream[s[0]:s[1]]})
+	}
+
+	rtpSrc, rtpDst := netip.MustParseAddrPort("10.0.0.9:6000"), netip.MustParseAddrPort("10.0.0.2:5000")
+	rtcpSrc, rtcpDst := netip.MustParseAddrPort("10.0.0.9:6001"), netip.MustParseAddrPort("10.0.0.2:5001")
+	for i := 0; i < 4; i++ {
+		write(pcap.Packet{Proto: pcap.ProtoUDP, Src: rtpSrc, Dst: rtpDst, Payload: rtpPacket(96, uint16(i), 0x3333)})
+	}
+	write(pcap.Packet{Proto: pcap.ProtoUDP, Src: rtcpSrc, Dst: rtcpDst, Payload: []byte{0x80, 200, 0, 1, 0, 0, 0x33, 0x33}})
+	return path
+}
+
+func readAll(t *testing.T, p *PcapTransport) []string {
+	var frames []string
+	for {
+		frame, err := p.ReadData()
+		if err == io.EOF {
+			return frames
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		kind := "rtp"
+		if frame[1] == 1 {
+			kind = "rtcp"
+		}
+		frames = append(frames, kind)
+	}
+}
+
+func TestPcapTransport(t *testing.T) {
+	path := writeCapture(t)
+
+	udp, err := OpenPcap(path, PcapFilter{Proto: pcap.ProtoUDP}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp.Speed = 0
+	if udp.Flow() != "udp:10.0.0.9:6000>10.0.0.2:5000" || udp.URL() != "rtsp://10.0.0.9/live/" {
+		t.Errorf("flow %v url %v", udp.Flow(), udp.URL())
+	}
+	if err := udp.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	res, err := udp.Send([]byte("DESCRIBE rtsp://10.0.0.9/live/ RTSP/1.0\r\nCSeq: 3\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(res); !strings.Contains(s, "CSeq: 3\r\n") || !strings.Contains(s, "m=video 0 RTP/AVP 96") ||
+		strings.Contains(s, "m=audio") || strings.Contains(s, "a=range") || strings.Contains(s, "track2") {
+		t.Errorf("DESCRIBE response:\n%s", res)
+	}
+	if got := strings.Join(readAll(t, udp), ","); got != "rtp,rtp,rtp,rtp,rtcp" {
+		t.Errorf("udp frames = %v", got)
+	}
+	udp.Disconnect()
+
+	// audio is on channel 2, the retransmitted and reordered segments count once
+	audio, err := OpenPcap(path, PcapFilter{Proto: pcap.ProtoTCP, SSRC: 0x2222}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio.Speed = 0
+	if audio.Flow() != "tcp:10.0.0.9:554>10.0.0.2:50000 channel 2" {
+		t.Errorf("flow %v", audio.Flow())
+	}
+	if string(audio.sdp) != "v=0\r\no=- 0 0 IN IP4 10.0.0.9\r\ns=cam\r\nm=audio 0 RTP/AVP 0\r\na=control:track2\r\n" {
+		t.Errorf("audio sdp = %q", audio.sdp)
+	}
+	if err := audio.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(readAll(t, audio)); got != 3 {
+		t.Errorf("%d audio frames, want 3", got)
+	}
+	audio.Disconnect()
+
+	filter, _ := ParsePcapFlow("udp:10.0.0.2>")
+	if _, err := OpenPcap(path, filter, nil); !errors.Is(err, ErrNoFlow) || !strings.Contains(err.Error(), "udp:10.0.0.9:6000>10.0.0.2:5000 (4 packets)") {
+		t.Errorf("OpenPcap with unmatched filter = %v", err)
+	}
+}
+
+func TestParsePcapFlow(t *testing.T) {
+	tests := []struct {
+		flow string
+		want PcapFilter
+		ok   bool
+	}{
+		{"udp:10.0.0.9:5000>10.0.0.2:6000", PcapFilter{Proto: "udp", Src: netip.MustParseAddrPort("10.0.0.9:5000"), Dst: netip.MustParseAddrPort("10.0.0.2:6000")}, true},
+		{"10.0.0.9>", PcapFilter{Src: netip.MustParseAddrPort("10.0.0.9:0")}, true},
+		{"tcp:>[fd00::2]:50000", PcapFilter{Proto: "tcp", Dst: netip.MustParseAddrPort("[fd00::2]:50000")}, true},
+		{"10.0.0.9", PcapFilter{}, false},
+		{"udp:cam>nvr", PcapFilter{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParsePcapFlow(tt.flow)
+		if (err == nil) != tt.ok || tt.ok && got != tt.want {
+			t.Errorf("ParsePcapFlow(%q) = %+v, %v", tt.flow, got, err)
+		}
+	}
+}
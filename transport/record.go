@@ -0,0 +1,411 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A recording starts with recordMagic followed by records of
+//
+//	kind (1 byte) | time since the recording started in ns (int64) |
+//	payload length (uint32) | payload
+//
+// all big endian. A request record is always followed by its response. A
+// meta record carries "Key: value" lines about the recording, readers skip
+// kinds they do not know.
+const recordMagic = "WSPREC\x00\x01"
+
+const (
+	recordRequest  byte = 1
+	recordResponse byte = 2
+	recordData     byte = 3
+	recordMeta     byte = 4
+)
+
+// metaCaptureStart is the meta key of the sender's wall clock time at the
+// start of the recording.
+const metaCaptureStart = "Capture-Start"
+
+// ErrBadRecording is returned for files that are not WSP recordings.
+var ErrBadRecording = errors.New("not a wsp recording")
+
+// Recorder is a Transporter that writes every RTSP request and response and
+// every interleaved frame passing through it to a file, timestamped, so the
+// session can be replayed with Replayer. Only what goes through the
+// Transporter is captured: TCP interleaved and WSP sessions, not UDP.
+type Recorder struct {
+	trans Transporter
+
+	mu     sync.Mutex
+	w      io.Writer
+	start  time.Time
+	err    error
+	closed bool
+}
+
+// NewRecorder records trans to w, w is closed with the Recorder when it is an
+// io.Closer.
+func NewRecorder(trans Transporter, w io.Writer) *Recorder {
+	r := &Recorder{trans: trans, w: w, start: time.Now()}
+	if _, err := io.WriteString(w, recordMagic); err != nil {
+		r.err = err
+	}
+	return r
+}
+
+func (r *Recorder) write(kind byte, at time.Time, payload []byte) {
+	if r.err != nil || r.closed {
+		return
+	}
+	var head [13]byte
+	head[0] = kind
+	binary.BigEndian.PutUint64(head[1:9], uint64(at.Sub(r.start)))
+	binary.BigEndian.PutUint32(head[9:13], uint32(len(payload)))
+	if _, r.err = r.w.Write(append(head[:], payload...)); r.err != nil {
+		// the session goes on unrecorded
+		log.Errorf("wsp recorder: %v", r.err)
+	}
+}
+
+func (r *Recorder) Send(payload []byte) ([]byte, error) {
+	at := time.Now()
+	res, err := r.trans.Send(payload)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.write(recordRequest, at, payload)
+	r.write(recordResponse, time.Now(), res)
+	r.mu.Unlock()
+	return res, nil
+}
+
+func (r *Recorder) ReadData() ([]byte, error) {
+	frame, err := r.trans.ReadData()
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.write(recordData, time.Now(), frame)
+	r.mu.Unlock()
+	return frame, nil
+}
+
+// SyncCapture records that a frame arriving now was captured at wallClock by
+// the sender, usually the RTCP sender report time, and returns the sender's
+// time at the start of the recording. The result is written to the
+// recording, see Replayer.CaptureStart.
+func (r *Recorder) SyncCapture(wallClock time.Time) time.Time {
+	now := time.Now()
+	start := r.start.Add(wallClock.Sub(now))
+	r.mu.Lock()
+	r.write(recordMeta, now, []byte(metaCaptureStart+": "+start.Format(time.RFC3339Nano)+"\r\n"))
+	r.mu.Unlock()
+	return start
+}
+
+// Connect connects the recorded transport if it is a Conn.
+func (r *Recorder) Connect() error {
+	if conn, ok := r.trans.(Conn); ok {
+		return conn.Connect()
+	}
+	return nil
+}
+
+// Disconnect disconnects the recorded transport and closes the recording.
+func (r *Recorder) Disconnect() {
+	if conn, ok := r.trans.(Conn); ok {
+		conn.Disconnect()
+	}
+	r.Close()
+}
+
+// Close ends the recording, the recorded transport is left alone.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if c, ok := r.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// readRecord reads the next record, io.EOF at the end of the recording.
+// Payloads of skipped kinds are discarded without being read into memory.
+func readRecord(r *bufio.Reader, keep func(kind byte) bool) (kind byte, at time.Duration, payload []byte, err error) {
+	var head [13]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// a recording cut short by a crash ends at its last whole record
+			err = io.EOF
+		}
+		return
+	}
+	kind = head[0]
+	at = time.Duration(binary.BigEndian.Uint64(head[1:9]))
+	size := int64(binary.BigEndian.Uint32(head[9:13]))
+	if !keep(kind) {
+		_, err = r.Discard(int(size))
+	} else {
+		payload = make([]byte, size)
+		_, err = io.ReadFull(r, payload)
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return
+}
+
+func openRecording(path string) (*os.File, *bufio.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReaderSize(f, 64*1024)
+	magic := make([]byte, len(recordMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != recordMagic {
+		f.Close()
+		return nil, nil, fmt.Errorf("%v: %w", path, ErrBadRecording)
+	}
+	return f, r, nil
+}
+
+// Replayer is a Transporter playing back a Recorder file: requests are
+// answered with the recorded responses to the same method, in order, and
+// interleaved frames are returned with their recorded spacing divided by
+// Speed. The file is read twice, the responses up front and the frames as
+// they are played, so long recordings are not held in memory.
+type Replayer struct {
+	// Speed scales the recorded frame timing, 2 plays twice as fast and zero
+	// or less plays as fast as the frames are read.
+	Speed float64
+
+	path string
+	uri  string
+
+	recorded     map[string][][]byte
+	captureStart time.Time
+
+	mu        sync.Mutex
+	responses map[string][][]byte
+	f         *os.File
+	r         *bufio.Reader
+	pacer     pacer
+	closed    chan struct{}
+}
+
+// OpenReplay loads the responses of the recording at path, Speed defaults to
+// the original speed.
+func OpenReplay(path string) (*Replayer, error) {
+	f, r, err := openRecording(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &Replayer{Speed: 1, path: path, recorded: make(map[string][][]byte)}
+	var method string
+	for {
+		kind, _, payload, err := readRecord(r, func(kind byte) bool { return kind != recordData })
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case recordRequest:
+			line, _, _ := strings.Cut(string(payload), "\r\n")
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				method = ""
+				continue
+			}
+			method = fields[0]
+			if p.uri == "" {
+				p.uri = fields[1]
+			}
+		case recordResponse:
+			if method != "" {
+				p.recorded[method] = append(p.recorded[method], payload)
+			}
+		case recordMeta:
+			for _, line := range strings.Split(string(payload), "\r\n") {
+				key, value, _ := strings.Cut(line, ":")
+				if strings.TrimSpace(key) != metaCaptureStart {
+					continue
+				}
+				if t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(value)); err == nil {
+					p.captureStart = t
+				}
+			}
+		}
+	}
+	if p.uri == "" {
+		return nil, fmt.Errorf("%v: no request recorded: %w", path, ErrBadRecording)
+	}
+	return p, nil
+}
+
+// URL is the rtsp url of the first recorded request.
+func (p *Replayer) URL() string {
+	return p.uri
+}
+
+// CaptureStart is the sender's wall clock time at the start of the
+// recording, zero when the recording carries none.
+func (p *Replayer) CaptureStart() time.Time {
+	return p.captureStart
+}
+
+// Connect rewinds the recording.
+func (p *Replayer) Connect() error {
+	f, r, err := openRecording(p.path)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.f != nil {
+		p.f.Close()
+	}
+	p.f, p.r = f, r
+	p.responses = make(map[string][][]byte, len(p.recorded))
+	for method, queue := range p.recorded {
+		p.responses[method] = queue
+	}
+	p.pacer = pacer{}
+	p.closed = make(chan struct{})
+	return nil
+}
+
+func (p *Replayer) Disconnect() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.f != nil {
+		p.f.Close()
+		p.f, p.r = nil, nil
+		close(p.closed)
+	}
+}
+
+// Send returns the next recorded response to the request's method. Requests
+// the recording has no response for, say keepalives sent more often at a
+// higher Speed, get a bare 200.
+func (p *Replayer) Send(payload []byte) ([]byte, error) {
+	line, _, _ := strings.Cut(string(payload), "\r\n")
+	method, _, _ := strings.Cut(line, " ")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.r == nil {
+		return nil, ErrNotConnected
+	}
+	if queue := p.responses[method]; len(queue) > 0 {
+		p.responses[method] = queue[1:]
+		return queue[0], nil
+	}
+	log.Debugf("wsp replay: no recorded response to %v", method)
+	return rtspReply(payload, nil, nil), nil
+}
+
+// rtspReply makes a 200 response to req carrying its CSeq, the headers and an
+// optional body.
+func rtspReply(req []byte, headers []string, body []byte) []byte {
+	var res bytes.Buffer
+	res.WriteString("RTSP/1.0 200 OK\r\n")
+	for _, h := range strings.Split(string(req), "\r\n") {
+		if strings.HasPrefix(strings.ToLower(h), "cseq:") {
+			res.WriteString(h + "\r\n")
+		}
+	}
+	for _, h := range headers {
+		res.WriteString(h + "\r\n")
+	}
+	if len(body) > 0 {
+		fmt.Fprintf(&res, "Content-Length: %d\r\n", len(body))
+	}
+	res.WriteString("\r\n")
+	res.Write(body)
+	return res.Bytes()
+}
+
+// ReadData returns the next recorded frame once it is due, io.EOF at the end
+// of the recording.
+func (p *Replayer) ReadData() ([]byte, error) {
+	p.mu.Lock()
+	r, closed := p.r, p.closed
+	p.mu.Unlock()
+	if r == nil {
+		return nil, ErrNotConnected
+	}
+	// ReadData is not called concurrently, the reader is only shared with
+	// Disconnect which closes the file under it
+	isData := func(kind byte) bool { return kind == recordData }
+	kind, at, frame, err := readRecord(r, isData)
+	for err == nil && kind != recordData {
+		kind, at, frame, err = readRecord(r, isData)
+	}
+	if err != nil {
+		select {
+		case <-closed:
+			return nil, ErrNotConnected
+		default:
+		}
+		return nil, err
+	}
+
+	p.mu.Lock()
+	due := p.pacer.due(at, p.Speed)
+	p.mu.Unlock()
+	if !sleepUntil(due, closed) {
+		return nil, ErrNotConnected
+	}
+	return frame, nil
+}
+
+// pacer spaces out replayed frames: a frame captured at offset at is due
+// (at-first)/speed after the first frame was played, at once if speed <= 0.
+type pacer struct {
+	started time.Time
+	first   time.Duration
+}
+
+func (p *pacer) due(at time.Duration, speed float64) time.Time {
+	if p.started.IsZero() {
+		p.started, p.first = time.Now(), at
+	}
+	if speed <= 0 {
+		return p.started
+	}
+	return p.started.Add(time.Duration(float64(at-p.first) / speed))
+}
+
+// sleepUntil waits for due, false when closed is closed first.
+func sleepUntil(due time.Time, closed <-chan struct{}) bool {
+	wait := time.Until(due)
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-closed:
+		return false
+	}
+}
@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedTransport answers requests with "<method> reply" and hands out its
+// frames 50ms apart.
+type scriptedTransport struct {
+	frames []string
+}
+
+func (s *scriptedTransport) Send(payload []byte) ([]byte, error) {
+	method, _, _ := strings.Cut(string(payload), " ")
+	return []byte("RTSP/1.0 200 OK\r\nCSeq: 1\r\n\r\n" + method + " reply"), nil
+}
+
+func (s *scriptedTransport) ReadData() ([]byte, error) {
+	if len(s.frames) == 0 {
+		return nil, io.EOF
+	}
+	time.Sleep(50 * time.Millisecond)
+	frame := s.frames[0]
+	s.frames = s.frames[1:]
+	return []byte(frame), nil
+}
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.wsprec")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := NewRecorder(&scriptedTransport{frames: []string{"$\x00\x00\x01a", "$\x00\x00\x01b", "$\x00\x00\x01c"}}, f)
+	for _, method := range []string{"OPTIONS", "DESCRIBE", "OPTIONS"} {
+		if _, err := rec.Send([]byte(method + " rtsp://cam/live RTSP/1.0\r\nCSeq: 1\r\n\r\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the sender's clock runs an hour ahead, the meta record sits between frames
+	if _, err := rec.ReadData(); err != nil {
+		t.Fatal(err)
+	}
+	captureStart := rec.SyncCapture(time.Now().Add(time.Hour))
+	for {
+		if _, err := rec.ReadData(); err != nil {
+			break
+		}
+	}
+	rec.Disconnect()
+
+	p, err := OpenReplay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.URL() != "rtsp://cam/live" {
+		t.Errorf("URL = %v", p.URL())
+	}
+	if !p.CaptureStart().Equal(captureStart) || time.Until(captureStart) < 59*time.Minute {
+		t.Errorf("CaptureStart = %v, want %v", p.CaptureStart(), captureStart)
+	}
+	if _, err := p.Send([]byte("OPTIONS * RTSP/1.0\r\n\r\n")); err != ErrNotConnected {
+		t.Errorf("Send before Connect = %v", err)
+	}
+
+	for _, speed := range []float64{1, 0} {
+		p.Speed = speed
+		if err := p.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		// responses are matched by method, whatever the order of requests
+		for _, method := range []string{"DESCRIBE", "OPTIONS", "OPTIONS"} {
+			res, err := p.Send([]byte(method + " rtsp://cam/live RTSP/1.0\r\nCSeq: 9\r\n\r\n"))
+			if err != nil || !strings.HasSuffix(string(res), method+" reply") {
+				t.Errorf("Send %v = %q, %v", method, res, err)
+			}
+		}
+		if res, _ := p.Send([]byte("GET_PARAMETER rtsp://cam/live RTSP/1.0\r\nCSeq: 10\r\n\r\n")); string(res) != "RTSP/1.0 200 OK\r\nCSeq: 10\r\n\r\n" {
+			t.Errorf("unrecorded response = %q", res)
+		}
+
+		start := time.Now()
+		var got []string
+		for {
+			frame, err := p.ReadData()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(frame[4:]))
+		}
+		elapsed := time.Since(start)
+		if strings.Join(got, "") != "abc" {
+			t.Errorf("speed %v: frames = %v", speed, got)
+		}
+		if speed == 1 && elapsed < 80*time.Millisecond {
+			t.Errorf("speed 1 replayed in %v, recorded spacing is 50ms", elapsed)
+		}
+		if speed == 0 && elapsed > 40*time.Millisecond {
+			t.Errorf("speed 0 replayed in %v", elapsed)
+		}
+		p.Disconnect()
+	}
+}
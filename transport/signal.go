@@ -0,0 +1,198 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSignalEntries bounds a SignalLog. The first signalHead entries, the
+	// handshake, are always kept, later ones are dropped oldest first.
+	maxSignalEntries = 64
+	signalHead       = 16
+	// maxSignalMessage truncates logged requests and responses.
+	maxSignalMessage = 4096
+)
+
+// SignalEntry is one signaling exchange: a WSP INIT, JOIN or WRAP, an RTSP
+// request and its response, or the connect of the transport.
+type SignalEntry struct {
+	Time       time.Time `json:"time"`
+	Proto      string    `json:"proto"`
+	Method     string    `json:"method"`
+	Status     int       `json:"status,omitempty"`
+	DurationMs float64   `json:"durationMs"`
+	Request    string    `json:"request,omitempty"`
+	Response   string    `json:"response,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// SignalLog keeps the signaling of a session with credentials and tokens
+// redacted, for diagnosing sessions that fail to open. A nil SignalLog
+// records nothing.
+type SignalLog struct {
+	mu      sync.Mutex
+	entries []SignalEntry
+	dropped int
+}
+
+func NewSignalLog() *SignalLog {
+	return &SignalLog{}
+}
+
+func (l *SignalLog) add(proto, method string, start time.Time, status int, req, res string, err error) {
+	if l == nil {
+		return
+	}
+	e := SignalEntry{
+		Time:       start,
+		Proto:      proto,
+		Method:     method,
+		Status:     status,
+		DurationMs: float64(time.Since(start)) / float64(time.Millisecond),
+		Request:    truncate(Redact(req)),
+		Response:   truncate(Redact(res)),
+	}
+	if err != nil {
+		e.Error = Redact(err.Error())
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) == maxSignalEntries {
+		l.entries = append(l.entries[:signalHead], l.entries[signalHead+1:]...)
+		l.dropped++
+	}
+	l.entries = append(l.entries, e)
+}
+
+// Entries returns a copy of the log and how many entries were dropped after
+// the handshake.
+func (l *SignalLog) Entries() ([]SignalEntry, int) {
+	if l == nil {
+		return nil, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]SignalEntry(nil), l.entries...), l.dropped
+}
+
+func truncate(s string) string {
+	if len(s) <= maxSignalMessage {
+		return s
+	}
+	return s[:maxSignalMessage] + fmt.Sprintf("... (%d bytes)", len(s))
+}
+
+var (
+	authHeader  = regexp.MustCompile(`(?im)^((?:proxy-)?authorization:[ \t]*)(\S+)[^\r\n]*`)
+	urlPassword = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]*):[^/\s@]*@`)
+	secretParam = regexp.MustCompile(`(?i)([?&;](?:jwt|token|access_token|password|passwd|pwd)=)[^&\s"]*`)
+)
+
+// Redact masks the credentials in signaling text: the value of Authorization
+// headers past the scheme, URL passwords and token and password query
+// parameters.
+func Redact(s string) string {
+	s = authHeader.ReplaceAllString(s, "$1$2 ***")
+	s = urlPassword.ReplaceAllString(s, "$1:***@")
+	return secretParam.ReplaceAllString(s, "${1}***")
+}
+
+// SignalTransport is a Conn that logs the connect and every RTSP request and
+// response passing through it to a SignalLog.
+type SignalTransport struct {
+	trans Transporter
+	uri   string
+	log   *SignalLog
+}
+
+// NewSignalTransport logs trans, which connects to uri, to log.
+func NewSignalTransport(trans Transporter, uri string, log *SignalLog) *SignalTransport {
+	return &SignalTransport{trans: trans, uri: uri, log: log}
+}
+
+func (s *SignalTransport) Send(payload []byte) ([]byte, error) {
+	start := time.Now()
+	res, err := s.trans.Send(payload)
+	method, _, _ := strings.Cut(string(payload), " ")
+	s.log.add("rtsp", method, start, rtspStatus(res), string(payload), string(res), err)
+	return res, err
+}
+
+func (s *SignalTransport) ReadData() ([]byte, error) {
+	return s.trans.ReadData()
+}
+
+// Connect connects the logged transport if it is a Conn.
+func (s *SignalTransport) Connect() error {
+	return s.ConnectContext(context.Background())
+}
+
+// ConnectContext connects the logged transport with Connect(ctx, ...).
+func (s *SignalTransport) ConnectContext(ctx context.Context) error {
+	conn, ok := s.trans.(Conn)
+	if !ok {
+		return nil
+	}
+	start := time.Now()
+	err := Connect(ctx, conn)
+	s.log.add("rtsp", "CONNECT", start, 0, s.uri, "", err)
+	return err
+}
+
+func (s *SignalTransport) Disconnect() {
+	if conn, ok := s.trans.(Conn); ok {
+		conn.Disconnect()
+	}
+}
+
+// rtspStatus returns the status code of an RTSP response, 0 when res is not one.
+func rtspStatus(res []byte) int {
+	line := res
+	if i := bytes.IndexByte(res, '\r'); i >= 0 {
+		line = res[:i]
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "RTSP/") {
+		return 0
+	}
+	code, _ := strconv.Atoi(fields[1])
+	return code
+}
+
+// wspText formats a WSP start line and its headers for the SignalLog, bodies
+// are left out: the RTSP messages in WRAP are logged by SignalTransport.
+func wspText(start string, headers map[string]string) string {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(start + "\r\n")
+	for _, k := range keys {
+		b.WriteString(k + ": " + headers[k] + "\r\n")
+	}
+	return b.String()
+}
+
+// signal logs a WSP exchange.
+func (wsp *WebSocketProxy) signal(req *WSPRequest, start time.Time, res *WSPResponse, err error) {
+	if wsp.Signal == nil {
+		return
+	}
+	var status int
+	var text string
+	if res != nil {
+		status = res.Code
+		text = wspText(fmt.Sprintf("WSP/1.1 %d %s", res.Code, res.Message), res.Headers)
+	}
+	wsp.Signal.add("wsp", req.Cmd, start, status, wspText("WSP/1.1 "+req.Cmd, req.Headers), text, err)
+}
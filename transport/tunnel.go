@@ -0,0 +1,119 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// tunnelContentType marks both halves of an RTSP-over-HTTP tunnel.
+const tunnelContentType = "application/x-rtsp-tunnelled"
+
+// NewHTTPTunnelTransport carries RTSP over HTTP the way QuickTime does: a GET
+// connection receives responses and interleaved data, a POST connection
+// carries base64 encoded requests, and both are tied together by the
+// x-sessioncookie header. The tunnel is opened to the host and port of the
+// rtsp url.
+func NewHTTPTunnelTransport(rtspurl string) (*ConnTransport, error) {
+	u, addr, err := hostPort(rtspurl)
+	if err != nil {
+		return nil, err
+	}
+	return newConnTransport(func(ctx context.Context) (io.Reader, io.Writer, io.Closer, error) {
+		return dialTunnel(ctx, u, addr)
+	}), nil
+}
+
+func sessionCookie() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func dialTunnel(ctx context.Context, u *url.URL, addr string) (io.Reader, io.Writer, io.Closer, error) {
+	cookie, err := sessionCookie()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	path := u.RequestURI()
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	get, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// the server may never answer the GET
+	stop := closeOnDone(ctx, get)
+	defer stop()
+	fmt.Fprintf(get, "GET %s HTTP/1.0\r\n"+
+		"Host: %s\r\n"+
+		"x-sessioncookie: %s\r\n"+
+		"Accept: %s\r\n"+
+		"Pragma: no-cache\r\n"+
+		"Cache-Control: no-cache\r\n\r\n", path, u.Host, cookie, tunnelContentType)
+	r := bufio.NewReader(get)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		get.Close()
+		return nil, nil, nil, contextErr(ctx, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		get.Close()
+		return nil, nil, nil, fmt.Errorf("rtsp tunnel GET: %v", res.Status)
+	}
+
+	post, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		get.Close()
+		return nil, nil, nil, err
+	}
+	// the POST never completes, its length is only a placeholder
+	_, err = fmt.Fprintf(post, "POST %s HTTP/1.0\r\n"+
+		"Host: %s\r\n"+
+		"x-sessioncookie: %s\r\n"+
+		"Content-Type: %s\r\n"+
+		"Content-Length: 32767\r\n"+
+		"Pragma: no-cache\r\n"+
+		"Cache-Control: no-cache\r\n"+
+		"Expires: Sun, 9 Jan 1972 00:00:00 GMT\r\n\r\n", path, u.Host, cookie, tunnelContentType)
+	if err != nil {
+		get.Close()
+		post.Close()
+		return nil, nil, nil, err
+	}
+	return r, &base64Writer{w: post}, &tunnelCloser{get: get, post: post}, nil
+}
+
+// base64Writer encodes every request on its own, as tunnel servers decode
+// each write separately.
+type base64Writer struct {
+	w io.Writer
+}
+
+func (b *base64Writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(b.w, base64.StdEncoding.EncodeToString(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+type tunnelCloser struct {
+	get, post net.Conn
+}
+
+func (c *tunnelCloser) Close() error {
+	err := c.post.Close()
+	if err2 := c.get.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
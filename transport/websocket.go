@@ -3,19 +3,17 @@ package transport
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
-	log "github.com/sirupsen/logrus"
 	"net/http"
 	"net/url"
-	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
-	// "nhooyr.io/websocket"
 )
 
 type WSPRequest struct {
@@ -43,6 +41,30 @@ func (req *WSPRequest) Bytes() []byte {
 	return buf.Bytes()
 }
 
+// NewWSPRequestFromBytes parses a request received by the proxy side.
+func NewWSPRequestFromBytes(b []byte) (*WSPRequest, error) {
+	idx := strings.Index(string(b), "\r\n\r\n")
+	if idx == -1 {
+		return nil, errors.New("bad request")
+	}
+	lines := strings.Split(string(b[:idx]), "\r\n")
+	reqfields := strings.SplitN(lines[0], " ", 2)
+	if len(reqfields) != 2 || reqfields[0] != "WSP/1.1" {
+		return nil, fmt.Errorf("request line 1: %s", lines[0])
+	}
+	req := NewWSPRequest()
+	req.Cmd = strings.TrimSpace(reqfields[1])
+	for _, line := range lines[1:] {
+		arr := strings.SplitN(line, ":", 2)
+		if len(arr) != 2 {
+			continue
+		}
+		req.Headers[arr[0]] = strings.TrimSpace(arr[1])
+	}
+	req.Body = b[idx+4:]
+	return req, nil
+}
+
 type WSPResponse struct {
 	Code    int
 	Message string
@@ -83,125 +105,188 @@ func NewWSPResponseFromBytes(b []byte) (*WSPResponse, error) {
 	}, nil
 }
 
+func (res *WSPResponse) Bytes() []byte {
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("WSP/1.1 %d %s\r\n", res.Code, res.Message))
+	for k, v := range res.Headers {
+		buf.WriteString(fmt.Sprintf("%s: %s\r\n", k, v))
+	}
+	buf.WriteString("\r\n")
+	buf.Write(res.Body)
+	return buf.Bytes()
+}
+
+// WSPError is a response of the WSP proxy refusing a request.
+type WSPError struct {
+	Code    int
+	Message string
+}
+
+func (e *WSPError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+// statusErr returns a *WSPError unless res has a 2xx status, the check
+// shared by INIT, JOIN, WRAP and reattaching.
+func statusErr(res *WSPResponse) error {
+	if res.Code >= 300 {
+		return &WSPError{Code: res.Code, Message: res.Message}
+	}
+	return nil
+}
+
+// defaultRequestTimeout bounds a WSP request when the caller's context has
+// no deadline.
+const defaultRequestTimeout = 10 * time.Second
+
 type WebSocketProxy struct {
-	seqChan     chan int64
+	seqNum      int64
 	wsurl       string
 	rtspurl     string
 	dataChannel string
-	ctrlConn    *websocket.Conn
-	dataConn    *websocket.Conn
-	fin         chan bool
+
+	// mu guards the sockets, requests may race with Disconnect
+	mu       sync.Mutex
+	ctrl     *wspSession
+	dataConn *websocket.Conn
+
+	// OnUnsolicited receives control messages that answer no pending
+	// request, set it before Connect.
+	OnUnsolicited func(*WSPResponse)
+	// RequestTimeout bounds requests sent without a deadline, zero means
+	// 10 seconds.
+	RequestTimeout time.Duration
+	// PingInterval is how often both sockets are pinged, a socket with no
+	// message or pong for two intervals is dead. Zero means 10 seconds,
+	// negative disables pings.
+	PingInterval time.Duration
+	// Dialer opens both sockets, nil uses websocket.DefaultDialer which
+	// verifies the proxy against the system roots.
+	Dialer *websocket.Dialer
+	// TokenSource returns a fresh token for the jwt query parameter of the
+	// wsurl. When set, the token is refreshed before the exp of the current
+	// one and the control socket is redialed with it.
+	TokenSource func() (string, error)
+	// Signal logs the INIT, JOIN and WRAP exchanges when set.
+	Signal *SignalLog
+
+	// stop ends the keepalive of the current connection
+	stop chan struct{}
 }
 
 func NewWebSocketProxy(wsurl string, rtspurl string) (*WebSocketProxy, error) {
-	wsp := &WebSocketProxy{
+	return &WebSocketProxy{
 		wsurl:   wsurl,
 		rtspurl: rtspurl,
-		seqChan: make(chan int64, 1),
-		fin:     make(chan bool),
-	}
-	go wsp.genSeqs()
-	runtime.SetFinalizer(wsp, func(wsp *WebSocketProxy) {
-		close(wsp.fin)
-	})
-	return wsp, nil
-}
-
-func (wsp *WebSocketProxy) genSeqs() {
-	seq := time.Now().Unix()
-	for {
-		select {
-		case <-wsp.fin:
-			break
-		default:
-		}
-		seq = seq + 1
-		wsp.seqChan <- seq
-	}
+		seqNum:  time.Now().Unix(),
+	}, nil
 }
 
 func (wsp *WebSocketProxy) Connect() error {
+	return wsp.ConnectContext(context.Background())
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+// ConnectContext is Connect abandoned when ctx is done. Within ctx, the
+// control socket and INIT are bounded by 30 seconds and JOIN by a minute.
+func (wsp *WebSocketProxy) ConnectContext(ctx context.Context) error {
+	initCtx, cancel := context.WithTimeout(ctx, time.Second*30)
 	defer cancel()
 
-	ctlDialer := websocket.DefaultDialer
-	ctlDialer.TLSClientConfig = &tls.Config{
-		InsecureSkipVerify: true,
-	}
-	controllHeadler := http.Header{}
-	controllHeadler.Add("Sec-WebSocket-Protocol", "control")
-	ctrlConn, _, err := ctlDialer.DialContext(ctx, wsp.wsurl, controllHeadler)
-
-	// ctrlConn, _, err := websocket.Dial(ctx, wsp.wsurl, &websocket.DialOptions{
-	// 	Subprotocols: []string{"control"},
-	// })
+	ctrlConn, err := wsp.dial(initCtx, "control")
 	if err != nil {
 		return err
 	}
-	wsp.ctrlConn = ctrlConn
+	ctrl := newWSPSession(ctrlConn, wsp.OnUnsolicited, wsp.readTimeout())
+	wsp.mu.Lock()
+	wsp.ctrl = ctrl
+	wsp.mu.Unlock()
 
-	err = wsp.doINIT()
+	err = wsp.doINIT(initCtx)
 	if err != nil {
 		return err
 	}
 
-	err = wsp.doJOIN()
+	err = wsp.doJOIN(ctx)
 	if err != nil {
 		return err
 	}
 
+	stop := make(chan struct{})
+	wsp.mu.Lock()
+	wsp.stop = stop
+	wsp.mu.Unlock()
+	go wsp.keepalive(ctrl, stop)
 	return nil
 }
 
+// dial opens a socket with the given subprotocol to the current wsurl.
+func (wsp *WebSocketProxy) dial(ctx context.Context, protocol string) (*websocket.Conn, error) {
+	dialer := wsp.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	header := http.Header{}
+	header.Add("Sec-WebSocket-Protocol", protocol)
+	wsp.mu.Lock()
+	wsurl := wsp.wsurl
+	wsp.mu.Unlock()
+	conn, _, err := dialer.DialContext(ctx, wsurl, header)
+	return conn, err
+}
+
 func (wsp *WebSocketProxy) Disconnect() {
-	if wsp.ctrlConn != nil {
-		// wsp.ctrlConn.Close(websocket.StatusNormalClosure, "")
-		wsp.ctrlConn.Close()
-		wsp.ctrlConn = nil
+	wsp.mu.Lock()
+	defer wsp.mu.Unlock()
+	wsp.closeLocked()
+}
+
+func (wsp *WebSocketProxy) closeLocked() {
+	if wsp.stop != nil {
+		close(wsp.stop)
+		wsp.stop = nil
+	}
+	if wsp.ctrl != nil {
+		wsp.ctrl.Close()
+		wsp.ctrl = nil
 	}
 	if wsp.dataConn != nil {
-		// wsp.dataConn.Close(websocket.StatusNormalClosure, "")
 		wsp.dataConn.Close()
 		wsp.dataConn = nil
 	}
 }
 
-func (wsp *WebSocketProxy) seq() int64 {
-	seq := <-wsp.seqChan
-	return seq
+func (wsp *WebSocketProxy) seq() string {
+	return strconv.FormatInt(atomic.AddInt64(&wsp.seqNum, 1), 10)
 }
 
-func (wsp *WebSocketProxy) sendRequest(c *websocket.Conn, req *WSPRequest) (*WSPResponse, error) {
-	if c == nil {
-		return nil, errors.New("sendRequest invalid conn")
-	}
-	req.Headers["seq"] = strconv.FormatInt(wsp.seq(), 10)
-	// ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	// defer cancel()
-
-	reqbody := req.Bytes()
-	log.Infof("send:%v", string(reqbody))
-	// err := c.Write(ctx, websocket.MessageText, reqbody)
-	err := c.WriteMessage(websocket.TextMessage, reqbody)
-	if err != nil {
-		return nil, err
+// withTimeout applies RequestTimeout when ctx has no deadline.
+func (wsp *WebSocketProxy) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, wsp.requestTimeout())
+}
 
-	// _, buf, err := c.Read(ctx)
-	_, buf, err := c.ReadMessage()
-	if err != nil {
-		return nil, err
+// request runs req on the control socket, concurrent requests are matched to
+// their responses by seq.
+func (wsp *WebSocketProxy) request(ctx context.Context, req *WSPRequest) (*WSPResponse, error) {
+	wsp.mu.Lock()
+	ctrl := wsp.ctrl
+	wsp.mu.Unlock()
+	if ctrl == nil {
+		return nil, ErrNotConnected
 	}
-	log.Infof("receive:%v", string(buf))
-
-	return NewWSPResponseFromBytes(buf)
+	ctx, cancel := wsp.withTimeout(ctx)
+	defer cancel()
+	return ctrl.Do(ctx, wsp.seq(), req)
 }
 
-func (wsp *WebSocketProxy) doINIT() error {
+// initRequest builds INIT for the rtsp url. A non-empty channel asks the proxy
+// to move that channel to the socket the request is sent on.
+func (wsp *WebSocketProxy) initRequest(channel string) (*WSPRequest, error) {
 	u, err := url.Parse(wsp.rtspurl)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	port := u.Port()
@@ -214,72 +299,128 @@ func (wsp *WebSocketProxy) doINIT() error {
 	req.Headers["proto"] = "rtsp"
 	req.Headers["host"] = u.Hostname()
 	req.Headers["port"] = port
-	res, err := wsp.sendRequest(wsp.ctrlConn, req)
+	if channel != "" {
+		req.Headers["channel"] = channel
+	}
+	return req, nil
+}
+
+func (wsp *WebSocketProxy) doINIT(ctx context.Context) error {
+	req, err := wsp.initRequest("")
 	if err != nil {
 		return err
 	}
-	wsp.dataChannel = res.Headers["channel"]
-	if res.Code > 300 {
-		return fmt.Errorf("%d %s", res.Code, res.Message)
+	start := time.Now()
+	res, err := wsp.request(ctx, req)
+	wsp.signal(req, start, res, err)
+	if err != nil {
+		return err
 	}
-	return nil
+	wsp.dataChannel = header(res.Headers, "channel")
+	return statusErr(res)
 }
 
 func (wsp *WebSocketProxy) Send(payload []byte) ([]byte, error) {
-	return wsp.doWrap(payload)
+	return wsp.SendContext(context.Background(), payload)
 }
 
-func (wsp *WebSocketProxy) doWrap(payload []byte) ([]byte, error) {
+// SendContext wraps an RTSP request in WRAP and waits for the response until
+// ctx is done, RequestTimeout applies when ctx has no deadline.
+func (wsp *WebSocketProxy) SendContext(ctx context.Context, payload []byte) ([]byte, error) {
 	req := NewWSPRequest()
 	req.Cmd = "WRAP"
 	req.Body = payload
-	res, err := wsp.sendRequest(wsp.ctrlConn, req)
+	start := time.Now()
+	res, err := wsp.request(ctx, req)
+	wsp.signal(req, start, res, err)
 	if err != nil {
 		return nil, err
 	}
-	if res.Code != 200 {
-		return nil, fmt.Errorf("%d %s", res.Code, res.Message)
+	if err := statusErr(res); err != nil {
+		return nil, err
 	}
 	return res.Body, nil
 }
 
 func (wsp *WebSocketProxy) ReadData() ([]byte, error) {
-	// _, buf, err := wsp.dataConn.Read(context.Background())
-	_, buf, err := wsp.dataConn.ReadMessage()
+	wsp.mu.Lock()
+	dataConn := wsp.dataConn
+	wsp.mu.Unlock()
+	if dataConn == nil {
+		return nil, ErrNotConnected
+	}
+	if timeout := wsp.readTimeout(); timeout > 0 {
+		dataConn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	_, buf, err := dataConn.ReadMessage()
 	return buf, err
 }
 
-func (wsp *WebSocketProxy) doJOIN() error {
+func (wsp *WebSocketProxy) doJOIN(ctx context.Context) (err error) {
+	wsp.mu.Lock()
 	if wsp.dataConn != nil {
-		// wsp.dataConn.Close(websocket.StatusNormalClosure, "")
 		wsp.dataConn.Close()
 		wsp.dataConn = nil
 	}
+	wsp.mu.Unlock()
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
 
-	// dataConn, _, err := websocket.Dial(ctx, wsp.wsurl, &websocket.DialOptions{
-	// 	Subprotocols: []string{"data"},
-	// })
-	dataDialer := websocket.DefaultDialer
-	dataDialer.TLSClientConfig = &tls.Config{
-		InsecureSkipVerify: true,
-	}
-	dataHeader := http.Header{}
-	dataHeader.Add("Sec-WebSocket-Protocol", "data")
-	dataConn, _, err := dataDialer.DialContext(ctx, wsp.wsurl, dataHeader)
-	if err != nil {
-		return err
-	}
-	wsp.dataConn = dataConn
-
+	// the data socket carries only the JOIN exchange before RTP, so it is
+	// answered in order
 	req := NewWSPRequest()
 	req.Cmd = "JOIN"
 	req.Headers["channel"] = wsp.dataChannel
-	_, err = wsp.sendRequest(wsp.dataConn, req)
+	req.Headers["seq"] = wsp.seq()
+	start := time.Now()
+	var res *WSPResponse
+	defer func() { wsp.signal(req, start, res, err) }()
+
+	dataConn, err := wsp.dial(ctx, "data")
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(wsp.requestTimeout())
+	dataConn.SetWriteDeadline(deadline)
+	dataConn.SetReadDeadline(deadline)
+	stop := closeOnDone(ctx, dataConn)
+	if err = dataConn.WriteMessage(websocket.TextMessage, req.Bytes()); err != nil {
+		stop()
+		dataConn.Close()
+		return contextErr(ctx, err)
+	}
+	_, buf, err := dataConn.ReadMessage()
+	stop()
+	// the socket may have been closed by closeOnDone after the read
+	if err != nil || ctx.Err() != nil {
+		dataConn.Close()
+		return contextErr(ctx, err)
+	}
+	dataConn.SetReadDeadline(time.Time{})
+	if timeout := wsp.readTimeout(); timeout > 0 {
+		dataConn.SetPongHandler(func(string) error {
+			return dataConn.SetReadDeadline(time.Now().Add(timeout))
+		})
+	}
+	res, err = NewWSPResponseFromBytes(buf)
 	if err != nil {
+		dataConn.Close()
 		return err
 	}
+	if err = statusErr(res); err != nil {
+		dataConn.Close()
+		return fmt.Errorf("JOIN %w", err)
+	}
+	wsp.mu.Lock()
+	wsp.dataConn = dataConn
+	wsp.mu.Unlock()
 	return nil
 }
+
+func (wsp *WebSocketProxy) requestTimeout() time.Duration {
+	if wsp.RequestTimeout > 0 {
+		return wsp.RequestTimeout
+	}
+	return defaultRequestTimeout
+}
@@ -0,0 +1,392 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startWSPServer is a WSP proxy stand-in. WRAP requests are echoed back after
+// the delay named in their body ("delay=50ms"), bodies equal to "hang" are
+// never answered, "push" makes the server send an unsolicited message and
+// "freeze" stops it reading, so pings go unanswered. Channels cannot be
+// reattached.
+func startWSPServer(t *testing.T) string {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"control", "data"}}
+	frozen := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var writeMu sync.Mutex
+		write := func(s string) {
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			conn.WriteMessage(websocket.TextMessage, []byte(s))
+		}
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			req, err := NewWSPRequestFromBytes(msg)
+			if err != nil {
+				return
+			}
+			seq := req.Headers["seq"]
+			switch req.Cmd {
+			case "INIT":
+				if req.Headers["channel"] != "" {
+					write("WSP/1.1 404 Not Found\r\nseq: " + seq + "\r\n\r\n")
+					continue
+				}
+				write("WSP/1.1 200 OK\r\nseq: " + seq + "\r\nchannel: 7\r\n\r\n")
+			case "JOIN":
+				write("WSP/1.1 200 OK\r\nseq: " + seq + "\r\n\r\n")
+			case "WRAP":
+				switch b := string(req.Body); {
+				case b == "hang":
+				case b == "freeze":
+					<-frozen
+				case b == "push":
+					write("WSP/1.1 200 OK\r\nseq: " + seq + "\r\n\r\npush")
+					write("WSP/1.1 404 Channel Closed\r\nchannel: 7\r\n\r\n")
+				default:
+					delay, _ := time.ParseDuration(strings.TrimPrefix(b, "delay="))
+					go func() {
+						time.Sleep(delay)
+						write("WSP/1.1 200 OK\r\nseq: " + seq + "\r\n\r\n" + b)
+					}()
+				}
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(frozen) })
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func connectWSP(t *testing.T, unsolicited func(*WSPResponse)) *WebSocketProxy {
+	wsp, err := NewWebSocketProxy(startWSPServer(t), "rtsp://127.0.0.1/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsp.OnUnsolicited = unsolicited
+	if err := wsp.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(wsp.Disconnect)
+	return wsp
+}
+
+func TestWSPStatus(t *testing.T) {
+	for code, fail := range map[int]bool{200: false, 204: false, 300: true, 302: true, 404: true, 502: true} {
+		err := statusErr(&WSPResponse{Code: code, Message: "x"})
+		if (err != nil) != fail {
+			t.Errorf("statusErr(%d) = %v", code, err)
+		}
+	}
+}
+
+func TestWSPPipelinedResponses(t *testing.T) {
+	wsp := connectWSP(t, nil)
+
+	// later requests are answered first, each caller still gets its own reply
+	delays := []string{"delay=80ms", "delay=40ms", "delay=0s"}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(delays))
+	for _, d := range delays {
+		wg.Add(1)
+		go func(d string) {
+			defer wg.Done()
+			res, err := wsp.Send([]byte(d))
+			if err != nil {
+				errs <- err
+			} else if string(res) != d {
+				errs <- fmt.Errorf("request %q got response %q", d, res)
+			}
+		}(d)
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestWSPRequestTimeout(t *testing.T) {
+	wsp := connectWSP(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := wsp.SendContext(ctx, []byte("hang")); err != context.DeadlineExceeded {
+		t.Errorf("SendContext error = %v, want DeadlineExceeded", err)
+	}
+
+	wsp.RequestTimeout = 50 * time.Millisecond
+	if _, err := wsp.Send([]byte("hang")); err != context.DeadlineExceeded {
+		t.Errorf("Send error = %v, want DeadlineExceeded", err)
+	}
+	// the socket is still usable after a timed out request
+	if res, err := wsp.Send([]byte("delay=0s")); err != nil || string(res) != "delay=0s" {
+		t.Errorf("Send after timeout = %q, %v", res, err)
+	}
+}
+
+func TestWSPConnectCanceled(t *testing.T) {
+	// the stand-in answers INIT but never JOIN
+	upgrader := websocket.Upgrader{Subprotocols: []string{"control", "data"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if req, err := NewWSPRequestFromBytes(msg); err == nil && req.Cmd == "INIT" {
+				conn.WriteMessage(websocket.TextMessage, []byte("WSP/1.1 200 OK\r\nseq: "+req.Headers["seq"]+"\r\nchannel: 7\r\n\r\n"))
+			}
+		}
+	}))
+	defer srv.Close()
+	wsp, err := NewWebSocketProxy("ws"+strings.TrimPrefix(srv.URL, "http"), "rtsp://127.0.0.1/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wsp.Disconnect()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	errc := make(chan error, 1)
+	go func() { errc <- Connect(ctx, wsp) }()
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Errorf("Connect error = %v, want Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connect not canceled")
+	}
+}
+
+func TestWSPUnsolicited(t *testing.T) {
+	got := make(chan *WSPResponse, 1)
+	wsp := connectWSP(t, func(res *WSPResponse) { got <- res })
+
+	if res, err := wsp.Send([]byte("push")); err != nil || string(res) != "push" {
+		t.Fatalf("Send = %q, %v", res, err)
+	}
+	select {
+	case res := <-got:
+		if res.Code != 404 || res.Headers["channel"] != "7" {
+			t.Errorf("unsolicited = %+v", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("unsolicited message not delivered")
+	}
+
+	wsp.Disconnect()
+	if _, err := wsp.Send([]byte("delay=0s")); err != ErrNotConnected {
+		t.Errorf("Send after Disconnect = %v, want ErrNotConnected", err)
+	}
+}
+
+// startRTSPServer runs serveRTSP on every connection accepted, which is also
+// sent to accepted.
+func startRTSPServer(t *testing.T, accepted chan net.Conn) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+			go func() {
+				defer conn.Close()
+				serveRTSP(bufio.NewReader(conn), conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestWSPServer(t *testing.T) {
+	hangup := make(chan net.Conn, 1)
+	addr := startRTSPServer(t, hangup)
+
+	srv := httptest.NewServer(NewWSPServer())
+	defer srv.Close()
+	wsurl := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	got := make(chan *WSPResponse, 1)
+	wsp, err := NewWebSocketProxy(wsurl, "rtsp://"+addr+"/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsp.OnUnsolicited = func(res *WSPResponse) { got <- res }
+	if err := wsp.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer wsp.Disconnect()
+
+	// the RTSP server hanging up is reported on the control socket
+	(<-hangup).Close()
+	select {
+	case res := <-got:
+		if res.Code != 404 || res.Headers["channel"] != wsp.dataChannel {
+			t.Errorf("unsolicited = %+v", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel close not reported")
+	}
+	wsp.Disconnect()
+
+	if err := wsp.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	checkExchange(t, wsp)
+
+	unreachable, _ := NewWebSocketProxy(wsurl, "rtsp://127.0.0.1:1/live")
+	if err := unreachable.Connect(); err == nil || !strings.HasPrefix(err.Error(), "502") {
+		t.Errorf("Connect to unreachable target = %v, want 502", err)
+	}
+	unreachable.Disconnect()
+}
+
+func TestWSPServerForbiddenTarget(t *testing.T) {
+	addr := startRTSPServer(t, make(chan net.Conn, 1))
+	proxy := NewWSPServer()
+	proxy.Dial = func(network, target string) (net.Conn, error) {
+		if target != addr {
+			return nil, ErrForbiddenTarget
+		}
+		return net.Dial(network, target)
+	}
+	srv := httptest.NewServer(proxy)
+	defer srv.Close()
+	wsurl := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	wsp, _ := NewWebSocketProxy(wsurl, "rtsp://"+addr+"/live")
+	if err := wsp.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	wsp.Disconnect()
+
+	internal, _ := NewWebSocketProxy(wsurl, "rtsp://10.0.0.1:554/live")
+	err := internal.Connect()
+	var wspErr *WSPError
+	if !errors.As(err, &wspErr) || wspErr.Code != 403 {
+		t.Errorf("Connect to a target outside the allowlist = %v, want 403", err)
+	}
+	internal.Disconnect()
+}
+
+func TestWSPDeadPeer(t *testing.T) {
+	wsp, err := NewWebSocketProxy(startWSPServer(t), "rtsp://127.0.0.1/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsp.PingInterval = 50 * time.Millisecond
+	if err := wsp.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer wsp.Disconnect()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := wsp.ReadData()
+		errc <- err
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	wsp.SendContext(ctx, []byte("freeze"))
+
+	// the control socket goes silent and the stand-in refuses to reattach, so
+	// the whole connection is dropped
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("ReadData succeeded on a dead connection")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("dead peer not detected")
+	}
+}
+
+// testToken is an unsigned JWT expiring at exp.
+func testToken(exp time.Time) string {
+	claims := fmt.Sprintf(`{"exp":%d}`, exp.Unix())
+	return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+}
+
+func TestWSPTokenRefresh(t *testing.T) {
+	accepted := make(chan net.Conn, 2)
+	addr := startRTSPServer(t, accepted)
+
+	proxy := NewWSPServer()
+	var mu sync.Mutex
+	var dials []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		dials = append(dials, r.Header.Get("Sec-WebSocket-Protocol")+" "+r.URL.Query().Get("jwt"))
+		mu.Unlock()
+		proxy.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	wsurl := "ws" + strings.TrimPrefix(srv.URL, "http") + "/?jwt=" + testToken(time.Now().Add(2*time.Second))
+	wsp, err := NewWebSocketProxy(wsurl, "rtsp://"+addr+"/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsp.TokenSource = func() (string, error) { return "fresh", nil }
+	if err := wsp.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer wsp.Disconnect()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		mu.Lock()
+		n := len(dials)
+		last := dials[n-1]
+		mu.Unlock()
+		if n == 3 && last == "control fresh" {
+			break
+		}
+		if n > 3 || time.Now().After(deadline) {
+			t.Fatalf("dials = %v", dials)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// requests go over the new control socket, data still arrives on the
+	// first data socket from the same RTSP connection
+	checkExchange(t, wsp)
+	if len(accepted) != 1 {
+		t.Errorf("RTSP server accepted %d connections, want 1", len(accepted))
+	}
+}
@@ -0,0 +1,239 @@
+package transport
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultPingInterval = 10 * time.Second
+	// tokenRefreshMargin is how long before its exp a token is replaced
+	tokenRefreshMargin = time.Minute
+	// tokenParam is the query parameter of the wsurl carrying the token
+	tokenParam = "jwt"
+)
+
+// ErrReattachRefused is returned when the proxy does not move a channel to a
+// redialed control socket.
+var ErrReattachRefused = errors.New("wsp proxy refused to reattach channel")
+
+func (wsp *WebSocketProxy) pingInterval() time.Duration {
+	if wsp.PingInterval == 0 {
+		return defaultPingInterval
+	}
+	return wsp.PingInterval
+}
+
+// readTimeout is how long a socket may stay silent, zero when pings are off.
+func (wsp *WebSocketProxy) readTimeout() time.Duration {
+	if interval := wsp.pingInterval(); interval > 0 {
+		return 2 * interval
+	}
+	return 0
+}
+
+// tokenExpiry returns the exp claim of a JWT, zero if it has none.
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
+
+// refreshDelay is when the token of wsurl should be replaced, -1 when it
+// does not expire or cannot be refreshed.
+func (wsp *WebSocketProxy) refreshDelay() time.Duration {
+	if wsp.TokenSource == nil {
+		return -1
+	}
+	wsp.mu.Lock()
+	wsurl := wsp.wsurl
+	wsp.mu.Unlock()
+	u, err := url.Parse(wsurl)
+	if err != nil {
+		return -1
+	}
+	exp := tokenExpiry(u.Query().Get(tokenParam))
+	if exp.IsZero() {
+		return -1
+	}
+	// short lived tokens are refreshed halfway through
+	lifetime := time.Until(exp)
+	delay := lifetime - tokenRefreshMargin
+	if delay < lifetime/2 {
+		delay = lifetime / 2
+	}
+	if delay < time.Second {
+		delay = time.Second
+	}
+	return delay
+}
+
+// refreshToken puts a token from TokenSource into the wsurl used by later
+// dials.
+func (wsp *WebSocketProxy) refreshToken() error {
+	token, err := wsp.TokenSource()
+	if err != nil {
+		return err
+	}
+	wsp.mu.Lock()
+	defer wsp.mu.Unlock()
+	u, err := url.Parse(wsp.wsurl)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	query.Set(tokenParam, token)
+	u.RawQuery = query.Encode()
+	wsp.wsurl = u.String()
+	return nil
+}
+
+// redialControl opens a new control socket and asks the proxy to move the
+// joined channel to it with INIT carrying the channel, so the data socket and
+// the RTSP connection behind it carry on. The old control socket is closed
+// once the proxy agrees.
+func (wsp *WebSocketProxy) redialControl(stop chan struct{}) (*wspSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), wsp.requestTimeout())
+	defer cancel()
+
+	conn, err := wsp.dial(ctx, "control")
+	if err != nil {
+		return nil, err
+	}
+	ctrl := newWSPSession(conn, wsp.OnUnsolicited, wsp.readTimeout())
+	req, err := wsp.initRequest(wsp.dataChannel)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	start := time.Now()
+	res, err := ctrl.Do(ctx, wsp.seq(), req)
+	wsp.signal(req, start, res, err)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	if statusErr(res) != nil || header(res.Headers, "channel") != wsp.dataChannel {
+		ctrl.Close()
+		return nil, fmt.Errorf("%w: %d %s", ErrReattachRefused, res.Code, res.Message)
+	}
+
+	wsp.mu.Lock()
+	if wsp.stop != stop {
+		// disconnected meanwhile
+		wsp.mu.Unlock()
+		ctrl.Close()
+		return nil, ErrNotConnected
+	}
+	old := wsp.ctrl
+	wsp.ctrl = ctrl
+	wsp.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return ctrl, nil
+}
+
+// ping sends a ping on both sockets, a missing pong makes their reads fail.
+func (wsp *WebSocketProxy) ping(ctrl *wspSession) {
+	deadline := time.Now().Add(wsp.pingInterval())
+	if err := ctrl.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+		log.Debugf("wsp: ping control: %v", err)
+	}
+	wsp.mu.Lock()
+	dataConn := wsp.dataConn
+	wsp.mu.Unlock()
+	if dataConn != nil {
+		if err := dataConn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+			log.Debugf("wsp: ping data: %v", err)
+		}
+	}
+}
+
+// keepalive pings both sockets, refreshes the token before it expires and
+// redials the control socket when it dies. When the proxy cannot take the
+// channel over, the whole connection is closed so ReadData fails and the
+// caller reconnects.
+func (wsp *WebSocketProxy) keepalive(ctrl *wspSession, stop chan struct{}) {
+	var pingC <-chan time.Time
+	if interval := wsp.pingInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		pingC = ticker.C
+	}
+	var refreshC <-chan time.Time
+	schedule := func() {
+		refreshC = nil
+		if delay := wsp.refreshDelay(); delay >= 0 {
+			refreshC = time.After(delay)
+		}
+	}
+	schedule()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-pingC:
+			wsp.ping(ctrl)
+		case <-refreshC:
+			if err := wsp.refreshToken(); err != nil {
+				log.Errorf("wsp: token refresh failed: %v", err)
+				refreshC = time.After(wsp.requestTimeout())
+				continue
+			}
+			// a proxy that cannot reattach keeps serving the old socket
+			if next, err := wsp.redialControl(stop); err != nil {
+				log.Warnf("wsp: redial control with new token: %v", err)
+			} else {
+				ctrl = next
+			}
+			schedule()
+		case <-ctrl.Done():
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			log.Warnf("wsp: control socket lost, redialing")
+			if wsp.TokenSource != nil {
+				if err := wsp.refreshToken(); err != nil {
+					log.Errorf("wsp: token refresh failed: %v", err)
+				}
+			}
+			next, err := wsp.redialControl(stop)
+			if err != nil {
+				log.Errorf("wsp: redial control: %v", err)
+				wsp.mu.Lock()
+				if wsp.stop == stop {
+					wsp.closeLocked()
+				}
+				wsp.mu.Unlock()
+				return
+			}
+			ctrl = next
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gorilla/websocket"
+)
+
+// WSPServer is the proxy side of WSP: a "control" WebSocket opens a TCP
+// connection to an RTSP server with INIT and relays the RTSP requests wrapped
+// in WRAP, a "data" WebSocket joins that channel with JOIN and receives its
+// interleaved frames as binary messages. The channel is closed with the
+// control socket that owns it. INIT carrying a channel header moves an open
+// channel to a new control socket instead, so a client can redial its control
+// socket, say with a fresh token, while the data socket carries on.
+//
+// It is an http.Handler, so it can be served by httptest next to the joy4
+// RTSP server in tests or mounted on the gin router for browsers.
+type WSPServer struct {
+	// Upgrader upgrades both sockets, its Subprotocols must offer "control"
+	// and "data".
+	Upgrader websocket.Upgrader
+	// Dial opens the connection to the RTSP server named by INIT, it may
+	// refuse targets the proxy should not reach with ErrForbiddenTarget, INIT
+	// is then answered 403. Nil dials TCP directly, only safe in tests.
+	Dial func(network, addr string) (net.Conn, error)
+
+	mu       sync.Mutex
+	channels map[string]*wspChannel
+}
+
+// wspChannel is an RTSP connection opened by INIT and the control socket
+// currently owning it.
+type wspChannel struct {
+	trans  *ConnTransport
+	owner  *websocket.Conn
+	notify func(*WSPResponse)
+}
+
+// ErrForbiddenTarget is returned by WSPServer.Dial for a target outside the
+// proxy's allowlist.
+var ErrForbiddenTarget = errors.New("wsp target not allowed")
+
+func NewWSPServer() *WSPServer {
+	return &WSPServer{
+		Upgrader: websocket.Upgrader{Subprotocols: []string{"control", "data"}},
+		channels: make(map[string]*wspChannel),
+	}
+}
+
+func (s *WSPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Warnf("wsp server: upgrade: %v", err)
+		return
+	}
+	defer conn.Close()
+	switch conn.Subprotocol() {
+	case "control":
+		s.serveControl(conn)
+	case "data":
+		s.serveData(conn)
+	default:
+		log.Warnf("wsp server: unknown subprotocol %q", conn.Subprotocol())
+	}
+}
+
+// wspReply builds a response to a request with the given seq.
+func wspReply(seq string, code int, body []byte) *WSPResponse {
+	res := &WSPResponse{
+		Code:    code,
+		Message: http.StatusText(code),
+		Headers: make(map[string]string),
+		Body:    body,
+	}
+	if seq != "" {
+		res.Headers["seq"] = seq
+	}
+	return res
+}
+
+func (s *WSPServer) dial(addr string) *ConnTransport {
+	return newConnTransport(func(ctx context.Context) (io.Reader, io.Writer, io.Closer, error) {
+		var conn net.Conn
+		var err error
+		if s.Dial != nil {
+			conn, err = s.Dial("tcp", addr)
+		} else {
+			dialer := &net.Dialer{Timeout: dialTimeout}
+			conn, err = dialer.DialContext(ctx, "tcp", addr)
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return conn, conn, conn, nil
+	})
+}
+
+// addChannel registers trans under a random id, ids are hard to guess since
+// INIT with the id takes the channel over.
+func (s *WSPServer) addChannel(trans *ConnTransport, owner *websocket.Conn, notify func(*WSPResponse)) (string, error) {
+	id, err := sessionCookie()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	if s.channels == nil {
+		s.channels = make(map[string]*wspChannel)
+	}
+	s.channels[id] = &wspChannel{trans: trans, owner: owner, notify: notify}
+	s.mu.Unlock()
+	go s.watchChannel(id, trans)
+	return id, nil
+}
+
+// watchChannel tells the owner when the RTSP server hangs up.
+func (s *WSPServer) watchChannel(id string, trans *ConnTransport) {
+	_, _, done := trans.channels()
+	<-done
+	s.mu.Lock()
+	ch := s.channels[id]
+	if ch == nil || ch.trans != trans {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.channels, id)
+	s.mu.Unlock()
+
+	trans.Disconnect()
+	res := wspReply("", http.StatusNotFound, nil)
+	res.Message = "Channel Closed"
+	res.Headers["channel"] = id
+	ch.notify(res)
+}
+
+// reattach moves a channel to another control socket.
+func (s *WSPServer) reattach(id string, owner *websocket.Conn, notify func(*WSPResponse)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := s.channels[id]
+	if ch == nil {
+		return false
+	}
+	ch.owner, ch.notify = owner, notify
+	return true
+}
+
+// owned returns the transport of a channel if owner still owns it.
+func (s *WSPServer) owned(id string, owner *websocket.Conn) *ConnTransport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch := s.channels[id]; ch != nil && ch.owner == owner {
+		return ch.trans
+	}
+	return nil
+}
+
+func (s *WSPServer) channel(id string) *ConnTransport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch := s.channels[id]; ch != nil {
+		return ch.trans
+	}
+	return nil
+}
+
+// release removes the channel if owner still owns it.
+func (s *WSPServer) release(id string, owner *websocket.Conn) *ConnTransport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := s.channels[id]
+	if ch == nil || ch.owner != owner {
+		return nil
+	}
+	delete(s.channels, id)
+	return ch.trans
+}
+
+// serveControl answers INIT and WRAP. WRAP requests are relayed concurrently,
+// the RTSP connection answers them in order and each reply carries the seq
+// of its request.
+func (s *WSPServer) serveControl(conn *websocket.Conn) {
+	var writeMu sync.Mutex
+	reply := func(res *WSPResponse) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := conn.WriteMessage(websocket.TextMessage, res.Bytes()); err != nil {
+			log.Debugf("wsp server: write: %v", err)
+		}
+	}
+
+	// id is the channel opened or taken over on this socket
+	var id string
+	defer func() {
+		if trans := s.release(id, conn); trans != nil {
+			trans.Disconnect()
+		}
+	}()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		req, err := NewWSPRequestFromBytes(msg)
+		if err != nil {
+			reply(wspReply("", http.StatusBadRequest, nil))
+			continue
+		}
+		seq := header(req.Headers, "seq")
+		switch req.Cmd {
+		case "INIT":
+			if id != "" {
+				reply(wspReply(seq, http.StatusBadRequest, nil))
+				continue
+			}
+			if channel := header(req.Headers, "channel"); channel != "" {
+				if !s.reattach(channel, conn, reply) {
+					reply(wspReply(seq, http.StatusNotFound, nil))
+					continue
+				}
+				id = channel
+			} else {
+				port := header(req.Headers, "port")
+				if port == "" {
+					port = defaultRTSPPort
+				}
+				trans := s.dial(net.JoinHostPort(header(req.Headers, "host"), port))
+				if err := trans.Connect(); err != nil {
+					log.Warnf("wsp server: INIT %v:%v: %v", header(req.Headers, "host"), port, err)
+					code := http.StatusBadGateway
+					if errors.Is(err, ErrForbiddenTarget) {
+						code = http.StatusForbidden
+					}
+					reply(wspReply(seq, code, nil))
+					continue
+				}
+				if id, err = s.addChannel(trans, conn, reply); err != nil {
+					trans.Disconnect()
+					reply(wspReply(seq, http.StatusInternalServerError, nil))
+					continue
+				}
+			}
+			res := wspReply(seq, http.StatusOK, nil)
+			res.Headers["channel"] = id
+			reply(res)
+		case "WRAP":
+			trans := s.owned(id, conn)
+			if trans == nil {
+				reply(wspReply(seq, http.StatusNotFound, nil))
+				continue
+			}
+			go func(body []byte) {
+				res, err := trans.Send(body)
+				if err != nil {
+					log.Warnf("wsp server: WRAP on channel %v: %v", id, err)
+					reply(wspReply(seq, http.StatusBadGateway, nil))
+					return
+				}
+				reply(wspReply(seq, http.StatusOK, res))
+			}(req.Body)
+		default:
+			reply(wspReply(seq, http.StatusNotImplemented, nil))
+		}
+	}
+}
+
+// serveData answers JOIN and then streams the channel's interleaved frames
+// until either side closes.
+func (s *WSPServer) serveData(conn *websocket.Conn) {
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		return
+	}
+	req, err := NewWSPRequestFromBytes(msg)
+	if err != nil || req.Cmd != "JOIN" {
+		conn.WriteMessage(websocket.TextMessage, wspReply("", http.StatusBadRequest, nil).Bytes())
+		return
+	}
+	seq := header(req.Headers, "seq")
+	trans := s.channel(header(req.Headers, "channel"))
+	if trans == nil {
+		conn.WriteMessage(websocket.TextMessage, wspReply(seq, http.StatusNotFound, nil).Bytes())
+		return
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, wspReply(seq, http.StatusOK, nil).Bytes()); err != nil {
+		return
+	}
+
+	// nothing is expected from the client, reading notices it going away
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+	for {
+		frame, err := trans.ReadData()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+			return
+		}
+	}
+}
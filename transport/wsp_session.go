@@ -0,0 +1,161 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrSessionClosed is returned to requests pending on a WSP control socket
+// that was closed.
+var ErrSessionClosed = errors.New("wsp session closed")
+
+// wspSession runs requests on a WSP control socket. Requests are pipelined: a
+// reader goroutine hands each response to the caller waiting on its seq, and
+// messages no caller is waiting for go to the unsolicited handler. With a
+// readTimeout the socket is considered dead when neither a message nor a pong
+// arrives within it.
+type wspSession struct {
+	conn        *websocket.Conn
+	unsolicited func(*WSPResponse)
+	readTimeout time.Duration
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan *WSPResponse
+	err     error
+	done    chan struct{}
+}
+
+func newWSPSession(conn *websocket.Conn, unsolicited func(*WSPResponse), readTimeout time.Duration) *wspSession {
+	s := &wspSession{
+		conn:        conn,
+		unsolicited: unsolicited,
+		readTimeout: readTimeout,
+		pending:     make(map[string]chan *WSPResponse),
+		done:        make(chan struct{}),
+	}
+	if readTimeout > 0 {
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(readTimeout))
+		})
+	}
+	go s.readLoop()
+	return s
+}
+
+// header returns a header regardless of the case the server used.
+func header(headers map[string]string, key string) string {
+	if v, ok := headers[key]; ok {
+		return v
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *wspSession) readLoop() {
+	var err error
+	defer func() {
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+		close(s.done)
+	}()
+	for {
+		if s.readTimeout > 0 {
+			s.conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+		}
+		var buf []byte
+		if _, buf, err = s.conn.ReadMessage(); err != nil {
+			return
+		}
+		log.Debugf("wsp receive: %v", string(buf))
+		res, perr := NewWSPResponseFromBytes(buf)
+		if perr != nil {
+			log.Warnf("wsp: bad control message: %v", perr)
+			continue
+		}
+		seq := header(res.Headers, "seq")
+		s.mu.Lock()
+		ch := s.pending[seq]
+		delete(s.pending, seq)
+		s.mu.Unlock()
+		if ch != nil {
+			ch <- res
+		} else if s.unsolicited != nil {
+			s.unsolicited(res)
+		} else {
+			log.Warnf("wsp: unsolicited message: %d %s", res.Code, res.Message)
+		}
+	}
+}
+
+// Do sends req with the given seq and waits for its response until ctx is
+// done or the socket closes.
+func (s *wspSession) Do(ctx context.Context, seq string, req *WSPRequest) (*WSPResponse, error) {
+	req.Headers["seq"] = seq
+	ch := make(chan *WSPResponse, 1)
+	s.mu.Lock()
+	if s.pending == nil {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	s.pending[seq] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, seq)
+		s.mu.Unlock()
+	}()
+
+	body := req.Bytes()
+	log.Debugf("wsp send: %v", string(body))
+	s.writeMu.Lock()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(deadline)
+	}
+	err := s.conn.WriteMessage(websocket.TextMessage, body)
+	s.writeMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-ch:
+		return res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.done:
+		s.mu.Lock()
+		err := s.err
+		s.mu.Unlock()
+		if err == nil {
+			err = ErrSessionClosed
+		}
+		return nil, err
+	}
+}
+
+// Done is closed when the socket stops being read, after Close or when the
+// peer goes away.
+func (s *wspSession) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *wspSession) Close() error {
+	s.mu.Lock()
+	s.pending = nil
+	s.mu.Unlock()
+	return s.conn.Close()
+}
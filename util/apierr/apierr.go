@@ -0,0 +1,126 @@
+// Package apierr 对外接口的错误模型：错误类别、上游 RTSP/WSP 状态码和是否可重试，
+// HTTP 和 WebSocket 客户端据此处理错误，无需解析错误信息
+package apierr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+)
+
+// Category 错误类别
+type Category string
+
+const (
+	// Auth 平台、WSP 代理或摄像机鉴权失败
+	Auth Category = "auth"
+	// NotFound 窗口、视频源或文件不存在
+	NotFound Category = "not_found"
+	// Network 连接失败、超时或连接中断
+	Network Category = "network"
+	// Protocol RTSP/WSP 交互出错
+	Protocol Category = "protocol"
+	// UnsupportedCodec 视频源没有可播放的视频流
+	UnsupportedCodec Category = "unsupported_codec"
+	// Decoder 解码器创建或解码失败
+	Decoder Category = "decoder"
+	// ResourceLimit 本机或上游资源不足，如 UDP 端口耗尽、服务端带宽不足
+	ResourceLimit Category = "resource_limit"
+	// InvalidRequest 请求参数错误或视频源不支持该操作
+	InvalidRequest Category = "invalid_request"
+	// Canceled 打开过程中窗口被关闭，或被同一窗口新的打开请求取代
+	Canceled Category = "canceled"
+	// Internal 未归类的错误
+	Internal Category = "internal"
+)
+
+// HTTPStatus 该类别错误对应的 HTTP 状态码，上游的问题用 502/503
+func (c Category) HTTPStatus() int {
+	switch c {
+	case Auth:
+		return http.StatusUnauthorized
+	case NotFound:
+		return http.StatusNotFound
+	case Network, Protocol:
+		return http.StatusBadGateway
+	case UnsupportedCodec:
+		return http.StatusUnsupportedMediaType
+	case ResourceLimit:
+		return http.StatusServiceUnavailable
+	case InvalidRequest:
+		return http.StatusBadRequest
+	case Canceled:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// retryable 该类别的错误默认是否可以重试
+func (c Category) retryable() bool {
+	return c == Network || c == ResourceLimit
+}
+
+// Error 带类别的错误，Status 为上游 RTSP/WSP 的状态码，没有时为 0
+type Error struct {
+	Category  Category `json:"category"`
+	Status    int      `json:"status,omitempty"`
+	Retryable bool     `json:"retryable"`
+	Message   string   `json:"message"`
+	Err       error    `json:"-"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatus 错误对应的 HTTP 状态码
+func (e *Error) HTTPStatus() int {
+	return e.Category.HTTPStatus()
+}
+
+// New 创建一个错误，是否可重试取类别的默认值
+func New(category Category, format string, args ...interface{}) *Error {
+	return &Error{Category: category, Retryable: category.retryable(), Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap 给 err 加上类别和上游状态码，错误信息不变
+func Wrap(category Category, status int, err error) *Error {
+	return &Error{Category: category, Status: status, Retryable: category.retryable(), Message: err.Error(), Err: err}
+}
+
+// From 返回 err 链上的 *Error，没有时按网络、文件不存在等通用错误归类，
+// 无法归类的为 Internal。err 为 nil 时返回 nil
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		if error(e) == err {
+			return e
+		}
+		// 保留外层补充的错误信息
+		wrapped := *e
+		wrapped.Message, wrapped.Err = err.Error(), err
+		return &wrapped
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return Wrap(NotFound, 0, err)
+	case errors.Is(err, context.Canceled):
+		return Wrap(Canceled, 0, err)
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return Wrap(Network, 0, err)
+	}
+	return Wrap(Internal, 0, err)
+}
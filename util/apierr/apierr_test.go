@@ -0,0 +1,44 @@
+package apierr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestFrom(t *testing.T) {
+	notFound := New(NotFound, "windowID: %v not exist", "w1")
+	_, dialErr := net.Dial("tcp", "127.0.0.1:1")
+	_, openErr := os.Open("/no/such/file.mp4")
+	tests := []struct {
+		err       error
+		category  Category
+		retryable bool
+		status    int
+	}{
+		{notFound, NotFound, false, http.StatusNotFound},
+		{fmt.Errorf("open: %w", notFound), NotFound, false, http.StatusNotFound},
+		{dialErr, Network, true, http.StatusBadGateway},
+		{openErr, NotFound, false, http.StatusNotFound},
+		{fmt.Errorf("open window w1: %w", context.Canceled), Canceled, false, http.StatusConflict},
+		{fmt.Errorf("open window w1: %w", context.DeadlineExceeded), Network, true, http.StatusBadGateway},
+		{Wrap(ResourceLimit, 453, errors.New("RTSP 453")), ResourceLimit, true, http.StatusServiceUnavailable},
+		{errors.New("something else"), Internal, false, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		e := From(tt.err)
+		if e.Category != tt.category || e.Retryable != tt.retryable || e.HTTPStatus() != tt.status {
+			t.Errorf("From(%v) = %+v", tt.err, e)
+		}
+		if e.Error() != tt.err.Error() {
+			t.Errorf("From(%v) message %q", tt.err, e.Error())
+		}
+	}
+	if From(nil) != nil {
+		t.Error("From(nil) != nil")
+	}
+}
@@ -0,0 +1,565 @@
+// Package pcap 读写 pcap 和 pcapng 抓包文件，只解出 IPv4/IPv6 上的 UDP 和 TCP 报文，
+// 用于从网络组提供的抓包中回放摄像机码流
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/netip"
+	"time"
+)
+
+// 传输层协议
+const (
+	ProtoUDP = "udp"
+	ProtoTCP = "tcp"
+)
+
+// TCP 标志位
+const (
+	FlagFIN = 0x01
+	FlagSYN = 0x02
+	FlagRST = 0x04
+	FlagPSH = 0x08
+	FlagACK = 0x10
+)
+
+// 支持的链路层类型
+const (
+	LinkTypeNull     = 0
+	LinkTypeEthernet = 1
+	LinkTypeRaw      = 101
+	LinkTypeLoop     = 108
+	LinkTypeLinuxSLL = 113
+	LinkTypeIPv4     = 228
+	LinkTypeIPv6     = 229
+	LinkTypeSLL2     = 276
+)
+
+const (
+	magicMicro = 0xa1b2c3d4
+	magicNano  = 0xa1b23c4d
+
+	blockSection     = 0x0a0d0d0a
+	blockInterface   = 0x00000001
+	blockPacket      = 0x00000002
+	blockSimple      = 0x00000003
+	blockEnhanced    = 0x00000006
+	byteOrderMagic   = 0x1a2b3c4d
+	optionEnd        = 0
+	optionTSResol    = 9
+	maxBlockSize     = 16 << 20
+	defaultTSResol   = 6
+	ipProtoTCP       = 6
+	ipProtoUDP       = 17
+	etherTypeIPv4    = 0x0800
+	etherTypeIPv6    = 0x86dd
+	etherTypeVLAN    = 0x8100
+	etherTypeQinQ    = 0x88a8
+	ipv6HopByHop     = 0
+	ipv6Routing      = 43
+	ipv6Fragment     = 44
+	ipv6Destinations = 60
+)
+
+// ErrFormat 不是 pcap 或 pcapng 文件，或文件已损坏
+var ErrFormat = errors.New("pcap: unsupported file format")
+
+// Packet 解出的 UDP 或 TCP 报文
+type Packet struct {
+	Time time.Time
+	// Proto 为 ProtoUDP 或 ProtoTCP
+	Proto    string
+	Src, Dst netip.AddrPort
+	// Seq 和 Flags 只对 TCP 有效
+	Seq     uint32
+	Flags   uint8
+	Payload []byte
+}
+
+// iface pcapng 的接口描述，经典 pcap 只有一个
+type iface struct {
+	linkType uint16
+	// tsResol 时间戳单位，最高位为 0 时为 10^-n 秒，为 1 时为 2^-n 秒
+	tsResol uint8
+}
+
+// Reader 顺序读取抓包文件中的 UDP 和 TCP 报文，分片的 IP 报文和截断的 UDP 报文被跳过
+type Reader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	ng    bool
+	nano  bool
+	ifs   []iface
+	buf   []byte
+}
+
+// NewReader 读取文件头，自动识别 pcap（微秒或纳秒时间戳）和 pcapng
+func NewReader(r io.Reader) (*Reader, error) {
+	var head [24]byte
+	if _, err := io.ReadFull(r, head[:4]); err != nil {
+		return nil, ErrFormat
+	}
+	pr := &Reader{r: r}
+	if binary.BigEndian.Uint32(head[:4]) == blockSection {
+		pr.ng = true
+		if err := pr.readSection(); err != nil {
+			return nil, err
+		}
+		return pr, nil
+	}
+
+	if _, err := io.ReadFull(r, head[4:]); err != nil {
+		return nil, ErrFormat
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(head[:4]) {
+		case magicMicro:
+			pr.order = order
+		case magicNano:
+			pr.order, pr.nano = order, true
+		}
+	}
+	if pr.order == nil {
+		return nil, ErrFormat
+	}
+	linkType := uint16(pr.order.Uint32(head[20:24]))
+	pr.ifs = []iface{{linkType: linkType}}
+	return pr, nil
+}
+
+// Next 返回下一个 UDP 或 TCP 报文，读完时返回 io.EOF。Payload 在下次调用前有效
+func (pr *Reader) Next() (Packet, error) {
+	for {
+		frame, ifc, t, err := pr.nextFrame()
+		if err != nil {
+			return Packet{}, err
+		}
+		if p, ok := decodeLink(frame, ifc.linkType); ok {
+			p.Time = t
+			return p, nil
+		}
+	}
+}
+
+// nextFrame 读取下一个链路层帧
+func (pr *Reader) nextFrame() ([]byte, iface, time.Time, error) {
+	if !pr.ng {
+		var head [16]byte
+		if err := readFull(pr.r, head[:]); err != nil {
+			return nil, iface{}, time.Time{}, err
+		}
+		sec, frac := pr.order.Uint32(head[0:4]), pr.order.Uint32(head[4:8])
+		size := pr.order.Uint32(head[8:12])
+		if size > maxBlockSize {
+			return nil, iface{}, time.Time{}, ErrFormat
+		}
+		frame, err := pr.read(int(size))
+		if err != nil {
+			return nil, iface{}, time.Time{}, err
+		}
+		if !pr.nano {
+			frac *= 1000
+		}
+		return frame, pr.ifs[0], time.Unix(int64(sec), int64(frac)), nil
+	}
+
+	for {
+		typ, body, err := pr.nextBlock()
+		if err != nil {
+			return nil, iface{}, time.Time{}, err
+		}
+		switch typ {
+		case blockSection:
+			// 新的 section 重新声明字节序和接口
+			if err := pr.parseSection(body); err != nil {
+				return nil, iface{}, time.Time{}, err
+			}
+		case blockInterface:
+			if len(body) < 8 {
+				return nil, iface{}, time.Time{}, ErrFormat
+			}
+			ifc := iface{linkType: pr.order.Uint16(body[0:2]), tsResol: defaultTSResol}
+			pr.parseOptions(body[8:], func(code uint16, value []byte) {
+				if code == optionTSResol && len(value) == 1 {
+					ifc.tsResol = value[0]
+				}
+			})
+			pr.ifs = append(pr.ifs, ifc)
+		case blockEnhanced, blockPacket:
+			var id, hi, lo, size uint32
+			if len(body) < 20 {
+				return nil, iface{}, time.Time{}, ErrFormat
+			}
+			if typ == blockEnhanced {
+				id = pr.order.Uint32(body[0:4])
+			} else {
+				id = uint32(pr.order.Uint16(body[0:2]))
+			}
+			hi, lo = pr.order.Uint32(body[4:8]), pr.order.Uint32(body[8:12])
+			size = pr.order.Uint32(body[12:16])
+			if int(id) >= len(pr.ifs) || int(size) > len(body)-20 {
+				return nil, iface{}, time.Time{}, ErrFormat
+			}
+			ifc := pr.ifs[id]
+			return body[20 : 20+size], ifc, timestamp(uint64(hi)<<32|uint64(lo), ifc.tsResol), nil
+		case blockSimple:
+			// 简单包块没有时间戳
+			if len(body) < 4 || len(pr.ifs) == 0 {
+				return nil, iface{}, time.Time{}, ErrFormat
+			}
+			size := int(pr.order.Uint32(body[0:4]))
+			if size > len(body)-4 {
+				size = len(body) - 4
+			}
+			return body[4 : 4+size], pr.ifs[0], time.Time{}, nil
+		}
+	}
+}
+
+// readSection 读取文件开头的 section 头块，块类型已读
+func (pr *Reader) readSection() error {
+	var rest [8]byte
+	if _, err := io.ReadFull(pr.r, rest[:]); err != nil {
+		return ErrFormat
+	}
+	switch uint32(byteOrderMagic) {
+	case binary.LittleEndian.Uint32(rest[4:8]):
+		pr.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(rest[4:8]):
+		pr.order = binary.BigEndian
+	default:
+		return ErrFormat
+	}
+	total := pr.order.Uint32(rest[0:4])
+	if total < 28 || total > maxBlockSize || total%4 != 0 {
+		return ErrFormat
+	}
+	// 跳过块剩余部分：版本、section 长度、选项和结尾的块长度
+	if _, err := pr.read(int(total) - 12); err != nil {
+		return ErrFormat
+	}
+	return nil
+}
+
+// parseSection 处理文件中间的 section 头块
+func (pr *Reader) parseSection(body []byte) error {
+	if len(body) < 4 {
+		return ErrFormat
+	}
+	if pr.order.Uint32(body[0:4]) != byteOrderMagic {
+		// 字节序与上一个 section 不同的文件极少见，不支持
+		return ErrFormat
+	}
+	pr.ifs = nil
+	return nil
+}
+
+// nextBlock 读取下一个 pcapng 块，返回类型和去掉首尾长度字段的块体
+func (pr *Reader) nextBlock() (uint32, []byte, error) {
+	var head [8]byte
+	if err := readFull(pr.r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	typ, total := pr.order.Uint32(head[0:4]), pr.order.Uint32(head[4:8])
+	if total < 12 || total > maxBlockSize || total%4 != 0 {
+		return 0, nil, ErrFormat
+	}
+	body, err := pr.read(int(total) - 8)
+	if err != nil {
+		return 0, nil, err
+	}
+	return typ, body[:len(body)-4], nil
+}
+
+// parseOptions 遍历 pcapng 块的选项
+func (pr *Reader) parseOptions(b []byte, fn func(code uint16, value []byte)) {
+	for len(b) >= 4 {
+		code, size := pr.order.Uint16(b[0:2]), int(pr.order.Uint16(b[2:4]))
+		if code == optionEnd || 4+size > len(b) {
+			return
+		}
+		fn(code, b[4:4+size])
+		b = b[4+(size+3)&^3:]
+	}
+}
+
+// read 读取 n 字节到复用的缓冲区，文件在记录中间结束时按结尾处理
+func (pr *Reader) read(n int) ([]byte, error) {
+	if cap(pr.buf) < n {
+		pr.buf = make([]byte, n)
+	}
+	b := pr.buf[:n]
+	if err := readFull(pr.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readFull 抓包中途被打断的文件以最后一个完整记录结尾
+func readFull(r io.Reader, b []byte) error {
+	_, err := io.ReadFull(r, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return err
+}
+
+// timestamp 按 pcapng 的时间戳单位换算
+func timestamp(ts uint64, resol uint8) time.Time {
+	n := resol & 0x7f
+	if resol&0x80 == 0 {
+		if n > 19 {
+			return time.Time{}
+		}
+		var unit uint64 = 1
+		for i := uint8(0); i < n; i++ {
+			unit *= 10
+		}
+		hi, lo := bits.Mul64(ts%unit, uint64(time.Second))
+		frac, _ := bits.Div64(hi, lo, unit)
+		return time.Unix(int64(ts/unit), int64(frac))
+	}
+	if n > 63 {
+		return time.Time{}
+	}
+	hi, lo := bits.Mul64(ts&(1<<n-1), uint64(time.Second))
+	frac := lo >> n
+	if n > 0 {
+		frac |= hi << (64 - n)
+	}
+	return time.Unix(int64(ts>>n), int64(frac))
+}
+
+// decodeLink 解析链路层到传输层，不是 IPv4/IPv6 上的 UDP 或 TCP 时返回 false
+func decodeLink(b []byte, linkType uint16) (Packet, bool) {
+	switch linkType {
+	case LinkTypeEthernet:
+		if len(b) < 14 {
+			return Packet{}, false
+		}
+		etherType, b := binary.BigEndian.Uint16(b[12:14]), b[14:]
+		for (etherType == etherTypeVLAN || etherType == etherTypeQinQ) && len(b) >= 4 {
+			etherType, b = binary.BigEndian.Uint16(b[2:4]), b[4:]
+		}
+		return decodeEtherType(b, etherType)
+	case LinkTypeNull, LinkTypeLoop:
+		if len(b) < 4 {
+			return Packet{}, false
+		}
+		// 地址族按抓包主机的字节序存放
+		family := binary.LittleEndian.Uint32(b[0:4])
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(b[0:4])
+		}
+		switch family {
+		case 2:
+			return decodeIPv4(b[4:])
+		case 24, 28, 30:
+			return decodeIPv6(b[4:])
+		}
+		return Packet{}, false
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+		return decodeIP(b)
+	case LinkTypeLinuxSLL:
+		if len(b) < 16 {
+			return Packet{}, false
+		}
+		return decodeEtherType(b[16:], binary.BigEndian.Uint16(b[14:16]))
+	case LinkTypeSLL2:
+		if len(b) < 20 {
+			return Packet{}, false
+		}
+		return decodeEtherType(b[20:], binary.BigEndian.Uint16(b[0:2]))
+	}
+	return Packet{}, false
+}
+
+func decodeEtherType(b []byte, etherType uint16) (Packet, bool) {
+	switch etherType {
+	case etherTypeIPv4:
+		return decodeIPv4(b)
+	case etherTypeIPv6:
+		return decodeIPv6(b)
+	}
+	return Packet{}, false
+}
+
+func decodeIP(b []byte) (Packet, bool) {
+	if len(b) == 0 {
+		return Packet{}, false
+	}
+	switch b[0] >> 4 {
+	case 4:
+		return decodeIPv4(b)
+	case 6:
+		return decodeIPv6(b)
+	}
+	return Packet{}, false
+}
+
+func decodeIPv4(b []byte) (Packet, bool) {
+	if len(b) < 20 || b[0]>>4 != 4 {
+		return Packet{}, false
+	}
+	headLen := int(b[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(b[2:4]))
+	if headLen < 20 || total < headLen || total > len(b) {
+		return Packet{}, false
+	}
+	// 分片（MF 标志或偏移不为 0）不重组
+	if binary.BigEndian.Uint16(b[6:8])&0x3fff != 0 {
+		return Packet{}, false
+	}
+	src, _ := netip.AddrFromSlice(b[12:16])
+	dst, _ := netip.AddrFromSlice(b[16:20])
+	return decodeTransport(b[headLen:total], b[9], src, dst)
+}
+
+func decodeIPv6(b []byte) (Packet, bool) {
+	if len(b) < 40 || b[0]>>4 != 6 {
+		return Packet{}, false
+	}
+	total := 40 + int(binary.BigEndian.Uint16(b[4:6]))
+	if total > len(b) {
+		return Packet{}, false
+	}
+	src, _ := netip.AddrFromSlice(b[8:24])
+	dst, _ := netip.AddrFromSlice(b[24:40])
+	next, payload := b[6], b[40:total]
+	for {
+		switch next {
+		case ipv6HopByHop, ipv6Routing, ipv6Destinations:
+			if len(payload) < 8 {
+				return Packet{}, false
+			}
+			size := (int(payload[1]) + 1) * 8
+			if size > len(payload) {
+				return Packet{}, false
+			}
+			next, payload = payload[0], payload[size:]
+		case ipv6Fragment:
+			return Packet{}, false
+		default:
+			return decodeTransport(payload, next, src, dst)
+		}
+	}
+}
+
+func decodeTransport(b []byte, proto byte, src, dst netip.Addr) (Packet, bool) {
+	switch proto {
+	case ipProtoUDP:
+		if len(b) < 8 {
+			return Packet{}, false
+		}
+		size := int(binary.BigEndian.Uint16(b[4:6]))
+		if size < 8 || size > len(b) {
+			return Packet{}, false
+		}
+		return Packet{
+			Proto:   ProtoUDP,
+			Src:     netip.AddrPortFrom(src, binary.BigEndian.Uint16(b[0:2])),
+			Dst:     netip.AddrPortFrom(dst, binary.BigEndian.Uint16(b[2:4])),
+			Payload: b[8:size],
+		}, true
+	case ipProtoTCP:
+		if len(b) < 20 {
+			return Packet{}, false
+		}
+		headLen := int(b[12]>>4) * 4
+		if headLen < 20 || headLen > len(b) {
+			return Packet{}, false
+		}
+		return Packet{
+			Proto:   ProtoTCP,
+			Src:     netip.AddrPortFrom(src, binary.BigEndian.Uint16(b[0:2])),
+			Dst:     netip.AddrPortFrom(dst, binary.BigEndian.Uint16(b[2:4])),
+			Seq:     binary.BigEndian.Uint32(b[4:8]),
+			Flags:   b[13],
+			Payload: b[headLen:],
+		}, true
+	}
+	return Packet{}, false
+}
+
+// Writer 写经典 pcap 文件（纳秒时间戳、裸 IP 链路层），用于从码流生成回归测试用的抓包
+type Writer struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewWriter 写入文件头
+func NewWriter(w io.Writer) (*Writer, error) {
+	var head [24]byte
+	binary.LittleEndian.PutUint32(head[0:4], magicNano)
+	binary.LittleEndian.PutUint16(head[4:6], 2)
+	binary.LittleEndian.PutUint16(head[6:8], 4)
+	binary.LittleEndian.PutUint32(head[16:20], 1<<16)
+	binary.LittleEndian.PutUint32(head[20:24], LinkTypeRaw)
+	if _, err := w.Write(head[:]); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// WritePacket 按 p 的地址封装 IP 和 UDP/TCP 头写入，校验和不计算
+func (pw *Writer) WritePacket(p Packet) error {
+	if p.Src.Addr().Is4() != p.Dst.Addr().Is4() {
+		return fmt.Errorf("pcap: mixed address families %v > %v", p.Src, p.Dst)
+	}
+	var l4 []byte
+	switch p.Proto {
+	case ProtoUDP:
+		l4 = make([]byte, 8, 8+len(p.Payload))
+		binary.BigEndian.PutUint16(l4[4:6], uint16(8+len(p.Payload)))
+	case ProtoTCP:
+		l4 = make([]byte, 20, 20+len(p.Payload))
+		binary.BigEndian.PutUint32(l4[4:8], p.Seq)
+		l4[12] = 5 << 4
+		l4[13] = p.Flags
+		binary.BigEndian.PutUint16(l4[14:16], 0xffff)
+	default:
+		return fmt.Errorf("pcap: unsupported protocol %q", p.Proto)
+	}
+	binary.BigEndian.PutUint16(l4[0:2], p.Src.Port())
+	binary.BigEndian.PutUint16(l4[2:4], p.Dst.Port())
+	l4 = append(l4, p.Payload...)
+
+	var ip []byte
+	proto := byte(ipProtoUDP)
+	if p.Proto == ProtoTCP {
+		proto = ipProtoTCP
+	}
+	if p.Src.Addr().Is4() {
+		ip = make([]byte, 20, 20+len(l4))
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(l4)))
+		ip[8] = 64
+		ip[9] = proto
+		src, dst := p.Src.Addr().As4(), p.Dst.Addr().As4()
+		copy(ip[12:16], src[:])
+		copy(ip[16:20], dst[:])
+	} else {
+		ip = make([]byte, 40, 40+len(l4))
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:6], uint16(len(l4)))
+		ip[6] = proto
+		ip[7] = 64
+		src, dst := p.Src.Addr().As16(), p.Dst.Addr().As16()
+		copy(ip[8:24], src[:])
+		copy(ip[24:40], dst[:])
+	}
+	ip = append(ip, l4...)
+
+	var head [16]byte
+	binary.LittleEndian.PutUint32(head[0:4], uint32(p.Time.Unix()))
+	binary.LittleEndian.PutUint32(head[4:8], uint32(p.Time.Nanosecond()))
+	binary.LittleEndian.PutUint32(head[8:12], uint32(len(ip)))
+	binary.LittleEndian.PutUint32(head[12:16], uint32(len(ip)))
+	pw.buf = append(append(pw.buf[:0], head[:]...), ip...)
+	_, err := pw.w.Write(pw.buf)
+	return err
+}
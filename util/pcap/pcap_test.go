@@ -0,0 +1,133 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestWriterReader(t *testing.T) {
+	start := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	packets := []Packet{
+		{Time: start, Proto: ProtoUDP, Src: netip.MustParseAddrPort("10.0.0.9:5000"), Dst: netip.MustParseAddrPort("10.0.0.2:6000"), Payload: []byte("rtp")},
+		{Time: start.Add(time.Millisecond), Proto: ProtoTCP, Src: netip.MustParseAddrPort("[fd00::9]:554"), Dst: netip.MustParseAddrPort("[fd00::2]:50000"),
+			Seq: 1000, Flags: FlagACK | FlagPSH, Payload: []byte("RTSP/1.0 200 OK\r\n\r\n")},
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range packets {
+		if err := w.WritePacket(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 抓包中途被打断，最后一个记录不完整
+	buf.Write([]byte{1, 2, 3})
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range packets {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Time.Equal(want.Time) || got.Proto != want.Proto || got.Src != want.Src || got.Dst != want.Dst ||
+			got.Seq != want.Seq || got.Flags != want.Flags || string(got.Payload) != string(want.Payload) {
+			t.Errorf("packet = %+v, want %+v", got, want)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Next at the end = %v, want io.EOF", err)
+	}
+
+	if _, err := NewReader(bytes.NewReader([]byte("not a capture file at all"))); err != ErrFormat {
+		t.Errorf("NewReader(text) = %v, want ErrFormat", err)
+	}
+}
+
+// block 按 pcapng 格式封装一个块
+func block(typ uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	b := binary.LittleEndian.AppendUint32(nil, typ)
+	b = binary.LittleEndian.AppendUint32(b, uint32(12+len(body)))
+	b = append(b, body...)
+	return binary.LittleEndian.AppendUint32(b, uint32(12+len(body)))
+}
+
+func TestPcapng(t *testing.T) {
+	var file []byte
+	shb := binary.LittleEndian.AppendUint32(nil, byteOrderMagic)
+	shb = append(shb, 1, 0, 0, 0)
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	file = append(file, block(blockSection, shb)...)
+
+	// 以太网接口，纳秒时间戳
+	idb := binary.LittleEndian.AppendUint16(nil, LinkTypeEthernet)
+	idb = append(idb, 0, 0, 0, 0, 1, 0)
+	idb = append(idb, optionTSResol, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0)
+	file = append(file, block(blockInterface, idb)...)
+
+	// 带 VLAN 标签的 IPv4 UDP 帧
+	var frame bytes.Buffer
+	frame.Write(make([]byte, 12))
+	frame.Write([]byte{0x81, 0x00, 0x00, 0x0a, 0x08, 0x00})
+	ip := []byte{0x45, 0, 0, 0, 0, 0, 0x40, 0, 64, ipProtoUDP, 0, 0, 192, 168, 1, 9, 192, 168, 1, 2}
+	udp := []byte{0x13, 0x88, 0x17, 0x70, 0, 12, 0, 0, 'd', 'a', 't', 'a'}
+	binary.BigEndian.PutUint16(ip[2:4], uint16(len(ip)+len(udp)))
+	frame.Write(ip)
+	frame.Write(udp)
+	// 以太网最小帧的填充不属于 IP 报文
+	frame.Write(make([]byte, 10))
+
+	at := time.Date(2024, 5, 6, 7, 8, 9, 5, time.UTC)
+	ns := uint64(at.UnixNano())
+	epb := binary.LittleEndian.AppendUint32(nil, 0)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ns>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ns))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(frame.Len()))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(frame.Len()))
+	epb = append(epb, frame.Bytes()...)
+	file = append(file, block(blockEnhanced, epb)...)
+
+	r, err := NewReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Proto != ProtoUDP || p.Src.String() != "192.168.1.9:5000" || p.Dst.String() != "192.168.1.2:6000" ||
+		string(p.Payload) != "data" || !p.Time.Equal(at) {
+		t.Errorf("packet = %+v", p)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Next at the end = %v, want io.EOF", err)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	tests := []struct {
+		ts    uint64
+		resol uint8
+		want  time.Time
+	}{
+		{1500000, 6, time.Unix(1, 500000000)},
+		{15, 1, time.Unix(1, 500000000)},
+		{3 << 20, 0x80 | 21, time.Unix(1, 500000000)},
+	}
+	for _, tt := range tests {
+		if got := timestamp(tt.ts, tt.resol); !got.Equal(tt.want) {
+			t.Errorf("timestamp(%v, %#x) = %v, want %v", tt.ts, tt.resol, got, tt.want)
+		}
+	}
+}
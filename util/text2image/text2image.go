@@ -158,6 +158,27 @@ func DrawChineseTextOnImage(img image.Image, positions []image.Point, texts []st
 	return dc.Image(), nil
 }
 
+// DrawSlate 将图像整体压暗，并在中央逐行绘制状态文字
+func DrawSlate(img image.Image, lines []string) (image.Image, error) {
+	dc := gg.NewContextForImage(img)
+	w, h := float64(dc.Width()), float64(dc.Height())
+
+	dc.SetRGBA(0, 0, 0, 0.6)
+	dc.DrawRectangle(0, 0, w, h)
+	dc.Fill()
+
+	if fontFace != nil {
+		dc.SetFontFace(fontFace)
+	}
+	dc.SetRGB(1, 1, 1)
+	lineHeight := dc.FontHeight() * 1.5
+	top := h/2 - lineHeight*float64(len(lines)-1)/2
+	for i, line := range lines {
+		dc.DrawStringAnchored(line, w/2, top+lineHeight*float64(i), 0.5, 0.5)
+	}
+	return dc.Image(), nil
+}
+
 func DrawRectangle(img image.Image, rects []image.Rectangle, colors []color.Color) (image.Image, error) {
 
 	dc := gg.NewContextForImage(img)
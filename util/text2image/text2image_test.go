@@ -73,3 +73,22 @@ func TestDrawRectAndText(t *testing.T) {
 
 	// saveJPEG(resultImg, "rec.jpg")
 }
+
+func TestDrawSlate(t *testing.T) {
+	// 创建一个测试用的图像
+	img := createImage(640, 360, color.RGBA{200, 200, 200, 255})
+
+	// 调用函数进行绘制
+	out, err := DrawSlate(img, []string{"Reconnecting (attempt 1)…", "Offline since 12:00:00"})
+	if err != nil {
+		t.Errorf("Error drawing slate: %v", err)
+	}
+
+	// 压暗后的像素应比原图暗
+	r, _, _, _ := out.At(0, 0).RGBA()
+	if r>>8 >= 200 {
+		t.Errorf("slate was not dimmed, r=%d", r>>8)
+	}
+
+	// saveJPEG(out, "slate.jpg")
+}
@@ -0,0 +1,131 @@
+package tlsutil
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"videoplayer/util/proxyutil"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrPinMismatch 服务端证书链中没有与固定值匹配的公钥
+var ErrPinMismatch = errors.New("tls: no certificate matches the pinned public keys")
+
+// Options TLS 配置，平台接口和 WSP 代理的 HTTP 客户端、WebSocket 拨号器都由它生成
+type Options struct {
+	// CAFile PEM 格式的 CA 证书包，追加到系统根证书之后用于校验服务端
+	CAFile string `json:"ca_file"`
+	// CertFile、KeyFile 双向 TLS 的客户端证书和私钥
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// Pins 固定的服务端公钥（SubjectPublicKeyInfo 的 SHA-256），base64 或 hex，
+	// 可带 "sha256//" 前缀，证书链中任一证书匹配即可
+	Pins []string `json:"pins"`
+	// Insecure 跳过证书链校验，仅用于测试环境；配置了 Pins 时仍然校验公钥
+	Insecure bool `json:"insecure"`
+}
+
+// Config 生成 tls.Config，零值 Options 使用系统根证书校验服务端
+func (o Options) Config() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: o.Insecure}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: read ca file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificate found in %v", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(o.Pins) > 0 {
+		pins, err := parsePins(o.Pins)
+		if err != nil {
+			return nil, err
+		}
+		// VerifyConnection 在跳过链校验时同样会被调用
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				if pins[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] {
+					return nil
+				}
+			}
+			return ErrPinMismatch
+		}
+	}
+	return config, nil
+}
+
+func parsePins(values []string) (map[[sha256.Size]byte]bool, error) {
+	pins := make(map[[sha256.Size]byte]bool)
+	for _, v := range values {
+		s := strings.TrimPrefix(strings.TrimSpace(v), "sha256//")
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || len(b) != sha256.Size {
+			b, err = hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+		}
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("tls: bad pin %q", v)
+		}
+		var pin [sha256.Size]byte
+		copy(pin[:], b)
+		pins[pin] = true
+	}
+	return pins, nil
+}
+
+// Pin 返回证书公钥的固定值，格式和 Options.Pins 一致
+func Pin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256//" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// HTTPClient 使用 config 和 proxy 的 HTTP 客户端，config 为空时使用系统根证书，
+// proxy 为空时按环境变量选择代理
+func HTTPClient(config *tls.Config, proxy proxyutil.Func, timeout time.Duration) *http.Client {
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	transport.Proxy = proxy
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// WebSocketDialer 使用 config 和 proxy 的 WebSocket 拨号器，不修改 websocket.DefaultDialer
+func WebSocketDialer(config *tls.Config, proxy proxyutil.Func) *websocket.Dialer {
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+	return &websocket.Dialer{
+		Proxy:            proxy,
+		HandshakeTimeout: 45 * time.Second,
+		NetDialContext:   (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+		TLSClientConfig:  config,
+	}
+}
@@ -0,0 +1,114 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePEM 写入 PEM 文件并返回路径
+func writePEM(t *testing.T, name, typ string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func get(t *testing.T, o Options, url string) error {
+	config, err := o.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := HTTPClient(config, nil, 5*time.Second).Get(url)
+	if err == nil {
+		res.Body.Close()
+	}
+	return err
+}
+
+func TestVerify(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	cert := srv.Certificate()
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	caFile := writePEM(t, "ca.pem", "CERTIFICATE", cert.Raw)
+
+	tests := []struct {
+		name string
+		opts Options
+		ok   bool
+	}{
+		{"system roots", Options{}, false},
+		{"ca file", Options{CAFile: caFile}, true},
+		{"insecure", Options{Insecure: true}, true},
+		{"pin", Options{CAFile: caFile, Pins: []string{Pin(cert)}}, true},
+		{"hex pin", Options{Insecure: true, Pins: []string{hex.EncodeToString(sum[:])}}, true},
+		{"wrong pin", Options{Insecure: true, Pins: []string{"sha256//" + "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}}, false},
+	}
+	for _, tt := range tests {
+		err := get(t, tt.opts, srv.URL)
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: err = %v", tt.name, err)
+		}
+		if tt.name == "wrong pin" && !errors.Is(err, ErrPinMismatch) {
+			t.Errorf("%v: err = %v, want ErrPinMismatch", tt.name, err)
+		}
+	}
+
+	if _, err := (Options{Pins: []string{"short"}}).Config(); err == nil {
+		t.Error("bad pin accepted")
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "player"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{
+		Insecure: true,
+		CertFile: writePEM(t, "client.pem", "CERTIFICATE", der),
+		KeyFile:  writePEM(t, "client.key", "EC PRIVATE KEY", keyDER),
+	}
+	if err := get(t, opts, srv.URL); err != nil {
+		t.Errorf("with client certificate: %v", err)
+	}
+	if err := get(t, Options{Insecure: true}, srv.URL); err == nil {
+		t.Error("request without client certificate succeeded")
+	}
+}